@@ -18,8 +18,10 @@ package main
 
 import (
 	"encoding/hex"
+	"expvar"
 	"fmt"
 	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil"
 	sdk "github.com/polynetwork/poly-go-sdk"
 	"github.com/polynetwork/poly/common/password"
 	"github.com/polynetwork/btc-vendor-tools/config"
@@ -32,9 +34,13 @@ import (
 	"github.com/polynetwork/btc-vendor-tools/utils"
 	"github.com/polynetwork/btc-vendor-tools/web"
 	"github.com/urfave/cli"
+	"io/ioutil"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"runtime"
+	"strings"
 	"syscall"
 	"time"
 )
@@ -53,6 +59,32 @@ func setupApp() *cli.App {
 		config.RunMode,
 		config.Web,
 	}
+	app.Commands = []cli.Command{
+		{
+			Name:   "encwif",
+			Usage:  "encrypt a btc private key in WIF into a file for the wif key store",
+			Action: encryptWIF,
+			Flags: []cli.Flag{
+				config.KeyFile,
+			},
+		},
+		{
+			Name:   "signd",
+			Usage:  "run a daemon holding only btc keys and signing for the vendor process over a unix socket",
+			Action: runSignd,
+			Flags: []cli.Flag{
+				config.Socket,
+			},
+		},
+		{
+			Name:   "keystore",
+			Usage:  "run a process holding only the btc key and signing sighashes for the remote key store",
+			Action: runKeyStore,
+			Flags: []cli.Flag{
+				config.KeyStoreListen,
+			},
+		},
+	}
 	app.Before = func(context *cli.Context) error {
 		cores := context.GlobalInt(config.GoMaxProcs.Name)
 		runtime.GOMAXPROCS(cores)
@@ -103,18 +135,11 @@ func run(ctx *cli.Context) {
 			log.Errorf("failed to save config: %v", err)
 		}
 	} else {
-		switch conf.ConfigBitcoinNet {
-		case "regtest":
-			config.BtcNetParam = &chaincfg.RegressionNetParams
-		case "test":
-			config.BtcNetParam = &chaincfg.TestNet3Params
-		case "main":
-			config.BtcNetParam = &chaincfg.MainNetParams
-		default:
-			log.Fatalf("wrong net type: %s", conf.ConfigBitcoinNet)
+		if err = setNetParam(conf.ConfigBitcoinNet); err != nil {
+			log.Fatalf("%v", err)
 			os.Exit(1)
 		}
-		if err = utils.SetUpPoly(poly, conf.PolyJsonRpcAddress); err != nil {
+		if err = utils.SetUpPoly(poly, conf.GetPolyRpcAddrs()...); err != nil {
 			panic(err)
 		}
 	}
@@ -133,44 +158,54 @@ func run(ctx *cli.Context) {
 		opwd = []byte(conf.WalletPwd)
 	}
 
-	var bpwd []byte
-	if pwd := ctx.GlobalString(config.BtcWalletPwd.Name); pwd != "" {
-		bpwd = []byte(pwd)
-	} else if conf.BtcWalletPwd == "" {
-		fmt.Println("enter your btc wallet password:")
-		if bpwd, err = password.GetPassword(); err != nil {
-			log.Fatalf("password is not found in config file and enter password failed: %v", err)
+	rcs := conf.GetRedeems()
+	if len(rcs) == 0 {
+		log.Errorf("no redeem in config")
+		os.Exit(1)
+	}
+	rbs := make([][]byte, len(rcs))
+	for i, rc := range rcs {
+		if rbs[i], err = hex.DecodeString(rc.Redeem); err != nil {
+			log.Errorf("failed to decode redeem %s: %v", rc.Redeem, err)
 			os.Exit(1)
 		}
-		fmt.Println("done")
-	} else {
-		bpwd = []byte(conf.BtcWalletPwd)
 	}
-
-	rb, err := hex.DecodeString(conf.Redeem)
+	bpwds, err := getBtcPwds(ctx, conf)
 	if err != nil {
-		log.Errorf("failed to decode redeem: %v", err)
+		log.Fatalf("%v", err)
+		os.Exit(1)
+	}
+
+	if err := startMetrics(conf.GetMetricsAddr()); err != nil {
+		log.Fatalf("failed to start metrics server: %v", err)
 		os.Exit(1)
 	}
 
 	switch mode {
-	case "all":
-		txchan := make(chan *utils.ToSignItem, 100)
-		if err := startObserver(conf, txchan, poly, rb, vdb); err != nil {
+	case "all", "shadow":
+		queued := make(chan struct{}, 1)
+		if err := startObserver(conf, queued, poly, rbs, vdb); err != nil {
 			log.Fatalf("failed to start ob: %v", err)
 			os.Exit(1)
 		}
-		if _, err := startSigner(conf, txchan, poly, vdb, rb, opwd, bpwd); err != nil {
+		s, err := startSigner(conf, queued, poly, vdb, opwd, bpwds, mode == "shadow")
+		if err != nil {
 			log.Fatalf("failed to start signer: %v", err)
 			os.Exit(1)
 		}
+		if conf.AdminToken != "" || len(conf.Operators) > 0 {
+			if err := startServer(conf, s); err != nil {
+				log.Fatalf("Failed to start rest service: %v", err)
+				os.Exit(1)
+			}
+		}
 	case "onlyob":
-		if err := startObserver(conf, nil, poly, rb, vdb); err != nil {
+		if err := startObserver(conf, nil, poly, rbs, vdb); err != nil {
 			log.Fatalf("failed to start ob: %v", err)
 			os.Exit(1)
 		}
 	case "onlysig":
-		s, err := startSigner(conf, nil, poly, vdb, rb, opwd, bpwd)
+		s, err := startSigner(conf, nil, poly, vdb, opwd, bpwds, false)
 		if err != nil {
 			log.Fatalf("failed to start signer: %v", err)
 			os.Exit(1)
@@ -187,39 +222,272 @@ func run(ctx *cli.Context) {
 	waitToExit()
 }
 
+func setNetParam(net string) error {
+	switch net {
+	case "regtest":
+		config.BtcNetParam = &chaincfg.RegressionNetParams
+	case "test":
+		config.BtcNetParam = &chaincfg.TestNet3Params
+	case "main":
+		config.BtcNetParam = &chaincfg.MainNetParams
+	default:
+		return fmt.Errorf("wrong net type: %s", net)
+	}
+	return nil
+}
+
+// getBtcPwds returns passwords of btc keys of all redeems in conf, or the shared
+// secret for remote key stores and signd. The password flag is only for the key of Redeem.
+func getBtcPwds(ctx *cli.Context, conf *config.Config) ([][]byte, error) {
+	rcs := conf.GetRedeems()
+	bpwds := make([][]byte, len(rcs))
+	var err error
+	for i, rc := range rcs {
+		if pwd := ctx.GlobalString(config.BtcWalletPwd.Name); pwd != "" && i == 0 && conf.Redeem != "" {
+			bpwds[i] = []byte(pwd)
+		} else if rc.BtcWalletPwd == "" {
+			if rc.KeyStore == signer.KEYSTORE_SIGND || rc.KeyStore == signer.KEYSTORE_REMOTE {
+				fmt.Printf("enter the %s secret for redeem %s:\n", rc.KeyStore, rc.Redeem)
+			} else {
+				fmt.Printf("enter your btc wallet password of %s:\n", rc.BtcPrivkFile)
+			}
+			if bpwds[i], err = password.GetPassword(); err != nil {
+				return nil, fmt.Errorf("password is not found in config file and enter password failed: %v", err)
+			}
+			fmt.Println("done")
+		} else {
+			bpwds[i] = []byte(rc.BtcWalletPwd)
+		}
+	}
+	return bpwds, nil
+}
+
+// loadRedeems opens key stores of all redeems in conf with bpwds from getBtcPwds.
+func loadRedeems(conf *config.Config, poly *sdk.PolySdk, bpwds [][]byte) ([]*signer.Redeem, error) {
+	rcs := conf.GetRedeems()
+	rds := make([]*signer.Redeem, len(rcs))
+	for i, rc := range rcs {
+		rb, err := hex.DecodeString(rc.Redeem)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode redeem %s: %v", rc.Redeem, err)
+		}
+		ks, err := signer.NewKeyStore(poly, rc.KeyStore, rc.BtcPrivkFile, rc.KeyStoreAddr, bpwds[i], rb)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open key store for redeem %s: %v", rc.Redeem, err)
+		}
+		if rds[i], err = signer.NewRedeem(rb, ks); err != nil {
+			return nil, fmt.Errorf("failed to new redeem %s: %v", rc.Redeem, err)
+		}
+		rds[i].SetRetiring(rc.Retiring)
+	}
+	return rds, nil
+}
+
+// runSignd runs a daemon holding only btc keys, see signer.SignDaemon.
+func runSignd(ctx *cli.Context) error {
+	log.InitLog(ctx.GlobalInt(config.LogLevelFlag.Name), log.Stdout)
+	conf, err := config.NewConfig(ctx.GlobalString(config.ConfigFile.Name))
+	if err != nil {
+		return err
+	}
+	if err = setNetParam(conf.ConfigBitcoinNet); err != nil {
+		return err
+	}
+	secret, err := getSigndSecret(conf)
+	if err != nil {
+		return err
+	}
+	bpwds, err := getBtcPwds(ctx, conf)
+	if err != nil {
+		return err
+	}
+	rds, err := loadRedeems(conf, sdk.NewPolySdk(), bpwds)
+	if err != nil {
+		return err
+	}
+	d, err := signer.NewSignDaemon(rds, secret)
+	if err != nil {
+		return err
+	}
+	for _, rd := range rds {
+		log.Infof("[signd] signing for redeem %s", rd.Key)
+	}
+	return d.Serve(ctx.String(config.Socket.Name))
+}
+
+// runKeyStore serves the btc key of the redeems in config to the remote key store of the
+// vendor process, see signer.ServeKeyStore. All redeems must use the same key.
+func runKeyStore(ctx *cli.Context) error {
+	log.InitLog(ctx.GlobalInt(config.LogLevelFlag.Name), log.Stdout)
+	conf, err := config.NewConfig(ctx.GlobalString(config.ConfigFile.Name))
+	if err != nil {
+		return err
+	}
+	if err = setNetParam(conf.ConfigBitcoinNet); err != nil {
+		return err
+	}
+	secret, err := getSigndSecret(conf)
+	if err != nil {
+		return err
+	}
+	bpwds, err := getBtcPwds(ctx, conf)
+	if err != nil {
+		return err
+	}
+	var ks signer.KeyStore
+	for i, rc := range conf.GetRedeems() {
+		if rc.KeyStore == signer.KEYSTORE_REMOTE || rc.KeyStore == signer.KEYSTORE_SIGND {
+			return fmt.Errorf("key of redeem %s is not held here but in %s", rc.Redeem, rc.KeyStore)
+		}
+		k, err := signer.NewKeyStore(sdk.NewPolySdk(), rc.KeyStore, rc.BtcPrivkFile, "", bpwds[i], nil)
+		if err != nil {
+			return fmt.Errorf("failed to open key store for redeem %s: %v", rc.Redeem, err)
+		}
+		if ks == nil {
+			ks = k
+		} else if !ks.PubKey().IsEqual(k.PubKey()) {
+			return fmt.Errorf("redeems use different keys, run one key store for each")
+		}
+	}
+	if ks == nil {
+		return fmt.Errorf("no redeem in config")
+	}
+	return signer.ServeKeyStore(ks, secret, ctx.String(config.KeyStoreListen.Name))
+}
+
+// getSigndSecret returns SigndSecret in conf, or asks for it, for signd and the key store.
+func getSigndSecret(conf *config.Config) ([]byte, error) {
+	if conf.SigndSecret != "" {
+		return []byte(conf.SigndSecret), nil
+	}
+	fmt.Println("enter the secret shared with the vendor process:")
+	secret, err := password.GetPassword()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret: %v", err)
+	}
+	return secret, nil
+}
+
+func encryptWIF(ctx *cli.Context) error {
+	fmt.Println("enter your btc private key in WIF:")
+	raw, err := password.GetPassword()
+	if err != nil {
+		return fmt.Errorf("failed to read WIF: %v", err)
+	}
+	wif, err := btcutil.DecodeWIF(strings.TrimSpace(string(raw)))
+	if err != nil {
+		return fmt.Errorf("wrong WIF: %v", err)
+	}
+	fmt.Println("enter password to encrypt it:")
+	pwd, err := password.GetConfirmedPassword()
+	if err != nil {
+		return fmt.Errorf("failed to read password: %v", err)
+	}
+	data, err := signer.EncryptWIF(wif, pwd)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt WIF: %v", err)
+	}
+	file := ctx.String(config.KeyFile.Name)
+	if err = ioutil.WriteFile(file, data, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %v", file, err)
+	}
+	fmt.Printf("encrypted WIF is written to %s\n", file)
+	return nil
+}
+
 func startServer(conf *config.Config, s *signer.Signer) error {
-	serv := service.NewService(s)
-	restServer := restful.InitRestServer(serv, conf.RestPort, conf.ObServerAddr)
+	serv := service.NewService(s, conf.AdminToken, conf.Operators, conf.ApprovalPageTmpl)
+	restServer := restful.InitRestServer(serv, conf.RestPort, conf.ObServerAddr, conf.AdminAddr)
 	go restServer.Start()
 
 	return nil
 }
 
-func startObserver(conf *config.Config, txchan chan *utils.ToSignItem, poly *sdk.PolySdk, rb []byte,
+// startMetrics serves /debug/vars on its own listener, so metrics are there in every
+// mode, whether the REST server runs or not.
+func startMetrics(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	go func() {
+		if err := http.Serve(l, mux); err != nil {
+			log.Errorf("[startMetrics] metrics server stopped: %v", err)
+		}
+	}()
+	log.Infof("[startMetrics] serving metrics at http://%s/debug/vars", addr)
+	return nil
+}
+
+func startObserver(conf *config.Config, queued chan struct{}, poly *sdk.PolySdk, rbs [][]byte,
 	vdb *db.VendorDB) error {
-	ob := observer.NewObserver(poly, txchan, conf.PolyObLoopWaitTime, rb, conf.WatchingKeyToSign,
-		conf.ConfigDBPath, conf.SignerAddr, conf.CircleToSaveHeight, conf.PolyStartHeight, vdb)
+	var quorum *observer.Quorum
+	if conf.PolyQuorum > 0 {
+		var err error
+		if quorum, err = observer.NewQuorum(conf.GetPolyRpcAddrs(), conf.PolyQuorum); err != nil {
+			return err
+		}
+	}
+	ob := observer.NewObserver(poly, queued, conf.PolyObLoopWaitTime, rbs, conf.WatchingKeyToSign,
+		conf.ConfigDBPath, conf.SignerAddr, conf.CircleToSaveHeight, conf.PolyStartHeight, conf.PolyCatchUpWorkers,
+		quorum, vdb)
+	if err := ob.LoadCheckpoint(); err != nil {
+		return err
+	}
 	go ob.Listen()
 
 	return nil
 }
 
-func startSigner(conf *config.Config, txchan chan *utils.ToSignItem, poly *sdk.PolySdk, vdb *db.VendorDB, rb, opwd, bpwd []byte) (*signer.Signer, error) {
+// startSigner starts a signer serving all redeems in conf, and bpwds are passwords of their
+// btc keys in the same order.
+func startSigner(conf *config.Config, queued chan struct{}, poly *sdk.PolySdk, vdb *db.VendorDB, opwd []byte,
+	bpwds [][]byte, shadow bool) (*signer.Signer, error) {
 	acct, err := utils.GetAccountByPassword(poly, conf.WalletFile, opwd)
 	if err != nil {
 		return nil, fmt.Errorf("[startSigner] GetAccountByPassword failed: %v", err)
 	}
-	s, err := signer.NewSigner(conf.BtcPrivkFile, bpwd, txchan, acct, poly, rb, vdb)
+	rds, err := loadRedeems(conf, poly, bpwds)
+	if err != nil {
+		return nil, fmt.Errorf("[startSigner] %v", err)
+	}
+	for _, rd := range rds {
+		log.Infof("[startSigner] serving redeem %s", rd.Key)
+	}
+	s, err := signer.NewSigner(rds, queued, acct, poly, vdb, conf.SignPolicy)
 	if err != nil {
 		return nil, fmt.Errorf("[startSigner] failed to new a signer: %v", err)
 	}
-	if txchan != nil {
+	if shadow {
+		log.Infof("[startSigner] running in shadow mode, nothing would be sent to poly")
+		s.SetShadow(true)
+	} else {
+		go s.Submitting()
+	}
+	go s.Releasing()
+	go s.Rotating()
+	go s.WatchingFreezeFile()
+	go watchFreezeSignal(s)
+	if queued != nil {
 		go s.Signing()
 	}
 
 	return s, nil
 }
 
+// watchFreezeSignal freezes signing on SIGUSR1.
+func watchFreezeSignal(s *signer.Signer) {
+	sc := make(chan os.Signal, 1)
+	signal.Notify(sc, syscall.SIGUSR1)
+	for range sc {
+		if err := s.Freeze("signal SIGUSR1"); err != nil {
+			log.Errorf("failed to freeze: %v", err)
+		}
+	}
+}
+
 func waitToExit() {
 	exit := make(chan bool, 0)
 	sc := make(chan os.Signal, 1)
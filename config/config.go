@@ -25,6 +25,9 @@ import (
 	"time"
 )
 
+// where metrics are served if MetricsAddr is not set
+const DEFAULT_METRICS_ADDR = "127.0.0.1:50074"
+
 var (
 	SleepTime   time.Duration    = 10 * time.Second
 	BtcNetParam *chaincfg.Params = nil
@@ -48,6 +51,108 @@ type Config struct {
 	ObServerAddr       string
 	PolyStartHeight    uint32
 	WebServerPort      string
+	SignPolicy         *SignPolicy
+	AdminToken         string
+	AdminAddr          string
+	// operator name => token. Admin actions recorded with an operator, like approving
+	// a transaction, take the operator from the token and don't accept AdminToken
+	Operators map[string]string
+	// template of the approval page, web/views/approvals.tmpl if not set
+	ApprovalPageTmpl string
+	// number of blocks fetched at the same time when the observer is far behind poly
+	PolyCatchUpWorkers int
+	// more poly endpoints besides PolyJsonRpcAddress to fail over to
+	PolyJsonRpcAddresses []string
+	// if positive, events of a block are taken only if the same from this many endpoints
+	PolyQuorum int
+	// more redeems served besides Redeem
+	Redeems []*RedeemConf
+	// where the btc key of Redeem is, see RedeemConf
+	BtcKeyStore     string
+	BtcKeyStoreAddr string
+	// shared secret between signd and the vendor process, only used by signd. The
+	// vendor process takes it as BtcWalletPwd of redeems with "signd" key store
+	SigndSecret string
+	// listen address of /debug/vars, served in every mode
+	MetricsAddr string
+}
+
+// RedeemConf is a multisig redeem served by us with the btc key in it.
+type RedeemConf struct {
+	Redeem       string
+	BtcPrivkFile string
+	BtcWalletPwd string
+	// the old redeem when rotating our key. It's served until nothing is locked
+	// under it on poly, and then retired
+	Retiring bool
+	// "wallet" (default) for a poly wallet file and "wif" for an encrypted WIF file in
+	// BtcPrivkFile, or "remote" and "signd" for a key held by the process at KeyStoreAddr
+	KeyStore     string
+	KeyStoreAddr string
+}
+
+// GetRedeems returns all redeems we serve, starting with Redeem if it's set.
+func (this *Config) GetRedeems() []*RedeemConf {
+	res := make([]*RedeemConf, 0, len(this.Redeems)+1)
+	if this.Redeem != "" {
+		res = append(res, &RedeemConf{
+			Redeem:       this.Redeem,
+			BtcPrivkFile: this.BtcPrivkFile,
+			BtcWalletPwd: this.BtcWalletPwd,
+			KeyStore:     this.BtcKeyStore,
+			KeyStoreAddr: this.BtcKeyStoreAddr,
+		})
+	}
+	return append(res, this.Redeems...)
+}
+
+// GetPolyRpcAddrs returns all poly endpoints, starting with PolyJsonRpcAddress if it's set.
+func (this *Config) GetPolyRpcAddrs() []string {
+	res := make([]string, 0, len(this.PolyJsonRpcAddresses)+1)
+	if this.PolyJsonRpcAddress != "" {
+		res = append(res, this.PolyJsonRpcAddress)
+	}
+	return append(res, this.PolyJsonRpcAddresses...)
+}
+
+// GetMetricsAddr returns where metrics are served, DEFAULT_METRICS_ADDR if MetricsAddr is not set.
+func (this *Config) GetMetricsAddr() string {
+	if this.MetricsAddr == "" {
+		return DEFAULT_METRICS_ADDR
+	}
+	return this.MetricsAddr
+}
+
+// SignPolicy is checked by signer before signing any transaction. A zero value
+// for any limit means no limit. Amounts are in satoshi and count the value not sent
+// back to our multisig, fee included.
+type SignPolicy struct {
+	MaxTxValue     uint64
+	MaxHourlyValue uint64
+	MaxDailyValue  uint64
+	MaxFee         uint64
+	MinOutputs     int
+	MaxOutputs     int
+	// transactions sending out more than this wait for approval of the operator
+	ApprovalValue uint64
+	// transactions sending out more than DelayValue are signed DelayMinutes after captured,
+	// unless vetoed through REST or by a file named by the unsigned txid in VetoDir
+	DelayValue   uint64
+	DelayMinutes int
+	VetoDir      string
+	// signing is frozen as soon as this file exists
+	FreezeFile string
+	// refuse transactions paying any address or script hash listed in this file
+	DenyListFile string
+	// refuse a transaction whose fee rate is bigger than FeeRate registered on poly
+	// times this factor or smaller than FeeRate divided by it
+	MaxFeeRateDeviation float64
+	// if set, POST every transaction passing other checks to this URL and sign only
+	// if it answers {"result": "allow"}. When it times out after WebhookTimeout seconds
+	// or fails, sign anyway if WebhookFailOpen is true, otherwise refuse
+	WebhookURL      string
+	WebhookTimeout  int
+	WebhookFailOpen bool
 }
 
 func NewConfig(file string) (*Config, error) {
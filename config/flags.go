@@ -58,10 +58,28 @@ var (
 
 	RunMode = cli.StringFlag{
 		Name:  "mode",
-		Usage: "the mode for this tool, eg: onlysig, onlyob, all",
+		Usage: "the mode for this tool, eg: onlysig, onlyob, all, shadow",
 		Value: "all",
 	}
 
+	KeyFile = cli.StringFlag{
+		Name:  "keyfile",
+		Usage: "the encrypted WIF file to write.",
+		Value: "./btcprivk.wif",
+	}
+
+	Socket = cli.StringFlag{
+		Name:  "socket",
+		Usage: "the unix socket for signd to listen on.",
+		Value: "./signd.sock",
+	}
+
+	KeyStoreListen = cli.StringFlag{
+		Name:  "listen",
+		Usage: "where the key store serves, unix:///path/to/sock or host:port for HTTP.",
+		Value: "unix://./keystore.sock",
+	}
+
 	Web = cli.IntFlag{
 		Name:  "web",
 		Usage: "start web server or not: 1(Y), 0(N)",
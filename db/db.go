@@ -20,18 +20,36 @@ import (
 	"bytes"
 	"container/list"
 	"encoding/binary"
+	"fmt"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
 	"github.com/polynetwork/btc-vendor-tools/utils"
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/util"
 	"sort"
 	"sync"
+	"time"
 )
 
 const CACHE_SIZE = 100
 
 var (
-	tx_prefix       = []byte("tx")
-	totalnum_prefix = []byte("total")
+	tx_prefix         = []byte("tx")
+	totalnum_prefix   = []byte("total")
+	rejected_prefix   = []byte("rejected")
+	spent_prefix      = []byte("spent")
+	outpoint_prefix   = []byte("outpoint")
+	override_prefix   = []byte("override")
+	outbox_prefix     = []byte("outbox")
+	shadow_prefix     = []byte("shadow")
+	approval_prefix   = []byte("approval")
+	delay_prefix      = []byte("delay")
+	frozen_prefix     = []byte("frozen")
+	retired_prefix    = []byte("retired")
+	queue_prefix      = []byte("queue")
+	quarantine_prefix = []byte("quarantine")
+	freeze_key        = []byte("freeze")
+	checkpoint_key    = []byte("checkpoint")
 )
 
 type VendorDB struct {
@@ -179,6 +197,470 @@ func (v *VendorDB) ReadFirstBatch() (utils.SavedItemArr, error) {
 	return arr, nil
 }
 
+func (v *VendorDB) PutRejectedTx(txHash []byte, item *utils.RejectedItem) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	val, err := item.Serialize()
+	if err != nil {
+		return err
+	}
+	return v.db.Put(append(rejected_prefix, txHash...), val, nil)
+}
+
+func (v *VendorDB) GetRejectedTx(txHash []byte) (*utils.RejectedItem, error) {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+
+	val, err := v.db.Get(append(rejected_prefix, txHash...), nil)
+	if err != nil {
+		return nil, err
+	}
+	item := &utils.RejectedItem{}
+	if err = item.Deserialize(val); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// PutSpent records that we signed a transaction sending val satoshi out at time t.
+func (v *VendorDB) PutSpent(txHash []byte, t time.Time, val uint64) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	raw := make([]byte, 8)
+	binary.BigEndian.PutUint64(raw, val)
+	return v.db.Put(getSpentKey(t, txHash), raw, nil)
+}
+
+// GetSpentSince sums up all value recorded by PutSpent from time t until now.
+func (v *VendorDB) GetSpentSince(t time.Time) (uint64, error) {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+
+	sum := uint64(0)
+	iter := v.db.NewIterator(&util.Range{
+		Start: getSpentKey(t, nil),
+		Limit: util.BytesPrefix(spent_prefix).Limit,
+	}, nil)
+	for iter.Next() {
+		sum += binary.BigEndian.Uint64(iter.Value())
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return 0, err
+	}
+	return sum, nil
+}
+
+func getSpentKey(t time.Time, txHash []byte) []byte {
+	key := make([]byte, len(spent_prefix)+8, len(spent_prefix)+8+len(txHash))
+	copy(key, spent_prefix)
+	binary.BigEndian.PutUint64(key[len(spent_prefix):], uint64(t.UnixNano()))
+	return append(key, txHash...)
+}
+
+// PutSignedOutpoints maps every outpoint spent by a transaction we signed to its unsigned txid.
+// An outpoint already mapped keeps its first spender, even if the operator allowed a conflict.
+func (v *VendorDB) PutSignedOutpoints(txHash []byte, ops []*wire.OutPoint) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	batch := new(leveldb.Batch)
+	for _, op := range ops {
+		key := getOutpointKey(op)
+		ok, err := v.db.Has(key, nil)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			batch.Put(key, txHash)
+		}
+	}
+	return v.db.Write(batch, nil)
+}
+
+// GetOutpointSpender returns the unsigned txid we signed to spend op, or nil if we never did.
+func (v *VendorDB) GetOutpointSpender(op *wire.OutPoint) ([]byte, error) {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+
+	val, err := v.db.Get(getOutpointKey(op), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	}
+	return val, err
+}
+
+// PutConflictOverride allows signing the tx even if it spends outpoints we signed for another tx.
+func (v *VendorDB) PutConflictOverride(txHash []byte) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	return v.db.Put(append(override_prefix, txHash...), []byte{1}, nil)
+}
+
+func (v *VendorDB) IsConflictOverridden(txHash []byte) (bool, error) {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+
+	return v.db.Has(append(override_prefix, txHash...), nil)
+}
+
+func getOutpointKey(op *wire.OutPoint) []byte {
+	key := make([]byte, len(outpoint_prefix)+len(op.Hash)+4)
+	copy(key, outpoint_prefix)
+	copy(key[len(outpoint_prefix):], op.Hash[:])
+	binary.BigEndian.PutUint32(key[len(outpoint_prefix)+len(op.Hash):], op.Index)
+	return key
+}
+
+// PutOutbox saves our signatures for a tx waiting to be sent to poly.
+func (v *VendorDB) PutOutbox(txHash []byte, item *utils.OutboxItem) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	val, err := item.Serialize()
+	if err != nil {
+		return err
+	}
+	return v.db.Put(append(outbox_prefix, txHash...), val, nil)
+}
+
+func (v *VendorDB) GetOutbox(txHash []byte) (*utils.OutboxItem, error) {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+
+	val, err := v.db.Get(append(outbox_prefix, txHash...), nil)
+	if err != nil {
+		return nil, err
+	}
+	item := &utils.OutboxItem{}
+	if err = item.Deserialize(val); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func (v *VendorDB) DelOutbox(txHash []byte) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	return v.db.Delete(append(outbox_prefix, txHash...), nil)
+}
+
+// GetAllOutbox returns all items in outbox keyed by unsigned txid.
+func (v *VendorDB) GetAllOutbox() (map[chainhash.Hash]*utils.OutboxItem, error) {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+
+	res := make(map[chainhash.Hash]*utils.OutboxItem)
+	iter := v.db.NewIterator(util.BytesPrefix(outbox_prefix), nil)
+	for iter.Next() {
+		item := &utils.OutboxItem{}
+		if err := item.Deserialize(iter.Value()); err != nil {
+			iter.Release()
+			return nil, err
+		}
+		var key chainhash.Hash
+		copy(key[:], iter.Key()[len(outbox_prefix):])
+		res[key] = item
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// PutShadowTx records signatures we would have sent to poly in shadow mode.
+func (v *VendorDB) PutShadowTx(txHash []byte, item *utils.OutboxItem) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	val, err := item.Serialize()
+	if err != nil {
+		return err
+	}
+	return v.db.Put(append(shadow_prefix, txHash...), val, nil)
+}
+
+func (v *VendorDB) GetShadowTx(txHash []byte) (*utils.OutboxItem, error) {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+
+	val, err := v.db.Get(append(shadow_prefix, txHash...), nil)
+	if err != nil {
+		return nil, err
+	}
+	item := &utils.OutboxItem{}
+	if err = item.Deserialize(val); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// PutApproval saves a tx waiting for approval of the operator, or the decision made for it.
+func (v *VendorDB) PutApproval(txHash []byte, item *utils.HeldItem) error {
+	return v.putHeld(approval_prefix, txHash, item)
+}
+
+func (v *VendorDB) GetApproval(txHash []byte) (*utils.HeldItem, error) {
+	return v.getHeld(approval_prefix, txHash)
+}
+
+// GetAllApprovals returns all approval records keyed by unsigned txid.
+func (v *VendorDB) GetAllApprovals() (map[chainhash.Hash]*utils.HeldItem, error) {
+	return v.getAllHeld(approval_prefix)
+}
+
+// PutDelayed saves a tx waiting for its veto window to pass, or the result of it.
+func (v *VendorDB) PutDelayed(txHash []byte, item *utils.HeldItem) error {
+	return v.putHeld(delay_prefix, txHash, item)
+}
+
+func (v *VendorDB) GetDelayed(txHash []byte) (*utils.HeldItem, error) {
+	return v.getHeld(delay_prefix, txHash)
+}
+
+// GetAllDelayed returns all delayed records keyed by unsigned txid.
+func (v *VendorDB) GetAllDelayed() (map[chainhash.Hash]*utils.HeldItem, error) {
+	return v.getAllHeld(delay_prefix)
+}
+
+// PutFrozen saves a tx captured while signing is frozen, or the decision made for it.
+func (v *VendorDB) PutFrozen(txHash []byte, item *utils.HeldItem) error {
+	return v.putHeld(frozen_prefix, txHash, item)
+}
+
+// GetAllFrozen returns all transactions captured while signing is frozen keyed by unsigned txid.
+func (v *VendorDB) GetAllFrozen() (map[chainhash.Hash]*utils.HeldItem, error) {
+	return v.getAllHeld(frozen_prefix)
+}
+
+// SetFreeze saves whether signing is frozen so that it stays frozen after restart.
+func (v *VendorDB) SetFreeze(frozen bool) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if frozen {
+		return v.db.Put(freeze_key, []byte{1}, nil)
+	}
+	return v.db.Delete(freeze_key, nil)
+}
+
+func (v *VendorDB) IsFrozen() (bool, error) {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+
+	return v.db.Has(freeze_key, nil)
+}
+
+// PutRetired records that the key of redeem is retired after key rotation.
+func (v *VendorDB) PutRetired(redeemKey string) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	return v.db.Put(append(retired_prefix, []byte(redeemKey)...), []byte{1}, nil)
+}
+
+func (v *VendorDB) IsRetired(redeemKey string) (bool, error) {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+
+	return v.db.Has(append(retired_prefix, []byte(redeemKey)...), nil)
+}
+
+// PutQueue saves items captured at height h into the queue to the signer, and moves
+// the checkpoint of the observer to h with hash of the block in the same batch.
+func (v *VendorDB) PutQueue(h uint32, hash []byte, items []*utils.ToSignItem) error {
+	if len(hash) != chainhash.HashSize {
+		return fmt.Errorf("wrong length %d of block hash", len(hash))
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	batch := new(leveldb.Batch)
+	for _, item := range items {
+		val, err := item.Serialize()
+		if err != nil {
+			return err
+		}
+		txid := utils.GetUnsignedTxHash(item.Mtx)
+		batch.Put(getQueueKey(h, txid[:]), val)
+	}
+	val := make([]byte, 4, 4+len(hash))
+	binary.BigEndian.PutUint32(val, h)
+	batch.Put(checkpoint_key, append(val, hash...))
+	return v.db.Write(batch, nil)
+}
+
+// Requeue puts items back into the queue at the heights they were captured, without
+// moving the checkpoint.
+func (v *VendorDB) Requeue(items []*utils.ToSignItem) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	batch := new(leveldb.Batch)
+	for _, item := range items {
+		val, err := item.Serialize()
+		if err != nil {
+			return err
+		}
+		txid := utils.GetUnsignedTxHash(item.Mtx)
+		batch.Put(getQueueKey(item.Height, txid[:]), val)
+	}
+	return v.db.Write(batch, nil)
+}
+
+// GetQueue returns keys and items in the queue in order of capture height.
+func (v *VendorDB) GetQueue() ([][]byte, []*utils.ToSignItem, error) {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+
+	keys := make([][]byte, 0)
+	items := make([]*utils.ToSignItem, 0)
+	iter := v.db.NewIterator(util.BytesPrefix(queue_prefix), nil)
+	for iter.Next() {
+		item := &utils.ToSignItem{}
+		if err := item.Deserialize(iter.Value()); err != nil {
+			iter.Release()
+			return nil, nil, err
+		}
+		keys = append(keys, append([]byte{}, iter.Key()...))
+		items = append(items, item)
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return nil, nil, err
+	}
+	return keys, items, nil
+}
+
+// AckQueue removes the item of key from the queue after the signer handled it.
+func (v *VendorDB) AckQueue(key []byte) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	return v.db.Delete(key, nil)
+}
+
+// GetCheckpoint returns the height the observer has handled and hash of the block, which
+// is nil if saved by older versions. leveldb.ErrNotFound is returned if there is none.
+func (v *VendorDB) GetCheckpoint() (uint32, []byte, error) {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+
+	val, err := v.db.Get(checkpoint_key, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	switch len(val) {
+	case 4:
+		return binary.BigEndian.Uint32(val), nil, nil
+	case 4 + chainhash.HashSize:
+		return binary.BigEndian.Uint32(val), val[4:], nil
+	default:
+		return 0, nil, fmt.Errorf("checkpoint corrupted: wrong length %d", len(val))
+	}
+}
+
+// PutQuarantined saves the No.idx notify of poly tx which failed to be decoded.
+func (v *VendorDB) PutQuarantined(idx uint32, item *utils.QuarantinedItem) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	val, err := item.Serialize()
+	if err != nil {
+		return err
+	}
+	key := make([]byte, len(quarantine_prefix)+4, len(quarantine_prefix)+4+len(item.TxHash)+4)
+	copy(key, quarantine_prefix)
+	binary.BigEndian.PutUint32(key[len(quarantine_prefix):], item.Height)
+	key = append(key, []byte(item.TxHash)...)
+	key = append(key, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(key[len(key)-4:], idx)
+	return v.db.Put(key, val, nil)
+}
+
+// GetAllQuarantined returns quarantined notifies in order of height.
+func (v *VendorDB) GetAllQuarantined() ([]*utils.QuarantinedItem, error) {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+
+	res := make([]*utils.QuarantinedItem, 0)
+	iter := v.db.NewIterator(util.BytesPrefix(quarantine_prefix), nil)
+	for iter.Next() {
+		item := &utils.QuarantinedItem{}
+		if err := item.Deserialize(iter.Value()); err != nil {
+			iter.Release()
+			return nil, err
+		}
+		res = append(res, item)
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func getQueueKey(h uint32, txHash []byte) []byte {
+	key := make([]byte, len(queue_prefix)+4, len(queue_prefix)+4+len(txHash))
+	copy(key, queue_prefix)
+	binary.BigEndian.PutUint32(key[len(queue_prefix):], h)
+	return append(key, txHash...)
+}
+
+func (v *VendorDB) putHeld(prefix, txHash []byte, item *utils.HeldItem) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	val, err := item.Serialize()
+	if err != nil {
+		return err
+	}
+	return v.db.Put(append(prefix, txHash...), val, nil)
+}
+
+func (v *VendorDB) getHeld(prefix, txHash []byte) (*utils.HeldItem, error) {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+
+	val, err := v.db.Get(append(prefix, txHash...), nil)
+	if err != nil {
+		return nil, err
+	}
+	item := &utils.HeldItem{}
+	if err = item.Deserialize(val); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func (v *VendorDB) getAllHeld(prefix []byte) (map[chainhash.Hash]*utils.HeldItem, error) {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+
+	res := make(map[chainhash.Hash]*utils.HeldItem)
+	iter := v.db.NewIterator(util.BytesPrefix(prefix), nil)
+	for iter.Next() {
+		item := &utils.HeldItem{}
+		if err := item.Deserialize(iter.Value()); err != nil {
+			iter.Release()
+			return nil, err
+		}
+		var key chainhash.Hash
+		copy(key[:], iter.Key()[len(prefix):])
+		res[key] = item
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
 func (v *VendorDB) Close() error {
 	return v.db.Close()
 }
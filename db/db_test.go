@@ -23,6 +23,7 @@ import (
 	"github.com/btcsuite/btcd/wire"
 	"github.com/polynetwork/btc-vendor-tools/utils"
 	"github.com/stretchr/testify/assert"
+	"github.com/syndtr/goleveldb/leveldb"
 	"os"
 	"testing"
 	"time"
@@ -175,3 +176,150 @@ func TestVendorDB_SetTxDone(t *testing.T) {
 	res, _ := db.GetSignedTx(txid[:])
 	assert.Equal(t, true, res.Done)
 }
+
+func TestVendorDB_PutRejectedTx(t *testing.T) {
+	db, _ := NewVendorDB("./temp")
+	defer os.RemoveAll("./temp")
+
+	arr := getTxArr(1)
+	txid := arr[0].Item.Mtx.TxHash()
+	err := db.PutRejectedTx(txid[:], &utils.RejectedItem{
+		Item:         arr[0].Item,
+		TimeReceived: arr[0].TimeReceived,
+		Reason:       "max_tx_value",
+	})
+	assert.NoError(t, err)
+
+	res, err := db.GetRejectedTx(txid[:])
+	assert.NoError(t, err)
+	assert.Equal(t, "max_tx_value", res.Reason)
+	assert.Equal(t, true, res.TimeReceived.Equal(arr[0].TimeReceived))
+}
+
+func TestVendorDB_GetSpentSince(t *testing.T) {
+	db, _ := NewVendorDB("./temp")
+	defer os.RemoveAll("./temp")
+
+	now := time.Now()
+	assert.NoError(t, db.PutSpent([]byte{1}, now.Add(-2*time.Hour), 100))
+	assert.NoError(t, db.PutSpent([]byte{2}, now.Add(-30*time.Minute), 20))
+	assert.NoError(t, db.PutSpent([]byte{3}, now, 3))
+
+	sum, err := db.GetSpentSince(now.Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(23), sum)
+
+	sum, err = db.GetSpentSince(now.Add(-24 * time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(123), sum)
+}
+
+func TestVendorDB_GetOutpointSpender(t *testing.T) {
+	db, _ := NewVendorDB("./temp")
+	defer os.RemoveAll("./temp")
+
+	ops := []*wire.OutPoint{wire.NewOutPoint(&chainhash.Hash{1}, 0), wire.NewOutPoint(&chainhash.Hash{1}, 1)}
+	assert.NoError(t, db.PutSignedOutpoints([]byte{9}, ops))
+
+	res, err := db.GetOutpointSpender(ops[1])
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{9}, res)
+
+	assert.NoError(t, db.PutSignedOutpoints([]byte{8}, ops[1:]))
+	res, _ = db.GetOutpointSpender(ops[1])
+	assert.Equal(t, []byte{9}, res)
+
+	res, err = db.GetOutpointSpender(wire.NewOutPoint(&chainhash.Hash{1}, 2))
+	assert.NoError(t, err)
+	assert.Nil(t, res)
+
+	ok, err := db.IsConflictOverridden([]byte{8})
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.NoError(t, db.PutConflictOverride([]byte{8}))
+	ok, _ = db.IsConflictOverridden([]byte{8})
+	assert.True(t, ok)
+}
+
+func TestVendorDB_GetAllOutbox(t *testing.T) {
+	db, _ := NewVendorDB("./temp")
+	defer os.RemoveAll("./temp")
+
+	arr := getTxArr(2)
+	for _, v := range arr {
+		txid := v.Item.Mtx.TxHash()
+		assert.NoError(t, db.PutOutbox(txid[:], &utils.OutboxItem{
+			Item:         v.Item,
+			TxHash:       txid,
+			Sigs:         [][]byte{{1}},
+			TimeReceived: v.TimeReceived,
+		}))
+	}
+	res, err := db.GetAllOutbox()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(res))
+	txid := arr[0].Item.Mtx.TxHash()
+	assert.Equal(t, txid, res[txid].TxHash)
+
+	assert.NoError(t, db.DelOutbox(txid[:]))
+	_, err = db.GetOutbox(txid[:])
+	assert.Error(t, err)
+	res, _ = db.GetAllOutbox()
+	assert.Equal(t, 1, len(res))
+}
+
+func TestVendorDB_PutQueue(t *testing.T) {
+	db, _ := NewVendorDB("./temp")
+	defer os.RemoveAll("./temp")
+
+	_, _, err := db.GetCheckpoint()
+	assert.Equal(t, leveldb.ErrNotFound, err)
+	arr := getTxArr(3)
+	hash := chainhash.Hash{1}
+	assert.NoError(t, db.PutQueue(20, hash[:], []*utils.ToSignItem{arr[0].Item}))
+	assert.NoError(t, db.PutQueue(10, hash[:], []*utils.ToSignItem{arr[1].Item, arr[2].Item}))
+	assert.Error(t, db.PutQueue(11, nil, nil))
+	h, res, err := db.GetCheckpoint()
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(10), h)
+	assert.Equal(t, hash[:], res)
+
+	keys, items, err := db.GetQueue()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(items))
+	assert.Equal(t, arr[0].Item.Mtx.TxHash(), items[2].Mtx.TxHash())
+
+	assert.NoError(t, db.AckQueue(keys[0]))
+	assert.NoError(t, db.PutQueue(21, hash[:], nil))
+	_, items, _ = db.GetQueue()
+	assert.Equal(t, 2, len(items))
+	h, _, _ = db.GetCheckpoint()
+	assert.Equal(t, uint32(21), h)
+
+	assert.NoError(t, db.db.Put(checkpoint_key, []byte{1, 2}, nil))
+	_, _, err = db.GetCheckpoint()
+	assert.Error(t, err)
+	assert.NotEqual(t, leveldb.ErrNotFound, err)
+}
+
+func TestVendorDB_PutQuarantined(t *testing.T) {
+	db, _ := NewVendorDB("./temp")
+	defer os.RemoveAll("./temp")
+
+	now := time.Unix(time.Now().Unix(), 0)
+	items := []*utils.QuarantinedItem{
+		{Height: 20, TxHash: "aa", States: []byte(`["makeBtcTx"]`), Reason: "bad", TimeReceived: now},
+		{Height: 10, TxHash: "bb", States: []byte(`["btcTxToRelay",1]`), Reason: "worse", TimeReceived: now},
+	}
+	assert.NoError(t, db.PutQuarantined(0, items[0]))
+	assert.NoError(t, db.PutQuarantined(1, items[1]))
+	assert.NoError(t, db.PutQuarantined(1, items[1]))
+
+	res, err := db.GetAllQuarantined()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(res))
+	assert.Equal(t, items[1].TxHash, res[0].TxHash)
+	assert.Equal(t, items[1].States, res[0].States)
+	assert.Equal(t, items[0].Reason, res[1].Reason)
+	assert.True(t, now.Equal(res[1].TimeReceived))
+}
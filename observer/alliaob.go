@@ -22,17 +22,18 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"github.com/btcsuite/btcd/wire"
 	sdk "github.com/polynetwork/poly-go-sdk"
-	"github.com/polynetwork/poly-go-sdk/client"
 	"github.com/polynetwork/poly-go-sdk/common"
 	"github.com/polynetwork/btc-vendor-tools/config"
 	"github.com/polynetwork/btc-vendor-tools/db"
 	"github.com/polynetwork/btc-vendor-tools/log"
 	httpcom "github.com/polynetwork/btc-vendor-tools/rest/http/common"
+	"github.com/polynetwork/btc-vendor-tools/rest/http/restful"
 	"github.com/polynetwork/btc-vendor-tools/utils"
+	"github.com/syndtr/goleveldb/leveldb"
 	"io/ioutil"
 	"net/http"
+	"os"
 	"path"
 	"strconv"
 	"strings"
@@ -40,48 +41,57 @@ import (
 )
 
 type Observer struct {
-	txchan            chan *utils.ToSignItem
+	queued            chan struct{}
 	poly              *sdk.PolySdk
 	loopWaitTime      int64
 	WatchingKeyToSign string
-	hashKey           string
+	hashKeys          map[string]bool
 	dbPath            string
 	waitingCircle     uint32
 	obCli             *ObCli
 	startHeight       uint32
+	lastHeight        uint32
+	catchUpWorkers    int
+	quorum            *Quorum
 	vdb               *db.VendorDB
 }
 
-func NewObserver(poly *sdk.PolySdk, txchan chan *utils.ToSignItem, loopWaitTime int64, redeem []byte, watchingKeyToSign,
-	dbPath, signerAddr string, circle, startHeight uint32, vdb *db.VendorDB) *Observer {
+func NewObserver(poly *sdk.PolySdk, queued chan struct{}, loopWaitTime int64, redeems [][]byte, watchingKeyToSign,
+	dbPath, signerAddr string, circle, startHeight uint32, catchUpWorkers int, quorum *Quorum,
+	vdb *db.VendorDB) *Observer {
+	hashKeys := make(map[string]bool)
+	for _, rb := range redeems {
+		hashKeys[utils.GetUtxoKey(rb)] = true
+	}
 	return &Observer{
 		poly:              poly,
-		txchan:            txchan,
+		queued:            queued,
 		WatchingKeyToSign: watchingKeyToSign,
-		hashKey:           utils.GetUtxoKey(redeem),
+		hashKeys:          hashKeys,
 		loopWaitTime:      loopWaitTime,
 		dbPath:            dbPath,
 		waitingCircle:     circle,
-		obCli: func(txchan chan *utils.ToSignItem) *ObCli {
-			if txchan == nil {
+		obCli: func(queued chan struct{}) *ObCli {
+			if queued == nil {
 				return NewObCli(signerAddr)
 			} else {
 				return nil
 			}
-		}(txchan),
-		startHeight: startHeight,
-		vdb:         vdb,
+		}(queued),
+		startHeight:    startHeight,
+		catchUpWorkers: catchUpWorkers,
+		quorum:         quorum,
+		vdb:            vdb,
 	}
 }
 
 func (ob *Observer) Listen() {
-	log.Infof("starting observing with hash-key %s", ob.hashKey)
-
-	top := ob.getLastHeight()
-	if ob.startHeight != 0 {
-		top = ob.startHeight
+	for k := range ob.hashKeys {
+		log.Infof("starting observing with hash-key %s", k)
 	}
-	log.Infof("[Observer] get start height %d from checkpoint or db, check once %d seconds", top, ob.loopWaitTime)
+
+	top := ob.lastHeight
+	log.Infof("[Observer] get start height %d from checkpoint or config, check once %d seconds", top, ob.loopWaitTime)
 	tick := time.NewTicker(time.Second * time.Duration(ob.loopWaitTime))
 	defer tick.Stop()
 
@@ -97,32 +107,41 @@ func (ob *Observer) Listen() {
 				continue
 			}
 
-			if newTop-top <= 0 {
+			if newTop <= top {
 				continue
 			}
+			metricPolyHeight.Set(int64(newTop))
 			h := top + 1
 			log.Tracef("[Observer] watch from %d to %d", h, newTop)
-			for h <= newTop {
-				events, err := ob.poly.GetSmartContractEventByBlock(h)
-				if err != nil {
-					switch err.(type) {
-					case client.PostErr:
-						log.Errorf("[Observer] GetSmartContractEventByBlock failed, retry after 10 sec: %v", err)
-					default:
-						log.Errorf("[Observer] not supposed to happen: %v", err)
+			workers := 1
+			var progress *syncProgress
+			if newTop-top > CATCH_UP_GAP && ob.catchUpWorkers > 1 {
+				workers = ob.catchUpWorkers
+				progress = newSyncProgress(h, newTop)
+			}
+			for slot := range prefetch(h, newTop, workers, ob.getEvents) {
+				items := ob.checkEvents(<-slot, h)
+				if len(items) > 0 {
+					ob.deliver(h, items)
+					lastRecorded = h
+				} else if h-lastRecorded >= ob.waitingCircle {
+					if err := ob.setLastHeight(h); err != nil {
+						log.Errorf("[Observer] failed to set height: %v", err)
 					}
-					utils.Wait(config.SleepTime)
-					continue
+					lastRecorded = h
+				}
+				toSign += len(items)
+				metricHeight.Set(int64(h))
+				if progress != nil {
+					progress.handled(h)
 				}
-				signCnt := ob.checkEvents(events, h)
-				toSign += signCnt
 				h++
 			}
 			if toSign > 0 {
 				log.Infof("[Observer] btc tx to sig: total %d transactions captured this time", toSign)
 			}
 			top = newTop
-			if toSign > 0 || top-lastRecorded >= ob.waitingCircle {
+			if top-lastRecorded >= ob.waitingCircle {
 				if err := ob.setLastHeight(top); err != nil {
 					log.Errorf("[Observer] failed to set height: %v", err)
 				}
@@ -132,72 +151,62 @@ func (ob *Observer) Listen() {
 	}
 }
 
-func (ob *Observer) checkEvents(events []*common.SmartContactEvent, h uint32) int {
-	toSign := 0
+// checkEvents returns items to sign captured from events at height h, and marks txs signed on poly as done.
+// Notifies are filtered by redeem key first, and those of ours which are not in the expected shape
+// are quarantined in db instead.
+func (ob *Observer) checkEvents(events []*common.SmartContactEvent, h uint32) []*utils.ToSignItem {
+	toSign := make([]*utils.ToSignItem, 0)
 	for _, e := range events {
-		for _, n := range e.Notify {
+		for idx, n := range e.Notify {
 			states, ok := n.States.([]interface{})
+			if !ok || len(states) == 0 {
+				continue
+			}
+			name, ok := states[0].(string)
 			if !ok {
 				continue
 			}
 
-			mtx := wire.NewMsgTx(wire.TxVersion)
-			if name, ok := states[0].(string); ok && name == utils.TO_SIGN_TX_KEY && states[1].(string) == ob.hashKey {
-				txb, err := hex.DecodeString(states[2].(string))
+			if name != utils.TO_SIGN_TX_KEY && name != utils.SIGNED_TX_KEY {
+				continue
+			}
+			// notifies of other vendors are none of our business, malformed or not
+			if key, ok := notifyRedeemKey(name, states); ok && !ob.hashKeys[key] {
+				continue
+			}
+
+			switch name {
+			case utils.TO_SIGN_TX_KEY:
+				nt, err := decodeMakeBtcTx(states)
 				if err != nil {
-					log.Errorf("[Observer] wrong hex-string of tx from chain, not supposed to happen: %v", err)
-					continue
-				}
-				if err = mtx.BtcDecode(bytes.NewBuffer(txb), wire.ProtocolVersion, wire.LatestEncoding); err != nil {
-					log.Errorf("[Observer] failed to decode btc transaction from chain, not supposed to happen: "+
-						"%v", err)
+					ob.quarantine(h, e.TxHash, uint32(idx), states, err)
 					continue
 				}
-				amts := make([]uint64, 0)
-				for _, v := range states[3].([]interface{}) {
-					amts = append(amts, uint64(v.(float64)))
-				}
 				item := &utils.ToSignItem{
-					Mtx:  mtx,
-					Amts: amts,
-				}
-				if ob.txchan == nil {
-				RETRY:
-					if err := ob.obCli.SendToSign(item); err != nil {
-						log.Errorf("[Observer] failed to call rpc: %v", err)
-						utils.Wait(config.SleepTime)
-						goto RETRY
-					}
-				} else {
-					ob.txchan <- item
+					Mtx:       nt.Mtx,
+					Amts:      nt.Amts,
+					Height:    h,
+					RedeemKey: nt.RedeemKey,
 				}
-				toSign++
-				mtx = mtx.Copy()
-				for _, v := range mtx.TxIn {
-					v.SignatureScript = nil
-				}
-				txid := mtx.TxHash()
-				log.Infof("[Observer] captured one tx (unsigned txid: %s) when height is %d", txid.String(), h)
-			} else if ok && name == utils.SIGNED_TX_KEY && states[5].(string) == ob.hashKey {
-				txb, err := hex.DecodeString(states[3].(string))
+				toSign = append(toSign, item)
+				txid := utils.GetUnsignedTxHash(nt.Mtx)
+				metricCaptured.Add(item.RedeemKey, 1)
+				log.Infof("[Observer] captured one tx (unsigned txid: %s) of redeem %s when height is %d",
+					txid.String(), item.RedeemKey, h)
+			case utils.SIGNED_TX_KEY:
+				nt, err := decodeBtcTxToRelay(states)
 				if err != nil {
-					log.Errorf("[Observer] wrong hex-string of tx from chain, not supposed to happen: %v", err)
-					continue
-				}
-				if err = mtx.BtcDecode(bytes.NewBuffer(txb), wire.ProtocolVersion, wire.LatestEncoding); err != nil {
-					log.Errorf("[Observer] failed to decode btc transaction from chain, not supposed to happen: "+
-						"%v", err)
+					ob.quarantine(h, e.TxHash, uint32(idx), states, err)
 					continue
 				}
-				for _, in := range mtx.TxIn {
-					in.SignatureScript = nil
-				}
-				txid := mtx.TxHash()
+				txid := utils.GetUnsignedTxHash(nt.Mtx)
 				if err = ob.vdb.SetTxDone(txid[:]); err != nil {
 					log.Errorf("[Observer] failed to change tx %s status: %v", txid.String(), err)
 					continue
 				}
-				log.Infof("[Observer] tx (unsigned tx key: %s) is signed", txid.String())
+				metricDone.Add(nt.RedeemKey, 1)
+				log.Infof("[Observer] tx (unsigned tx key: %s) of redeem %s is signed", txid.String(),
+					nt.RedeemKey)
 			}
 		}
 	}
@@ -205,34 +214,136 @@ func (ob *Observer) checkEvents(events []*common.SmartContactEvent, h uint32) in
 	return toSign
 }
 
-func (ob *Observer) getLastHeight() uint32 {
-	val, err := ioutil.ReadFile(path.Join(ob.dbPath, "last_height"))
+// quarantine keeps the malformed No.idx notify of poly tx txHash in db for inspection, so that
+// one bad event never stops the observer.
+func (ob *Observer) quarantine(h uint32, txHash string, idx uint32, states []interface{}, reason error) {
+	metricQuarantined.Add(states[0].(string), 1)
+	log.Errorf("[Observer][ALERT] notify No.%d of poly tx %s at height %d quarantined: %v", idx, txHash, h, reason)
+	raw, err := json.Marshal(states)
 	if err != nil {
-		return 0
+		raw = []byte(fmt.Sprintf("%v", states))
 	}
+	if err = ob.vdb.PutQuarantined(idx, &utils.QuarantinedItem{
+		Height:       h,
+		TxHash:       txHash,
+		States:       raw,
+		Reason:       reason.Error(),
+		TimeReceived: time.Now(),
+	}); err != nil {
+		log.Errorf("[Observer] failed to quarantine notify No.%d of poly tx %s: %v", idx, txHash, err)
+	}
+}
 
-	h := string(val)
-	h = strings.TrimFunc(h, func(r rune) bool {
-		if r == ' ' || r == '\n' {
-			return true
+// deliver hands items captured at height h to the signer and moves the checkpoint to h.
+// When the signer runs in the same process, items are queued in db in the same batch
+// with the checkpoint, so nothing captured is lost if we crash before it's signed.
+func (ob *Observer) deliver(h uint32, items []*utils.ToSignItem) {
+	if ob.queued == nil {
+		for _, item := range items {
+		RETRY:
+			if err := ob.obCli.SendToSign(item); err != nil {
+				if _, ok := err.(RejectedError); ok {
+					log.Warnf("[Observer] signer refused tx at height %d: %v", h, err)
+					continue
+				}
+				log.Errorf("[Observer] failed to call rpc: %v", err)
+				utils.Wait(config.SleepTime)
+				goto RETRY
+			}
 		}
-		return false
-	})
+		if err := ob.setLastHeight(h); err != nil {
+			log.Errorf("[Observer] failed to set height: %v", err)
+		}
+		return
+	}
+	for {
+		err := ob.checkpoint(h, items)
+		if err == nil {
+			break
+		}
+		log.Errorf("[Observer] failed to queue %d txs captured at height %d, retry after 10 sec: %v",
+			len(items), h, err)
+		utils.Wait(config.SleepTime)
+	}
+	select {
+	case ob.queued <- struct{}{}:
+	default:
+	}
+}
 
-	height, err := strconv.ParseUint(h, 10, 32)
-	if err != nil {
-		return 0
+// LoadCheckpoint finds the height to start from, which must be called before Listen. The
+// checkpoint in db is checked against the block hash on poly, and the last_height file of
+// older versions is moved into db. A missing or corrupted checkpoint is an error unless
+// the start height is given in config.
+func (ob *Observer) LoadCheckpoint() error {
+	h, hash, err := ob.vdb.GetCheckpoint()
+	if ob.startHeight != 0 {
+		if err == nil && h != ob.startHeight {
+			// blocks are skipped or scanned again, which is only wanted by hand
+			log.Errorf("[Observer][ALERT] start from height %d in config instead of checkpoint %d in db, "+
+				"set PolyStartHeight back to 0 once started", ob.startHeight, h)
+		}
+		ob.lastHeight = ob.startHeight
+		return nil
+	}
+	switch err {
+	case nil:
+	case leveldb.ErrNotFound:
+		if h, err = ob.migrateLastHeight(); err != nil {
+			return err
+		}
+		log.Infof("[Observer] checkpoint %d moved from file into db", h)
+	default:
+		return fmt.Errorf("failed to read checkpoint from db, set PolyStartHeight to start anyway: %v", err)
+	}
+
+	if hash != nil {
+		bh, err := ob.poly.GetBlockHash(h)
+		if err != nil {
+			return fmt.Errorf("failed to get hash of block %d to check the checkpoint: %v", h, err)
+		}
+		if !bytes.Equal(bh[:], hash) {
+			return fmt.Errorf("block %d in checkpoint is %x, not %x on poly, set PolyStartHeight to start anyway",
+				h, hash, bh[:])
+		}
 	}
+	ob.lastHeight = h
+	return nil
+}
 
-	return uint32(height)
+// migrateLastHeight moves the height in the last_height file written by older versions into db.
+func (ob *Observer) migrateLastHeight() (uint32, error) {
+	file := path.Join(ob.dbPath, "last_height")
+	val, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return 0, fmt.Errorf("no checkpoint found in db or %s, set PolyStartHeight for the first start", file)
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %v", file, err)
+	}
+	h, err := strconv.ParseUint(strings.TrimSpace(string(val)), 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("checkpoint in %s corrupted, set PolyStartHeight to start anyway: %v", file, err)
+	}
+	if err = ob.setLastHeight(uint32(h)); err != nil {
+		return 0, fmt.Errorf("failed to save checkpoint into db: %v", err)
+	}
+	if err = os.Rename(file, file+".migrated"); err != nil {
+		log.Warnf("[Observer] failed to rename %s after migration: %v", file, err)
+	}
+	return uint32(h), nil
 }
 
 func (ob *Observer) setLastHeight(h uint32) error {
-	if err := ioutil.WriteFile(path.Join(ob.dbPath, "last_height"), []byte(strconv.FormatUint(uint64(h), 10)),
-		0644); err != nil {
-		return err
+	return ob.checkpoint(h, nil)
+}
+
+// checkpoint moves the checkpoint to h with the block hash and queues items in the same batch.
+func (ob *Observer) checkpoint(h uint32, items []*utils.ToSignItem) error {
+	hash, err := ob.poly.GetBlockHash(h)
+	if err != nil {
+		return fmt.Errorf("failed to get hash of block %d: %v", h, err)
 	}
-	return nil
+	return ob.vdb.PutQueue(h, hash[:], items)
 }
 
 type ObCli struct {
@@ -270,6 +381,16 @@ func (cli *ObCli) sendRequest(addr string, data []byte) ([]byte, error) {
 	return body, nil
 }
 
+// RejectedError means the signer refused to sign the item for good, so there is no
+// point to send it again.
+type RejectedError struct {
+	Desc string
+}
+
+func (err RejectedError) Error() string {
+	return fmt.Sprintf("rejected by signer: %s", err.Desc)
+}
+
 func (cli *ObCli) SendToSign(item *utils.ToSignItem) error {
 	rawItem, err := item.Serialize()
 	if err != nil {
@@ -292,6 +413,9 @@ func (cli *ObCli) SendToSign(item *utils.ToSignItem) error {
 	if err != nil {
 		return fmt.Errorf("failed to unmarshal resp to json: %v", err)
 	}
+	if resp.Error == restful.SIGN_REJECTED {
+		return RejectedError{Desc: resp.Desc}
+	}
 	if resp.Error != 0 || resp.Desc != "SUCCESS" {
 		return fmt.Errorf("response shows failure: %s", resp.Desc)
 	}
@@ -22,7 +22,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/btcsuite/btcd/wire"
-	utils3 "github.com/polynetwork/btc-relayer/utils"
+	"github.com/polynetwork/btc-vendor-tools/db"
+	"github.com/polynetwork/btc-vendor-tools/log"
 	sdk "github.com/polynetwork/poly-go-sdk"
 	"github.com/polynetwork/poly-go-sdk/client"
 	"github.com/polynetwork/poly-go-sdk/common"
@@ -31,9 +32,8 @@ import (
 	"github.com/polynetwork/poly/native/service/governance/side_chain_manager"
 	common3 "github.com/polynetwork/poly/native/service/header_sync/common"
 	"github.com/polynetwork/poly/native/service/utils"
-	"github.com/polynetwork/btc-vendor-tools/log"
-	utils2 "github.com/polynetwork/btc-vendor-tools/utils"
 	"github.com/stretchr/testify/assert"
+	"github.com/syndtr/goleveldb/leveldb"
 	"io/ioutil"
 	"math/big"
 	"net/http"
@@ -47,45 +47,46 @@ var (
 	redeem = "552102dec9a415b6384ec0a9331d0cdf02020f0f1e5731c327b86e2b5a92455a289748210365b1066bcfa21987c3e207b92e309b95ca6bee5f1133cf04d6ed4ed265eafdbc21031104e387cd1a103c27fdc8a52d5c68dec25ddfb2f574fbdca405edfd8c5187de21031fdb4b44a9f20883aff505009ebc18702774c105cb04b1eecebcb294d404b1cb210387cda955196cc2b2fc0adbbbac1776f8de77b563c6d2a06a77d96457dc3d0d1f2102dd7767b6a7cc83693343ba721e0f5f4c7b4b8d85eeb7aec20d227625ec0f59d321034ad129efdab75061e8d4def08f5911495af2dae6d3e9a4b6e7aeb5186fa432fc57ae"
 )
 
-func TestNewObserver(t *testing.T) {
+// newTestObserver returns an observer of poly at addr with its files in ./temp.
+func newTestObserver(t *testing.T, addr string, loopWaitTime int64) *Observer {
 	poly := sdk.NewPolySdk()
-	poly.NewRpcClient().SetAddress("")
+	poly.NewRpcClient().SetAddress(addr)
 	rb, _ := hex.DecodeString(redeem)
-	txc := make(chan *utils2.ToSignItem)
-	NewObserver(poly, txc, 10, rb, "", "", "./", "", 10, 1)
+	vdb, err := db.NewVendorDB("./temp/db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewObserver(poly, make(chan struct{}, 1), loopWaitTime, [][]byte{rb}, "makeBtcTx", "./temp", "",
+		10, 1, 1, nil, vdb)
 }
 
-func TestObserver_Listen(t *testing.T) {
-	poly := sdk.NewPolySdk()
-	poly.NewRpcClient().SetAddress(startMockPolyServer())
+func TestNewObserver(t *testing.T) {
+	defer os.RemoveAll("./temp")
+	ob := newTestObserver(t, "", 10)
+	assert.Equal(t, 1, len(ob.hashKeys))
+	assert.Nil(t, ob.obCli)
+}
 
-	txc := make(chan *utils2.ToSignItem)
-	rb, _ := hex.DecodeString(redeem)
-	ob := NewObserver(poly, txc, 1, rb, "makeBtcTx", "regtest", "./", "", 10, 1)
+func TestObserver_Listen(t *testing.T) {
+	defer os.RemoveAll("./temp")
 	log.InitLog(0, log.Stdout)
+	ob := newTestObserver(t, startMockPolyServer(), 1)
 
-	res := make([]*utils2.ToSignItem, 0)
 	go ob.Listen()
-	go func() {
-		for v := range txc {
-			res = append(res, v)
-		}
-	}()
-
 	time.Sleep(time.Second * 5)
-	assert.Equal(t, 2, len(res))
+	// the mock poly stays at height 1
+	_, res, err := ob.vdb.GetQueue()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(res))
 	assert.Equal(t, "fdbbbd59b96ccbfe82ab5f501d22ef39a816103c187233f435836523c054a2f3", res[0].Mtx.TxHash().String())
+	h, _, err := ob.vdb.GetCheckpoint()
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(1), h)
 }
 
 func TestObserver_checkEvents(t *testing.T) {
-	defer os.RemoveAll("./last_height")
-
-	rb, _ := hex.DecodeString(redeem)
-	poly := sdk.NewPolySdk()
-	poly.NewRpcClient().SetAddress(startMockPolyServer())
-
-	txc := make(chan *utils2.ToSignItem, 10)
-	ob := NewObserver(poly, txc, 10, rb, "makeBtcTx", "", "./", "", 10, 1)
+	defer os.RemoveAll("./temp")
+	ob := newTestObserver(t, startMockPolyServer(), 10)
 
 	log.InitLog(2, log.Stdout)
 	events := make([]*common.SmartContactEvent, 1)
@@ -106,38 +107,76 @@ func TestObserver_checkEvents(t *testing.T) {
 		Notify: notifys,
 	}
 
-	cntTx := ob.checkEvents(events, 1)
-	assert.Equal(t, 1, int(cntTx))
-	txItem := <-txc
+	items := ob.checkEvents(events, 1)
+	assert.Equal(t, 1, len(items))
+	txItem := items[0]
 	assert.Equal(t, "fdbbbd59b96ccbfe82ab5f501d22ef39a816103c187233f435836523c054a2f3", txItem.Mtx.TxHash().String())
-}
 
-func TestSigner_setLastHeight(t *testing.T) {
-	defer os.RemoveAll("./last_height")
-
-	rb, _ := hex.DecodeString(redeem)
-	poly := sdk.NewPolySdk()
-
-	txc := make(chan *utils2.ToSignItem, 10)
-	ob := NewObserver(poly, txc, 10, rb, "makeBtcTx", "", "./", "", 10, 1)
-
-	if ob.getLastHeight() != 0 {
-		t.Fatal("should be 0")
+	// malformed notifies of other vendors are skipped, only ours are quarantined
+	events[0].Notify = []*common.NotifyEventInfo{
+		{States: []interface{}{"makeBtcTx", "0000000000000000000000000000000000000000", "zz"}},
+		{States: []interface{}{"btcTxToRelay", 1.0, 2.0, "zz", "", "0000000000000000000000000000000000000000"}},
+		{States: []interface{}{"makeBtcTx", "c330431496364497d7257839737b5e4596f5ac06", "zz", []interface{}{}}},
 	}
+	assert.Equal(t, 0, len(ob.checkEvents(events, 2)))
+	quarantined, err := ob.vdb.GetAllQuarantined()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(quarantined))
+	assert.Equal(t, uint32(2), quarantined[0].Height)
+}
 
-	if err := ob.setLastHeight(10); err != nil {
-		t.Fatal(err)
-	}
-	if ob.getLastHeight() != 10 {
-		t.Fatal("should be 10")
-	}
+func TestObserver_LoadCheckpoint(t *testing.T) {
+	defer os.RemoveAll("./temp")
+	ob := newTestObserver(t, startMockPolyServer(), 10)
+	ob.startHeight = 0
+	assert.Error(t, ob.LoadCheckpoint())
+
+	// migrated from the file of older versions
+	assert.NoError(t, ioutil.WriteFile("./temp/last_height", []byte("10\n"), 0644))
+	assert.NoError(t, ob.LoadCheckpoint())
+	assert.Equal(t, uint32(10), ob.lastHeight)
+	_, err := os.Stat("./temp/last_height")
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat("./temp/last_height.migrated")
+	assert.NoError(t, err)
+	h, hash, err := ob.vdb.GetCheckpoint()
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(10), h)
+	assert.NotNil(t, hash)
+
+	assert.NoError(t, ob.setLastHeight(11))
+	assert.NoError(t, ob.LoadCheckpoint())
+	assert.Equal(t, uint32(11), ob.lastHeight)
+
+	// checkpoint on another chain
+	assert.NoError(t, ob.vdb.PutQueue(12, make([]byte, 32), nil))
+	assert.Error(t, ob.LoadCheckpoint())
+	ob.startHeight = 5
+	assert.NoError(t, ob.LoadCheckpoint())
+	assert.Equal(t, uint32(5), ob.lastHeight)
+}
 
-	if err := ob.setLastHeight(11); err != nil {
+func TestObserver_LoadCheckpointCorrupted(t *testing.T) {
+	defer os.RemoveAll("./temp")
+	ob := newTestObserver(t, startMockPolyServer(), 10)
+	ob.startHeight = 0
+	assert.NoError(t, ioutil.WriteFile("./temp/last_height", []byte("ten"), 0644))
+	assert.Error(t, ob.LoadCheckpoint())
+	_, _, err := ob.vdb.GetCheckpoint()
+	assert.Equal(t, leveldb.ErrNotFound, err)
+	_, err = os.Stat("./temp/last_height")
+	assert.NoError(t, err)
+	ob.vdb.Close()
+
+	ldb, err := leveldb.OpenFile("./temp/db", nil)
+	if err != nil {
 		t.Fatal(err)
 	}
-	if ob.getLastHeight() != 11 {
-		t.Fatal("should be 11")
-	}
+	assert.NoError(t, ldb.Put([]byte("checkpoint"), []byte{1, 2, 3}, nil))
+	assert.NoError(t, ldb.Close())
+	ob = newTestObserver(t, startMockPolyServer(), 10)
+	ob.startHeight = 0
+	assert.Error(t, ob.LoadCheckpoint())
 }
 
 func startMockPolyServer() string {
@@ -241,6 +280,15 @@ func handlePolyReq(w http.ResponseWriter, r *http.Request) {
 			"id":      req.Id,
 		})
 
+		w.Write(rb)
+	case client.RPC_GET_BLOCK_HASH:
+		rb, _ := json.Marshal(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"error":   int64(0),
+			"desc":    "SUCCESS",
+			"result":  fmt.Sprintf("%064x", int64(req.Params[0].(float64))),
+			"id":      req.Id,
+		})
 		w.Write(rb)
 	case client.RPC_GET_BLOCK_COUNT:
 		if req.Id == "1" {
@@ -300,7 +348,11 @@ func TestNewObCli(t *testing.T) {
 	poly := sdk.NewPolySdk()
 	poly.NewRpcClient().SetAddress("http://40.115.182.238:40336") //("http://138.91.6.125:40336")
 
-	h, hash, err := utils3.GetCurrHeightFromPoly(poly)
+	h, err := poly.GetCurrentBlockHeight()
+	if err != nil {
+		t.Skipf("poly node not reachable: %v", err)
+	}
+	hash, err := poly.GetBlockHash(h)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -0,0 +1,132 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package observer
+
+import (
+	"github.com/polynetwork/btc-vendor-tools/config"
+	"github.com/polynetwork/btc-vendor-tools/log"
+	"github.com/polynetwork/btc-vendor-tools/utils"
+	"github.com/polynetwork/poly-go-sdk/client"
+	"github.com/polynetwork/poly-go-sdk/common"
+	"time"
+)
+
+const (
+	// catch up with workers when we are this far behind poly
+	CATCH_UP_GAP = 100
+	// interval to log the progress of catching up
+	PROGRESS_INTERVAL = 30 * time.Second
+)
+
+// prefetch fetches events of blocks from `from` to `to` with at most `workers` requests in flight.
+// Every height gets a slot in the returned channel in order of height, so the caller still
+// handles blocks one by one from the lowest, while the following ones are being fetched.
+func prefetch(from, to uint32, workers int, fetch func(uint32) []*common.SmartContactEvent) <-chan chan []*common.SmartContactEvent {
+	if workers < 1 {
+		workers = 1
+	}
+	// the slot being waited for by the caller is out of the channel
+	slots := make(chan chan []*common.SmartContactEvent, workers-1)
+	go func() {
+		defer close(slots)
+		for h := from; h <= to && h >= from; h++ {
+			slot := make(chan []*common.SmartContactEvent, 1)
+			slots <- slot
+			go func(h uint32) {
+				slot <- fetch(h)
+			}(h)
+		}
+	}()
+	return slots
+}
+
+// getEvents returns events at height h, retrying until it succeeds. In quorum mode, the
+// events are the same from at least k endpoints.
+func (ob *Observer) getEvents(h uint32) []*common.SmartContactEvent {
+	for {
+		var (
+			events []*common.SmartContactEvent
+			err    error
+		)
+		if ob.quorum != nil {
+			events, err = ob.quorum.GetSmartContractEventByBlock(h)
+		} else {
+			events, err = ob.poly.GetSmartContractEventByBlock(h)
+		}
+		if err == nil {
+			return events
+		}
+		switch err := err.(type) {
+		case client.PostErr:
+			log.Errorf("[Observer] GetSmartContractEventByBlock(%d) failed, retry after 10 sec: %v", h, err)
+		case QuorumError:
+			if err.Conflicting() {
+				log.Errorf("[Observer][ALERT] poly endpoints disagree, retry after 10 sec: %v", err)
+			} else {
+				log.Errorf("[Observer] no quorum, retry after 10 sec: %v", err)
+			}
+		default:
+			log.Errorf("[Observer] not supposed to happen when getting events at %d: %v", h, err)
+		}
+		utils.Wait(config.SleepTime)
+	}
+}
+
+// syncProgress tracks how we are catching up with poly from height `from` to `to`.
+type syncProgress struct {
+	from       uint32
+	to         uint32
+	start      time.Time
+	lastReport time.Time
+}
+
+func newSyncProgress(from, to uint32) *syncProgress {
+	now := time.Now()
+	log.Infof("[Observer] %d blocks behind poly, catching up from %d to %d", to-from+1, from, to)
+	metricSyncTarget.Set(int64(to))
+	return &syncProgress{
+		from:       from,
+		to:         to,
+		start:      now,
+		lastReport: now,
+	}
+}
+
+// eta estimates the time left when h is handled, from the speed so far.
+func (p *syncProgress) eta(h uint32, now time.Time) time.Duration {
+	done := h - p.from + 1
+	if done == 0 || h >= p.to {
+		return 0
+	}
+	spent := now.Sub(p.start)
+	return time.Duration(float64(spent) / float64(done) * float64(p.to-h))
+}
+
+// handled is called after height h is handled.
+func (p *syncProgress) handled(h uint32) {
+	now := time.Now()
+	eta := p.eta(h, now)
+	metricSyncETA.Set(int64(eta / time.Second))
+	if h < p.to && now.Sub(p.lastReport) < PROGRESS_INTERVAL {
+		return
+	}
+	p.lastReport = now
+	done := h - p.from + 1
+	speed := float64(done) / now.Sub(p.start).Seconds()
+	log.Infof("[Observer] catching up: height %d/%d (%.2f%%), %.1f blocks/s, ETA %v", h, p.to,
+		float64(done)*100/float64(p.to-p.from+1), speed, eta.Round(time.Second))
+}
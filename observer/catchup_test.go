@@ -0,0 +1,70 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package observer
+
+import (
+	"fmt"
+	"github.com/polynetwork/poly-go-sdk/common"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPrefetch(t *testing.T) {
+	var (
+		lock     sync.Mutex
+		inFlight int
+		maxIn    int
+	)
+	fetch := func(h uint32) []*common.SmartContactEvent {
+		lock.Lock()
+		inFlight++
+		if inFlight > maxIn {
+			maxIn = inFlight
+		}
+		lock.Unlock()
+		time.Sleep(time.Duration(rand.Intn(5)) * time.Millisecond)
+		lock.Lock()
+		inFlight--
+		lock.Unlock()
+		return []*common.SmartContactEvent{{TxHash: fmt.Sprint(h)}}
+	}
+
+	h := uint32(10)
+	for slot := range prefetch(10, 109, 4, fetch) {
+		events := <-slot
+		assert.Equal(t, fmt.Sprint(h), events[0].TxHash)
+		h++
+	}
+	assert.Equal(t, uint32(110), h)
+	assert.True(t, maxIn <= 4, "%d requests in flight", maxIn)
+	assert.True(t, maxIn > 1)
+
+	cnt := 0
+	for range prefetch(5, 4, 4, fetch) {
+		cnt++
+	}
+	assert.Equal(t, 0, cnt)
+}
+
+func TestSyncProgress_eta(t *testing.T) {
+	p := newSyncProgress(101, 300)
+	assert.Equal(t, 30*time.Second, p.eta(150, p.start.Add(10*time.Second)))
+	assert.Equal(t, time.Duration(0), p.eta(300, p.start.Add(time.Minute)))
+}
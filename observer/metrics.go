@@ -0,0 +1,39 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package observer
+
+import "expvar"
+
+// counters by redeem key, published at /debug/vars
+var (
+	metricCaptured = expvar.NewMap("observer_captured")
+	metricDone     = expvar.NewMap("observer_done")
+
+	// by notify name, as the redeem key of a malformed notify is not trustworthy
+	metricQuarantined = expvar.NewMap("observer_quarantined")
+
+	// by poly endpoint, answers outvoted by the quorum
+	metricQuorumDissent = expvar.NewMap("poly_quorum_dissent")
+)
+
+// sync status with poly
+var (
+	metricHeight     = expvar.NewInt("observer_height")
+	metricPolyHeight = expvar.NewInt("observer_poly_height")
+	metricSyncTarget = expvar.NewInt("observer_sync_target")
+	metricSyncETA    = expvar.NewInt("observer_sync_eta_seconds")
+)
@@ -0,0 +1,164 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package observer
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/polynetwork/btc-vendor-tools/utils"
+	"math"
+)
+
+// arity of notifies:
+// makeBtcTx: [name, redeem key, raw tx, [amounts of inputs]]
+// btcTxToRelay: [name, from chain id, to chain id, raw tx, tx hash, redeem key]
+const (
+	MAKE_BTC_TX_ARITY     = 4
+	BTC_TX_TO_RELAY_ARITY = 6
+
+	// index of the redeem key in states
+	MAKE_BTC_TX_REDEEM_KEY     = 1
+	BTC_TX_TO_RELAY_REDEEM_KEY = 5
+)
+
+// MakeBtcTxNotify is emitted when poly makes an unsigned tx for vendors to sign.
+type MakeBtcTxNotify struct {
+	RedeemKey string
+	Mtx       *wire.MsgTx
+	Amts      []uint64
+}
+
+// BtcTxToRelayNotify is emitted when the tx collected enough signatures.
+type BtcTxToRelayNotify struct {
+	RedeemKey string
+	Mtx       *wire.MsgTx
+}
+
+// NotifyError means a notify of ours is not in the expected shape.
+type NotifyError struct {
+	Name string
+	Desc string
+}
+
+func (err NotifyError) Error() string {
+	return fmt.Sprintf("malformed %s notify: %s", err.Name, err.Desc)
+}
+
+func decodeMakeBtcTx(states []interface{}) (*MakeBtcTxNotify, error) {
+	if len(states) != MAKE_BTC_TX_ARITY {
+		return nil, NotifyError{utils.TO_SIGN_TX_KEY, fmt.Sprintf("%d states, expecting %d", len(states),
+			MAKE_BTC_TX_ARITY)}
+	}
+	key, err := stateRedeemKey(utils.TO_SIGN_TX_KEY, states, MAKE_BTC_TX_REDEEM_KEY)
+	if err != nil {
+		return nil, err
+	}
+	mtx, err := stateTx(utils.TO_SIGN_TX_KEY, states, 2)
+	if err != nil {
+		return nil, err
+	}
+	vals, ok := states[3].([]interface{})
+	if !ok {
+		return nil, NotifyError{utils.TO_SIGN_TX_KEY, fmt.Sprintf("amounts are %T, not a list", states[3])}
+	}
+	if len(vals) != len(mtx.TxIn) {
+		return nil, NotifyError{utils.TO_SIGN_TX_KEY, fmt.Sprintf("%d amounts for %d inputs", len(vals),
+			len(mtx.TxIn))}
+	}
+	amts := make([]uint64, len(vals))
+	for i, v := range vals {
+		f, ok := v.(float64)
+		if !ok || f < 0 || f > btcMaxSatoshi || f != math.Trunc(f) {
+			return nil, NotifyError{utils.TO_SIGN_TX_KEY, fmt.Sprintf("No.%d amount %v is not a valid value", i, v)}
+		}
+		amts[i] = uint64(f)
+	}
+	return &MakeBtcTxNotify{
+		RedeemKey: key,
+		Mtx:       mtx,
+		Amts:      amts,
+	}, nil
+}
+
+func decodeBtcTxToRelay(states []interface{}) (*BtcTxToRelayNotify, error) {
+	if len(states) != BTC_TX_TO_RELAY_ARITY {
+		return nil, NotifyError{utils.SIGNED_TX_KEY, fmt.Sprintf("%d states, expecting %d", len(states),
+			BTC_TX_TO_RELAY_ARITY)}
+	}
+	key, err := stateRedeemKey(utils.SIGNED_TX_KEY, states, BTC_TX_TO_RELAY_REDEEM_KEY)
+	if err != nil {
+		return nil, err
+	}
+	mtx, err := stateTx(utils.SIGNED_TX_KEY, states, 3)
+	if err != nil {
+		return nil, err
+	}
+	return &BtcTxToRelayNotify{
+		RedeemKey: key,
+		Mtx:       mtx,
+	}, nil
+}
+
+// notifyRedeemKey returns the redeem key in states of notify name without decoding the rest,
+// so that notifies of other vendors are skipped whatever shape they are in. It's false when
+// there is no string where the key should be, and then we can't tell whose notify it is.
+func notifyRedeemKey(name string, states []interface{}) (string, bool) {
+	i := MAKE_BTC_TX_REDEEM_KEY
+	if name == utils.SIGNED_TX_KEY {
+		i = BTC_TX_TO_RELAY_REDEEM_KEY
+	}
+	if len(states) <= i {
+		return "", false
+	}
+	key, ok := states[i].(string)
+	return key, ok
+}
+
+// the max amount of bitcoin in satoshi, which is exactly representable in float64
+const btcMaxSatoshi = 21e14
+
+func stateRedeemKey(name string, states []interface{}, i int) (string, error) {
+	key, ok := states[i].(string)
+	if !ok {
+		return "", NotifyError{name, fmt.Sprintf("redeem key is %T, not a string", states[i])}
+	}
+	if raw, err := hex.DecodeString(key); err != nil || len(raw) != 20 {
+		return "", NotifyError{name, fmt.Sprintf("redeem key %s is not a hex hash160", key)}
+	}
+	return key, nil
+}
+
+func stateTx(name string, states []interface{}, i int) (*wire.MsgTx, error) {
+	s, ok := states[i].(string)
+	if !ok {
+		return nil, NotifyError{name, fmt.Sprintf("tx is %T, not a string", states[i])}
+	}
+	txb, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, NotifyError{name, fmt.Sprintf("wrong hex-string of tx: %v", err)}
+	}
+	mtx := wire.NewMsgTx(wire.TxVersion)
+	if err = mtx.BtcDecode(bytes.NewBuffer(txb), wire.ProtocolVersion, wire.LatestEncoding); err != nil {
+		return nil, NotifyError{name, fmt.Sprintf("failed to decode btc tx: %v", err)}
+	}
+	if len(mtx.TxIn) == 0 {
+		return nil, NotifyError{name, "tx has no input"}
+	}
+	return mtx, nil
+}
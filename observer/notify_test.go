@@ -0,0 +1,72 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package observer
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDecodeNotify(t *testing.T) {
+	mtx := wire.NewMsgTx(wire.TxVersion)
+	mtx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{1}, 0), nil, nil))
+	mtx.AddTxOut(wire.NewTxOut(1000, []byte{0x51}))
+	var buf bytes.Buffer
+	assert.NoError(t, mtx.BtcEncode(&buf, wire.ProtocolVersion, wire.LatestEncoding))
+	rawTx := hex.EncodeToString(buf.Bytes())
+	key := "87a9652e9b396545598c0fc72cb5a98848bf93d3"
+
+	// states are decoded from json by the sdk
+	parse := func(raw string) []interface{} {
+		var states []interface{}
+		assert.NoError(t, json.Unmarshal([]byte(raw), &states))
+		return states
+	}
+	nt, err := decodeMakeBtcTx(parse(`["makeBtcTx","` + key + `","` + rawTx + `",[5000]]`))
+	assert.NoError(t, err)
+	assert.Equal(t, key, nt.RedeemKey)
+	assert.Equal(t, []uint64{5000}, nt.Amts)
+	assert.Equal(t, mtx.TxHash(), nt.Mtx.TxHash())
+
+	for _, raw := range []string{
+		`["makeBtcTx","` + key + `","` + rawTx + `"]`,
+		`["makeBtcTx",1,"` + rawTx + `",[5000]]`,
+		`["makeBtcTx","abcd","` + rawTx + `",[5000]]`,
+		`["makeBtcTx","` + key + `","zz",[5000]]`,
+		`["makeBtcTx","` + key + `","` + rawTx + `",5000]`,
+		`["makeBtcTx","` + key + `","` + rawTx + `",[5000,1]]`,
+		`["makeBtcTx","` + key + `","` + rawTx + `",[-1]]`,
+		`["makeBtcTx","` + key + `","` + rawTx + `",[1.5]]`,
+		`["makeBtcTx","` + key + `","` + rawTx + `",["5000"]]`,
+	} {
+		_, err = decodeMakeBtcTx(parse(raw))
+		assert.Error(t, err, raw)
+	}
+
+	rnt, err := decodeBtcTxToRelay(parse(`["btcTxToRelay",1,0,"` + rawTx + `","00","` + key + `"]`))
+	assert.NoError(t, err)
+	assert.Equal(t, key, rnt.RedeemKey)
+	_, err = decodeBtcTxToRelay(parse(`["btcTxToRelay",1,0,"` + rawTx + `","00"]`))
+	assert.Error(t, err)
+	_, err = decodeBtcTxToRelay(parse(`["btcTxToRelay",1,0,"` + rawTx + `","00",null]`))
+	assert.Error(t, err)
+}
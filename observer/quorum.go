@@ -0,0 +1,141 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package observer
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/polynetwork/btc-vendor-tools/log"
+	sdk "github.com/polynetwork/poly-go-sdk"
+	"github.com/polynetwork/poly-go-sdk/common"
+	"sort"
+	"sync"
+)
+
+// Quorum gets events of a block from every poly endpoint, and takes them only if at
+// least k endpoints answer the same, so less than k malicious or lagging nodes can't make
+// us sign or mark anything done. k must be a strict majority, so at most one answer gets
+// k votes. Endpoints answering otherwise are flagged but don't block the others, trading
+// an early stop on a single bad answer for progress while one node is lagging.
+type Quorum struct {
+	addrs []string
+	polys []*sdk.PolySdk
+	k     int
+}
+
+func NewQuorum(addrs []string, k int) (*Quorum, error) {
+	if k <= len(addrs)/2 || k > len(addrs) {
+		return nil, fmt.Errorf("[Quorum] quorum %d is not a majority of %d endpoints", k, len(addrs))
+	}
+	polys := make([]*sdk.PolySdk, len(addrs))
+	for i, addr := range addrs {
+		polys[i] = sdk.NewPolySdk()
+		polys[i].NewRpcClient().SetAddress(addr)
+	}
+	return &Quorum{
+		addrs: addrs,
+		polys: polys,
+		k:     k,
+	}, nil
+}
+
+// QuorumError means less than k endpoints give the same events of a block.
+type QuorumError struct {
+	Height uint32
+	// number of endpoints giving each distinct answer, most first
+	Votes  []int
+	Failed int
+	K      int
+}
+
+func (err QuorumError) Error() string {
+	return fmt.Sprintf("events at height %d: answers from endpoints %v, %d failed, need %d same",
+		err.Height, err.Votes, err.Failed, err.K)
+}
+
+// Conflicting is true if endpoints answered with different events, which means one of
+// them is lagging or lying.
+func (err QuorumError) Conflicting() bool {
+	return len(err.Votes) > 1
+}
+
+func (q *Quorum) GetSmartContractEventByBlock(h uint32) ([]*common.SmartContactEvent, error) {
+	type answer struct {
+		events []*common.SmartContactEvent
+		addrs  []string
+	}
+	var (
+		wg      sync.WaitGroup
+		lock    sync.Mutex
+		answers = make(map[[sha256.Size]byte]*answer)
+		failed  = 0
+	)
+	for i := range q.polys {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			events, err := q.polys[i].GetSmartContractEventByBlock(h)
+			var raw []byte
+			if err == nil {
+				raw, err = json.Marshal(events)
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				failed++
+				return
+			}
+			digest := sha256.Sum256(raw)
+			if a, ok := answers[digest]; ok {
+				a.addrs = append(a.addrs, q.addrs[i])
+			} else {
+				answers[digest] = &answer{events: events, addrs: []string{q.addrs[i]}}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var agreed *answer
+	votes := make([]int, 0, len(answers))
+	for _, a := range answers {
+		if len(a.addrs) >= q.k {
+			agreed = a
+		}
+		votes = append(votes, len(a.addrs))
+	}
+	if agreed != nil {
+		for _, a := range answers {
+			if a == agreed {
+				continue
+			}
+			for _, addr := range a.addrs {
+				metricQuorumDissent.Add(addr, 1)
+				log.Errorf("[Quorum][ALERT] endpoint %s disagrees with %d others on events at height %d",
+					addr, len(agreed.addrs), h)
+			}
+		}
+		return agreed.events, nil
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(votes)))
+	return nil, QuorumError{
+		Height: h,
+		Votes:  votes,
+		Failed: failed,
+		K:      q.k,
+	}
+}
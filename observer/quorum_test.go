@@ -0,0 +1,75 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package observer
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func startMockEventServer(txHash string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, `{"jsonrpc":"2.0","id":"1","error":0,"desc":"SUCCESS","result":[{"TxHash":"%s",`+
+			`"State":1,"Notify":[{"ContractAddress":"0300000000000000000000000000000000000000",`+
+			`"States":["makeBtcTx","87a9652e9b396545598c0fc72cb5a98848bf93d3","00",[1]]}]}]}`, txHash)
+	}))
+}
+
+func TestQuorum(t *testing.T) {
+	s1, s2, s3 := startMockEventServer("aa"), startMockEventServer("aa"), startMockEventServer("bb")
+	defer s1.Close()
+	defer s2.Close()
+	defer s3.Close()
+	addrs := []string{s1.URL, s2.URL, s3.URL}
+
+	// a majority agrees and the one saying otherwise is flagged
+	q, err := NewQuorum(addrs, 2)
+	assert.NoError(t, err)
+	events, err := q.GetSmartContractEventByBlock(1)
+	assert.NoError(t, err)
+	assert.Equal(t, "aa", events[0].TxHash)
+	assert.Equal(t, "1", metricQuorumDissent.Get(s3.URL).String())
+	assert.Nil(t, metricQuorumDissent.Get(s1.URL))
+
+	q3, _ := NewQuorum(addrs, 3)
+	_, err = q3.GetSmartContractEventByBlock(1)
+	qerr, ok := err.(QuorumError)
+	assert.True(t, ok)
+	assert.Equal(t, []int{2, 1}, qerr.Votes)
+	assert.True(t, qerr.Conflicting())
+
+	s3.Close()
+	events, err = q.GetSmartContractEventByBlock(1)
+	assert.NoError(t, err)
+	assert.Equal(t, "aa", events[0].TxHash)
+	_, err = q3.GetSmartContractEventByBlock(1)
+	qerr = err.(QuorumError)
+	assert.Equal(t, 1, qerr.Failed)
+	assert.False(t, qerr.Conflicting())
+
+	_, err = NewQuorum(addrs, 4)
+	assert.Error(t, err)
+	_, err = NewQuorum(addrs, 1)
+	assert.Error(t, err)
+	_, err = NewQuorum(addrs[:2], 1)
+	assert.Error(t, err)
+	_, err = NewQuorum(addrs[:1], 1)
+	assert.NoError(t, err)
+}
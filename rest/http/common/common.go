@@ -17,11 +17,45 @@
 package common
 
 const (
-	SIGNTX = "/api/v1/signtx"
+	SIGNTX   = "/api/v1/signtx"
+	OVERRIDE = "/api/v1/admin/override"
+
+	APPROVALS = "/api/v1/admin/approvals"
+	APPROVE   = "/api/v1/admin/approve"
+	REJECT    = "/api/v1/admin/reject"
+	DELAYED   = "/api/v1/admin/delayed"
+	VETO      = "/api/v1/admin/veto"
+	FREEZE    = "/api/v1/admin/freeze"
+	UNFREEZE  = "/api/v1/admin/unfreeze"
+	FROZEN    = "/api/v1/admin/frozen"
+	PSBT      = "/api/v1/admin/psbt"
+
+	// routes under this are only for the operator, never opened to other origins
+	ADMIN_PREFIX = "/api/v1/admin/"
+
+	APPROVAL_PAGE = "/approvals"
+	METRICS       = "/debug/vars"
 )
 
+// admin token of GET requests, never taken from the url so that it stays out of access logs
+const ADMIN_TOKEN_HEADER = "X-Admin-Token"
+
 const (
-	ACTION_SIGNTX = "signtx"
+	ACTION_SIGNTX    = "signtx"
+	ACTION_OVERRIDE  = "override"
+	ACTION_APPROVALS = "approvals"
+	ACTION_APPROVE   = "approve"
+	ACTION_REJECT    = "reject"
+	ACTION_DELAYED   = "delayed"
+	ACTION_VETO      = "veto"
+	ACTION_FREEZE    = "freeze"
+	ACTION_UNFREEZE  = "unfreeze"
+	ACTION_FROZEN    = "frozen"
+	ACTION_EXPORT    = "exportpsbt"
+	ACTION_IMPORT    = "importpsbt"
+
+	BACKLOG_SIGN    = "sign"
+	BACKLOG_DISCARD = "discard"
 )
 
 type Response struct {
@@ -34,3 +68,60 @@ type Response struct {
 type SignItemReq struct {
 	Raw string `json:"raw"`
 }
+
+type AdminReq struct {
+	Token string `json:"token"`
+}
+
+type OverrideReq struct {
+	AdminReq
+	TxHash string `json:"txhash"`
+}
+
+type DecisionReq struct {
+	AdminReq
+	TxHash   string `json:"txhash"`
+	Operator string `json:"operator"`
+}
+
+type PSBTReq struct {
+	AdminReq
+	TxHash string `json:"txhash"`
+}
+
+type ImportPSBTReq struct {
+	AdminReq
+	PSBT     string `json:"psbt"`
+	Operator string `json:"operator"`
+}
+
+type PSBTInfo struct {
+	TxHash string `json:"txhash"`
+	PSBT   string `json:"psbt"`
+}
+
+type FreezeReq struct {
+	AdminReq
+	Operator string `json:"operator"`
+	Backlog  string `json:"backlog"`
+}
+
+type TxOutInfo struct {
+	Addr   string `json:"addr"`
+	Value  uint64 `json:"value"`
+	Change bool   `json:"change"`
+}
+
+type ApprovalInfo struct {
+	TxHash       string       `json:"txhash"`
+	TimeReceived string       `json:"time_received"`
+	Status       string       `json:"status"`
+	Operator     string       `json:"operator"`
+	DecisionTime string       `json:"decision_time"`
+	ReleaseTime  string       `json:"release_time,omitempty"`
+	Reason       string       `json:"reason,omitempty"`
+	Outputs      []*TxOutInfo `json:"outputs"`
+	InValue      uint64       `json:"in_value"`
+	Leaving      uint64       `json:"leaving"`
+	Fee          uint64       `json:"fee"`
+}
@@ -25,6 +25,8 @@ const (
 	INVALID_PARAMS     uint32 = 42002
 	ILLEGAL_DATAFORMAT uint32 = 42003
 	INTERNAL_ERROR     uint32 = 42004
+	UNAUTHORIZED       uint32 = 42005
+	SIGN_REJECTED      uint32 = 42006
 )
 
 var ErrMap = map[uint32]string{
@@ -34,4 +36,6 @@ var ErrMap = map[uint32]string{
 	INVALID_PARAMS:     "INVALID PARAMS",
 	ILLEGAL_DATAFORMAT: "ILLEGAL DATAFORMAT",
 	INTERNAL_ERROR:     "INTERNAL_ERROR",
+	UNAUTHORIZED:       "UNAUTHORIZED",
+	SIGN_REJECTED:      "SIGN REJECTED",
 }
@@ -16,6 +16,20 @@
 */
 package restful
 
+import "net/http"
+
 type Web interface {
 	SignTx(map[string]interface{}) map[string]interface{}
+	OverrideConflict(map[string]interface{}) map[string]interface{}
+	GetApprovals(map[string]interface{}) map[string]interface{}
+	Approve(map[string]interface{}) map[string]interface{}
+	Reject(map[string]interface{}) map[string]interface{}
+	GetDelayed(map[string]interface{}) map[string]interface{}
+	Veto(map[string]interface{}) map[string]interface{}
+	Freeze(map[string]interface{}) map[string]interface{}
+	Unfreeze(map[string]interface{}) map[string]interface{}
+	GetFrozen(map[string]interface{}) map[string]interface{}
+	ExportPSBT(map[string]interface{}) map[string]interface{}
+	ImportPSBT(map[string]interface{}) map[string]interface{}
+	ApprovalPage(http.ResponseWriter, *http.Request)
 }
@@ -20,10 +20,12 @@ package restful
 import (
 	"context"
 	"encoding/json"
+	"expvar"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -54,12 +56,12 @@ type restServer struct {
 }
 
 //init restful server
-func InitRestServer(web Web, port uint64, cliAddr string) ApiServer {
+func InitRestServer(web Web, port uint64, cliAddrs ...string) ApiServer {
 	rt := &restServer{
 		port: port,
 	}
 
-	rt.router = NewRouter(cliAddr)
+	rt.router = NewRouter(cliAddrs...)
 	rt.getMap = make(map[string]Action)
 	rt.postMap = make(map[string]Action)
 	rt.registryRestServerAction(web)
@@ -71,10 +73,26 @@ func InitRestServer(web Web, port uint64, cliAddr string) ApiServer {
 //resigtry handler method
 func (this *restServer) registryRestServerAction(web Web) {
 	postMethodMap := map[string]Action{
-		common.SIGNTX: {name: common.ACTION_SIGNTX, handler: web.SignTx},
+		common.SIGNTX:   {name: common.ACTION_SIGNTX, handler: web.SignTx},
+		common.OVERRIDE: {name: common.ACTION_OVERRIDE, handler: web.OverrideConflict},
+		common.APPROVE:  {name: common.ACTION_APPROVE, handler: web.Approve},
+		common.REJECT:   {name: common.ACTION_REJECT, handler: web.Reject},
+		common.VETO:     {name: common.ACTION_VETO, handler: web.Veto},
+		common.FREEZE:   {name: common.ACTION_FREEZE, handler: web.Freeze},
+		common.UNFREEZE: {name: common.ACTION_UNFREEZE, handler: web.Unfreeze},
+		common.PSBT:     {name: common.ACTION_IMPORT, handler: web.ImportPSBT},
 	}
 
-	getMethodMap := map[string]Action{}
+	getMethodMap := map[string]Action{
+		common.APPROVALS: {name: common.ACTION_APPROVALS, handler: web.GetApprovals},
+		common.DELAYED:   {name: common.ACTION_DELAYED, handler: web.GetDelayed},
+		common.FROZEN:    {name: common.ACTION_FROZEN, handler: web.GetFrozen},
+		common.PSBT:      {name: common.ACTION_EXPORT, handler: web.ExportPSBT},
+	}
+
+	this.router.Get(common.APPROVAL_PAGE, web.ApprovalPage)
+	this.router.Post(common.APPROVAL_PAGE, web.ApprovalPage)
+	this.router.Get(common.METRICS, expvar.Handler().ServeHTTP)
 
 	this.postMap = postMethodMap
 	this.getMap = getMethodMap
@@ -116,6 +134,15 @@ func (this *restServer) getUrlParams(r *http.Request) map[string]interface{} {
 	for name, value := range values {
 		params[name] = value[0]
 	}
+	if _, ok := params["token"]; ok {
+		log.Warnf("[Rest] admin token in url of %s is ignored, use header %s", r.URL.Path,
+			common.ADMIN_TOKEN_HEADER)
+		delete(params, "token")
+	}
+	if token := r.Header.Get(common.ADMIN_TOKEN_HEADER); token != "" {
+		params["token"] = token
+	}
+	params["host"], _, _ = net.SplitHostPort(r.RemoteAddr)
 	return params
 }
 
@@ -134,7 +161,7 @@ func (this *restServer) initGetHandler() {
 				resp = PackResponse(INVALID_METHOD)
 				resp["action"] = h.name
 			}
-			this.response(w, resp)
+			this.response(w, url, resp)
 		})
 	}
 }
@@ -153,6 +180,9 @@ func (this *restServer) initPostHandler() {
 			if h, ok := this.postMap[url]; ok {
 				if err := json.Unmarshal(body, &req); err == nil {
 					req["host"], _, _ = net.SplitHostPort(r.RemoteAddr)
+					if token := r.Header.Get(common.ADMIN_TOKEN_HEADER); token != "" && req["token"] == nil {
+						req["token"] = token
+					}
 					resp = h.handler(req)
 				} else {
 					log.Error("unmarshal body error:", err)
@@ -163,33 +193,35 @@ func (this *restServer) initPostHandler() {
 				resp = PackResponse(INVALID_METHOD)
 				resp["action"] = h.name
 			}
-			this.response(w, resp)
+			this.response(w, url, resp)
 		})
 	}
 	//Options
 	for k := range this.postMap {
 		this.router.Options(k, func(w http.ResponseWriter, r *http.Request) {
-			this.write(w, []byte{})
+			this.write(w, r.URL.Path, []byte{})
 		})
 	}
 }
 
-func (this *restServer) write(w http.ResponseWriter, data []byte) {
-	w.Header().Add("Access-Control-Allow-Headers", "Content-Type")
+func (this *restServer) write(w http.ResponseWriter, path string, data []byte) {
 	w.Header().Set("content-type", "application/json;charset=utf-8")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if !strings.HasPrefix(path, common.ADMIN_PREFIX) {
+		w.Header().Add("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	}
 	w.Write(data)
 }
 
 //response
-func (this *restServer) response(w http.ResponseWriter, resp map[string]interface{}) {
+func (this *restServer) response(w http.ResponseWriter, path string, resp map[string]interface{}) {
 	//resp["desc"] = ErrMap[resp["error"].(uint32)]
 	data, err := json.Marshal(resp)
 	if err != nil {
 		log.Fatalf("HTTP Handle - json.Marshal: %v", err)
 		return
 	}
-	this.write(w, data)
+	this.write(w, path, data)
 }
 
 //stop restful server
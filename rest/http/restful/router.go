@@ -35,13 +35,19 @@ type Route struct {
 	Handler http.HandlerFunc
 }
 type Router struct {
-	cliHost string
-	routes  []*Route
+	cliHosts map[string]bool
+	routes   []*Route
 }
 
-func NewRouter(cliHost string) *Router {
+func NewRouter(cliHosts ...string) *Router {
+	hosts := make(map[string]bool)
+	for _, h := range cliHosts {
+		if h != "" {
+			hosts[h] = true
+		}
+	}
 	return &Router{
-		cliHost: cliHost,
+		cliHosts: hosts,
 	}
 }
 
@@ -116,7 +122,7 @@ func (r *Router) Options(path string, handler http.HandlerFunc) {
 }
 
 func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	if host, _, _ := net.SplitHostPort(req.RemoteAddr); host != r.cliHost {
+	if host, _, _ := net.SplitHostPort(req.RemoteAddr); !r.cliHosts[host] {
 		return
 	}
 	handler, params, err := r.Try(req.URL.Path, req.Method)
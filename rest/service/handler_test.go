@@ -19,32 +19,57 @@ package service
 import (
 	"bytes"
 	"encoding/hex"
+	"github.com/btcsuite/btcd/btcec"
 	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
 	"github.com/polynetwork/btc-vendor-tools/config"
+	"github.com/polynetwork/btc-vendor-tools/db"
+	"github.com/polynetwork/btc-vendor-tools/rest/http/common"
 	"github.com/polynetwork/btc-vendor-tools/observer"
 	"github.com/polynetwork/btc-vendor-tools/rest/http/restful"
 	"github.com/polynetwork/btc-vendor-tools/signer"
 	"github.com/polynetwork/btc-vendor-tools/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 var (
+	privk     = "../../privk"
 	redeem    = "5521023ac710e73e1410718530b2686ce47f12fa3c470a9eb6085976b70b01c64c9f732102c9dc4d8f419e325bbef0fe039ed6feaf2079a2ef7b27336ddb79be2ea6e334bf2102eac939f2f0873894d8bf0ef2f8bbdd32e4290cbf9632b59dee743529c0af9e802103378b4a3854c88cca8bfed2558e9875a144521df4a75ab37a206049ccef12be692103495a81957ce65e3359c114e6c2fe9f97568be491e3f24d6fa66cc542e360cd662102d43e29299971e802160a92cfcd4037e8ae83fb8f6af138684bebdc5686f3b9db21031e415c04cbc9b81fbee6e04d8c902e8f61109a2c9883a959ba528c52698c055a57ae"
 	usignedTx = "01000000015ef067df7af576fa5b43bb7e99846c970af7e998cf060c9942920883a515cc6c0000000000ffffffff01401f00000000000017a91487a9652e9b396545598c0fc72cb5a98848bf93d38700000000"
 )
 
 func TestService_SignTx(t *testing.T) {
+	if _, err := os.Stat(privk); os.IsNotExist(err) {
+		t.Skipf("fixture %s not found", privk)
+	}
 	config.BtcNetParam = &chaincfg.TestNet3Params
 	rb, _ := hex.DecodeString(redeem)
-	sgr, err := signer.NewSigner("/Users/zou/go/src/github.com/ontio/btc-vendor-tools/privk",
-		nil, nil, nil, rb)
+	ks, err := signer.NewWalletKeyStore(nil, privk, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rd, err := signer.NewRedeem(rb, ks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sgr, err := signer.NewSigner([]*signer.Redeem{rd}, nil, nil, nil, nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	serv := NewService(sgr)
-	restServer := restful.InitRestServer(serv, 50071)
+	serv := NewService(sgr, "", nil, "")
+	restServer := restful.InitRestServer(serv, 50071, "127.0.0.1")
 	go restServer.Start()
 
 	obc := observer.NewObCli("0.0.0.0:50071")
@@ -60,3 +85,122 @@ func TestService_SignTx(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestService_SignTxRejected(t *testing.T) {
+	config.BtcNetParam = &chaincfg.RegressionNetParams
+	addrs := make([]*btcutil.AddressPubKey, 3)
+	keys := make([]*btcec.PrivateKey, 3)
+	for i := range addrs {
+		keys[i], _ = btcec.NewPrivateKey(btcec.S256())
+		addrs[i], _ = btcutil.NewAddressPubKey(keys[i].PubKey().SerializeCompressed(), config.BtcNetParam)
+	}
+	rb, err := txscript.MultiSigScript(addrs, 2)
+	require.NoError(t, err)
+	rd, err := signer.NewRedeem(rb, signer.NewPrivKeyStore(keys[0]))
+	require.NoError(t, err)
+	sgr, err := signer.NewSigner([]*signer.Redeem{rd}, nil, nil, nil, nil, nil)
+	require.NoError(t, err)
+	serv := NewService(sgr, "", nil, "")
+
+	// spending an output not locked by our redeem
+	mtx := wire.NewMsgTx(wire.TxVersion)
+	mtx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{1}, 0), []byte{0x51}, nil))
+	mtx.AddTxOut(wire.NewTxOut(1000, []byte{0x51}))
+	item := &utils.ToSignItem{
+		Amts: []uint64{2000},
+		Mtx:  mtx,
+	}
+	raw, err := item.Serialize()
+	require.NoError(t, err)
+	resp := serv.SignTx(map[string]interface{}{"raw": hex.EncodeToString(raw)})
+	assert.Equal(t, restful.SIGN_REJECTED, resp["error"])
+
+	restServer := restful.InitRestServer(serv, 50072, "127.0.0.1")
+	go restServer.Start()
+	time.Sleep(100 * time.Millisecond)
+	err = observer.NewObCli("127.0.0.1:50072").SendToSign(item)
+	_, ok := err.(observer.RejectedError)
+	assert.True(t, ok, "%v", err)
+
+	// admin routes are not opened to other origins
+	resp2, err := http.Get("http://127.0.0.1:50072" + common.APPROVALS)
+	require.NoError(t, err)
+	resp2.Body.Close()
+	assert.Empty(t, resp2.Header.Get("Access-Control-Allow-Origin"))
+}
+
+func TestService_OperatorToken(t *testing.T) {
+	serv := NewService(nil, "admin", map[string]string{"alice": "t-alice", "bob": "t-bob", "carol": ""}, "")
+	assert.True(t, serv.checkToken("admin"))
+	assert.True(t, serv.checkToken("t-bob"))
+	assert.False(t, serv.checkToken(""))
+	assert.False(t, serv.checkToken("t-carol"))
+
+	operator, ok := serv.operatorOf("t-alice", "")
+	assert.True(t, ok)
+	assert.Equal(t, "alice", operator)
+	_, ok = serv.operatorOf("t-alice", "alice")
+	assert.True(t, ok)
+	_, ok = serv.operatorOf("t-alice", "bob")
+	assert.False(t, ok)
+	_, ok = serv.operatorOf("admin", "alice")
+	assert.False(t, ok)
+	_, ok = serv.operatorOf("", "")
+	assert.False(t, ok)
+
+	// the shared admin token can't decide for anyone
+	resp := serv.Approve(map[string]interface{}{"token": "admin", "txhash": chainhash.Hash{}.String(),
+		"operator": "alice"})
+	assert.Equal(t, restful.UNAUTHORIZED, resp["error"])
+	resp = serv.Freeze(map[string]interface{}{"token": "t-alice", "operator": "bob"})
+	assert.Equal(t, restful.UNAUTHORIZED, resp["error"])
+}
+
+func TestService_ApprovalPage(t *testing.T) {
+	config.BtcNetParam = &chaincfg.RegressionNetParams
+	vdb, err := db.NewVendorDB("./temp")
+	require.NoError(t, err)
+	defer os.RemoveAll("./temp")
+	key, _ := btcec.NewPrivateKey(btcec.S256())
+	addr, _ := btcutil.NewAddressPubKey(key.PubKey().SerializeCompressed(), config.BtcNetParam)
+	rb, err := txscript.MultiSigScript([]*btcutil.AddressPubKey{addr}, 1)
+	require.NoError(t, err)
+	rd, err := signer.NewRedeem(rb, signer.NewPrivKeyStore(key))
+	require.NoError(t, err)
+	sgr, err := signer.NewSigner([]*signer.Redeem{rd}, nil, nil, nil, vdb, nil)
+	require.NoError(t, err)
+	serv := NewService(sgr, "admin", map[string]string{"alice": "t-alice"}, "../../web/views/approvals.tmpl")
+	s := httptest.NewServer(http.HandlerFunc(serv.ApprovalPage))
+	defer s.Close()
+
+	post := func(origin string, form url.Values) (int, string) {
+		req, err := http.NewRequest(http.MethodPost, s.URL+common.APPROVAL_PAGE, strings.NewReader(form.Encode()))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var buf bytes.Buffer
+		_, _ = buf.ReadFrom(resp.Body)
+		assert.Equal(t, "DENY", resp.Header.Get("X-Frame-Options"))
+		return resp.StatusCode, buf.String()
+	}
+
+	code, _ := post("http://evil.example", url.Values{"token": {"t-alice"}})
+	assert.Equal(t, http.StatusForbidden, code)
+	_, body := post(s.URL, url.Values{"token": {"admin"}})
+	assert.Contains(t, body, "wrong operator token")
+	_, body = post(s.URL, url.Values{"token": {"t-alice"}})
+	assert.Contains(t, body, "Signed in as alice")
+
+	// a decision needs the csrf value of the form
+	txid := chainhash.Hash{1}.String()
+	_, body = post(s.URL, url.Values{"token": {"t-alice"}, "txhash": {txid}, "action": {common.ACTION_APPROVE}})
+	assert.Contains(t, body, "reload the page")
+	_, body = post(s.URL, url.Values{"token": {"t-alice"}, "txhash": {txid}, "csrf": {serv.csrf},
+		"action": {common.ACTION_APPROVE}})
+	assert.Contains(t, body, "failed to approve tx "+txid)
+}
@@ -17,26 +17,92 @@
 package service
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
 	"encoding/hex"
 	"fmt"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/polynetwork/btc-vendor-tools/log"
 	"github.com/polynetwork/btc-vendor-tools/rest/http/common"
 	"github.com/polynetwork/btc-vendor-tools/rest/http/restful"
 	"github.com/polynetwork/btc-vendor-tools/rest/utils"
 	"github.com/polynetwork/btc-vendor-tools/signer"
 	locutil "github.com/polynetwork/btc-vendor-tools/utils"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
 )
 
+// template of the approval page if not configured
+const APPROVAL_PAGE_TMPL = "web/views/approvals.tmpl"
+
+var heldStatus = map[uint8]string{
+	locutil.HELD_PENDING:  "pending",
+	locutil.HELD_APPROVED: "approved",
+	locutil.HELD_REJECTED: "rejected",
+}
+
 type Service struct {
-	signer *signer.Signer
+	signer     *signer.Signer
+	adminToken string
+	// operator name => token
+	operators map[string]string
+	tmpl      string
+	// put into forms of the approval page and checked on every decision against CSRF
+	csrf string
 }
 
-func NewService(signer *signer.Signer) *Service {
+func NewService(signer *signer.Signer, adminToken string, operators map[string]string, tmpl string) *Service {
+	if tmpl == "" {
+		tmpl = APPROVAL_PAGE_TMPL
+	}
+	csrf := make([]byte, 16)
+	if _, err := rand.Read(csrf); err != nil {
+		panic(err)
+	}
 	return &Service{
-		signer: signer,
+		signer:     signer,
+		adminToken: adminToken,
+		operators:  operators,
+		tmpl:       tmpl,
+		csrf:       hex.EncodeToString(csrf),
 	}
 }
 
+// checkToken tells if token is the admin token or the token of any operator. An empty
+// admin token never matches.
+func (serv *Service) checkToken(token string) bool {
+	if serv.adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(serv.adminToken)) == 1 {
+		return true
+	}
+	_, ok := serv.operatorOf(token, "")
+	return ok
+}
+
+// operatorOf returns the operator token is given to. Actions recorded with an operator take
+// it from the token rather than the shared admin token, and refuse a claimed operator other
+// than the token's.
+func (serv *Service) operatorOf(token, claimed string) (string, bool) {
+	operator := ""
+	for name, t := range serv.operators {
+		if t == "" || subtle.ConstantTimeCompare([]byte(token), []byte(t)) != 1 {
+			continue
+		}
+		if operator != "" {
+			log.Errorf("[Rest] operators %s and %s share the same token, both refused", operator, name)
+			return "", false
+		}
+		operator = name
+	}
+	if operator == "" || claimed != "" && claimed != operator {
+		return "", false
+	}
+	return operator, true
+}
+
 func (serv *Service) SignTx(params map[string]interface{}) map[string]interface{} {
 	resp := &common.Response{
 		Action: common.ACTION_SIGNTX,
@@ -69,8 +135,12 @@ func (serv *Service) SignTx(params map[string]interface{}) map[string]interface{
 	}
 
 	if err := serv.signer.Sign(item); err != nil {
-		log.Errorf("[Rest] SignTx: deserialize failed, err: %s", err)
+		log.Errorf("[Rest] SignTx: sign failed, err: %s", err)
 		resp.Error = restful.INTERNAL_ERROR
+		if signer.IsRejection(err) {
+			// the observer moves on, sending it again gets the same answer
+			resp.Error = restful.SIGN_REJECTED
+		}
 		resp.Desc = fmt.Sprintf("[Rest] SignTx: sign failed, err: %s", err)
 		m, _ := utils.RefactorResp(resp, resp.Error)
 		return m
@@ -84,3 +154,440 @@ func (serv *Service) SignTx(params map[string]interface{}) map[string]interface{
 	}
 	return m
 }
+
+func (serv *Service) OverrideConflict(params map[string]interface{}) map[string]interface{} {
+	resp := &common.Response{
+		Action: common.ACTION_OVERRIDE,
+	}
+	req := &common.OverrideReq{}
+	if err := utils.ParseParams(req, params); err != nil {
+		log.Errorf("[Rest] OverrideConflict: decode params failed, err: %s", err)
+		resp.Error = restful.INVALID_PARAMS
+		resp.Desc = fmt.Sprintf("OverrideConflict: decode params failed, err: %s", err)
+		m, _ := utils.RefactorResp(resp, resp.Error)
+		return m
+	}
+	if !serv.checkToken(req.Token) {
+		log.Errorf("[Rest] OverrideConflict: unauthorized request from %v", params["host"])
+		resp.Error = restful.UNAUTHORIZED
+		resp.Desc = "OverrideConflict: wrong admin token"
+		m, _ := utils.RefactorResp(resp, resp.Error)
+		return m
+	}
+
+	txid, err := chainhash.NewHashFromStr(req.TxHash)
+	if err != nil {
+		log.Errorf("[Rest] OverrideConflict: decode txhash failed, err: %s", err)
+		resp.Error = restful.INVALID_PARAMS
+		resp.Desc = fmt.Sprintf("OverrideConflict: decode txhash failed, err: %s", err)
+		m, _ := utils.RefactorResp(resp, resp.Error)
+		return m
+	}
+	if err := serv.signer.AllowConflict(txid); err != nil {
+		log.Errorf("[Rest] OverrideConflict: %v", err)
+		resp.Error = restful.INTERNAL_ERROR
+		resp.Desc = fmt.Sprintf("OverrideConflict: %v", err)
+		m, _ := utils.RefactorResp(resp, resp.Error)
+		return m
+	}
+
+	m, err := utils.RefactorResp(resp, resp.Error)
+	if err != nil {
+		log.Errorf("[Rest] OverrideConflict: failed, err: %v", err)
+	} else {
+		log.Infof("[Rest] OverrideConflict: allow tx %s from %v", txid.String(), params["host"])
+	}
+	return m
+}
+
+func (serv *Service) GetApprovals(params map[string]interface{}) map[string]interface{} {
+	return serv.listHeld(common.ACTION_APPROVALS, params, serv.signer.GetApprovals)
+}
+
+func (serv *Service) GetDelayed(params map[string]interface{}) map[string]interface{} {
+	return serv.listHeld(common.ACTION_DELAYED, params, serv.signer.GetDelayed)
+}
+
+func (serv *Service) GetFrozen(params map[string]interface{}) map[string]interface{} {
+	return serv.listHeld(common.ACTION_FROZEN, params, serv.signer.GetFrozen)
+}
+
+func (serv *Service) listHeld(action string, params map[string]interface{},
+	f func() (map[chainhash.Hash]*locutil.HeldItem, error)) map[string]interface{} {
+	resp := &common.Response{
+		Action: action,
+	}
+	req := &common.AdminReq{}
+	if err := utils.ParseParams(req, params); err != nil {
+		log.Errorf("[Rest] %s: decode params failed, err: %s", action, err)
+		resp.Error = restful.INVALID_PARAMS
+		resp.Desc = fmt.Sprintf("%s: decode params failed, err: %s", action, err)
+		m, _ := utils.RefactorResp(resp, resp.Error)
+		return m
+	}
+	if !serv.checkToken(req.Token) {
+		log.Errorf("[Rest] %s: unauthorized request", action)
+		resp.Error = restful.UNAUTHORIZED
+		resp.Desc = fmt.Sprintf("%s: wrong admin token", action)
+		m, _ := utils.RefactorResp(resp, resp.Error)
+		return m
+	}
+	infos, err := serv.getHeldInfos(f)
+	if err != nil {
+		log.Errorf("[Rest] %s: %v", action, err)
+		resp.Error = restful.INTERNAL_ERROR
+		resp.Desc = fmt.Sprintf("%s: %v", action, err)
+		m, _ := utils.RefactorResp(resp, resp.Error)
+		return m
+	}
+	resp.Result = infos
+
+	m, err := utils.RefactorResp(resp, resp.Error)
+	if err != nil {
+		log.Errorf("[Rest] %s: failed, err: %v", action, err)
+	}
+	return m
+}
+
+func (serv *Service) Approve(params map[string]interface{}) map[string]interface{} {
+	return serv.decide(common.ACTION_APPROVE, params, serv.signer.Approve)
+}
+
+func (serv *Service) Reject(params map[string]interface{}) map[string]interface{} {
+	return serv.decide(common.ACTION_REJECT, params, serv.signer.Reject)
+}
+
+func (serv *Service) Veto(params map[string]interface{}) map[string]interface{} {
+	return serv.decide(common.ACTION_VETO, params, serv.signer.Veto)
+}
+
+func (serv *Service) decide(action string, params map[string]interface{},
+	f func(*chainhash.Hash, string) error) map[string]interface{} {
+	resp := &common.Response{
+		Action: action,
+	}
+	req := &common.DecisionReq{}
+	if err := utils.ParseParams(req, params); err != nil {
+		log.Errorf("[Rest] %s: decode params failed, err: %s", action, err)
+		resp.Error = restful.INVALID_PARAMS
+		resp.Desc = fmt.Sprintf("%s: decode params failed, err: %s", action, err)
+		m, _ := utils.RefactorResp(resp, resp.Error)
+		return m
+	}
+	operator, ok := serv.operatorOf(req.Token, req.Operator)
+	if !ok {
+		log.Errorf("[Rest] %s: unauthorized request from %v", action, params["host"])
+		resp.Error = restful.UNAUTHORIZED
+		resp.Desc = fmt.Sprintf("%s: wrong operator token", action)
+		m, _ := utils.RefactorResp(resp, resp.Error)
+		return m
+	}
+	req.Operator = operator
+	if err := serv.doDecide(req, f); err != nil {
+		log.Errorf("[Rest] %s: %v", action, err)
+		resp.Error = restful.INTERNAL_ERROR
+		resp.Desc = fmt.Sprintf("%s: %v", action, err)
+		m, _ := utils.RefactorResp(resp, resp.Error)
+		return m
+	}
+
+	m, err := utils.RefactorResp(resp, resp.Error)
+	if err != nil {
+		log.Errorf("[Rest] %s: failed, err: %v", action, err)
+	} else {
+		log.Infof("[Rest] %s: tx %s by %s from %v", action, req.TxHash, req.Operator, params["host"])
+	}
+	return m
+}
+
+func (serv *Service) doDecide(req *common.DecisionReq, f func(*chainhash.Hash, string) error) error {
+	txid, err := chainhash.NewHashFromStr(req.TxHash)
+	if err != nil {
+		return fmt.Errorf("decode txhash failed, err: %s", err)
+	}
+	return f(txid, req.Operator)
+}
+
+func (serv *Service) getHeldInfos(f func() (map[chainhash.Hash]*locutil.HeldItem, error)) (
+	[]*common.ApprovalInfo, error) {
+	items, err := f()
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]*common.ApprovalInfo, 0, len(items))
+	for txid, held := range items {
+		info := &common.ApprovalInfo{
+			TxHash:       txid.String(),
+			TimeReceived: held.TimeReceived.Format(time.RFC3339),
+			Status:       heldStatus[held.Status],
+			Operator:     held.Operator,
+			Reason:       held.Reason,
+		}
+		if held.Status != locutil.HELD_PENDING {
+			info.DecisionTime = held.DecisionTime.Format(time.RFC3339)
+		}
+		if !held.ReleaseTime.IsZero() {
+			info.ReleaseTime = held.ReleaseTime.Format(time.RFC3339)
+		}
+		if sum, err := serv.signer.Summarize(held.Item); err == nil {
+			for _, o := range sum.Outs {
+				info.Outputs = append(info.Outputs, &common.TxOutInfo{
+					Addr:   o.Addr,
+					Value:  o.Value,
+					Change: o.Change,
+				})
+			}
+			info.InValue = sum.InValue
+			info.Leaving = sum.Leaving()
+			info.Fee = sum.Fee
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].TimeReceived > infos[j].TimeReceived
+	})
+	return infos, nil
+}
+
+// ApprovalPage shows the approval queue and lets the operator approve or reject transactions.
+// Decisions must come from a page of our own origin carrying the csrf value of the form.
+func (serv *Service) ApprovalPage(w http.ResponseWriter, r *http.Request) {
+	tmpl, err := template.ParseFiles(serv.tmpl)
+	if err != nil {
+		log.Errorf("[Rest] ApprovalPage: failed to parse template: %v", err)
+		http.Error(w, "template not found", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	data := map[string]interface{}{}
+	if r.Method == http.MethodPost {
+		if !sameOrigin(r) {
+			log.Errorf("[Rest] ApprovalPage: cross-origin request from %s refused", r.RemoteAddr)
+			http.Error(w, "cross-origin request refused", http.StatusForbidden)
+			return
+		}
+		r.ParseForm()
+		req := &common.DecisionReq{
+			AdminReq: common.AdminReq{Token: r.PostForm.Get("token")},
+			TxHash:   r.PostForm.Get("txhash"),
+		}
+		operator, ok := serv.operatorOf(req.Token, "")
+		if !ok {
+			data["msg"] = "wrong operator token"
+		} else {
+			req.Operator = operator
+			data["token"] = req.Token
+			data["operator"] = operator
+			data["csrf"] = serv.csrf
+			switch action := r.PostForm.Get("action"); action {
+			case common.ACTION_APPROVE, common.ACTION_REJECT:
+				if subtle.ConstantTimeCompare([]byte(r.PostForm.Get("csrf")), []byte(serv.csrf)) != 1 {
+					log.Errorf("[Rest] ApprovalPage: %s tx %s by %s without a valid csrf value", action,
+						req.TxHash, operator)
+					data["msg"] = "the form is expired, reload the page and try again"
+					break
+				}
+				f := serv.signer.Approve
+				if action == common.ACTION_REJECT {
+					f = serv.signer.Reject
+				}
+				if err = serv.doDecide(req, f); err != nil {
+					data["msg"] = fmt.Sprintf("failed to %s tx %s: %v", action, req.TxHash, err)
+				} else {
+					data["msg"] = fmt.Sprintf("tx %s: %s by %s", req.TxHash, action, req.Operator)
+					log.Infof("[Rest] ApprovalPage: %s tx %s by %s", action, req.TxHash, req.Operator)
+				}
+			}
+			if data["items"], err = serv.getHeldInfos(serv.signer.GetApprovals); err != nil {
+				data["msg"] = fmt.Sprintf("failed to get approvals: %v", err)
+			}
+		}
+	}
+	w.Header().Set("content-type", "text/html;charset=utf-8")
+	if err = tmpl.Execute(w, data); err != nil {
+		log.Errorf("[Rest] ApprovalPage: failed to render: %v", err)
+	}
+}
+
+// sameOrigin tells if r comes from a page of our own host by its Origin or Referer header.
+// Browsers send Origin with every cross-origin POST, so a request with neither header
+// doesn't come from a page of another site.
+func sameOrigin(r *http.Request) bool {
+	from := r.Header.Get("Origin")
+	if from == "" {
+		from = r.Header.Get("Referer")
+	}
+	if from == "" {
+		return true
+	}
+	u, err := url.Parse(from)
+	return err == nil && u.Host == r.Host
+}
+
+func (serv *Service) ExportPSBT(params map[string]interface{}) map[string]interface{} {
+	resp := &common.Response{
+		Action: common.ACTION_EXPORT,
+	}
+	req := &common.PSBTReq{}
+	if err := utils.ParseParams(req, params); err != nil {
+		log.Errorf("[Rest] ExportPSBT: decode params failed, err: %s", err)
+		resp.Error = restful.INVALID_PARAMS
+		resp.Desc = fmt.Sprintf("ExportPSBT: decode params failed, err: %s", err)
+		m, _ := utils.RefactorResp(resp, resp.Error)
+		return m
+	}
+	if !serv.checkToken(req.Token) {
+		log.Errorf("[Rest] ExportPSBT: unauthorized request")
+		resp.Error = restful.UNAUTHORIZED
+		resp.Desc = "ExportPSBT: wrong admin token"
+		m, _ := utils.RefactorResp(resp, resp.Error)
+		return m
+	}
+	txid, err := chainhash.NewHashFromStr(req.TxHash)
+	if err != nil {
+		log.Errorf("[Rest] ExportPSBT: decode txhash failed, err: %s", err)
+		resp.Error = restful.INVALID_PARAMS
+		resp.Desc = fmt.Sprintf("ExportPSBT: decode txhash failed, err: %s", err)
+		m, _ := utils.RefactorResp(resp, resp.Error)
+		return m
+	}
+	p, err := serv.signer.ExportPSBT(txid)
+	if err != nil {
+		log.Errorf("[Rest] ExportPSBT: %v", err)
+		resp.Error = restful.INTERNAL_ERROR
+		resp.Desc = fmt.Sprintf("ExportPSBT: %v", err)
+		m, _ := utils.RefactorResp(resp, resp.Error)
+		return m
+	}
+	raw, err := p.Serialize()
+	if err != nil {
+		log.Errorf("[Rest] ExportPSBT: serialize failed, err: %v", err)
+		resp.Error = restful.INTERNAL_ERROR
+		resp.Desc = fmt.Sprintf("ExportPSBT: serialize failed, err: %v", err)
+		m, _ := utils.RefactorResp(resp, resp.Error)
+		return m
+	}
+	resp.Result = &common.PSBTInfo{
+		TxHash: txid.String(),
+		PSBT:   base64.StdEncoding.EncodeToString(raw),
+	}
+
+	m, err := utils.RefactorResp(resp, resp.Error)
+	if err != nil {
+		log.Errorf("[Rest] ExportPSBT: failed, err: %v", err)
+	}
+	return m
+}
+
+func (serv *Service) ImportPSBT(params map[string]interface{}) map[string]interface{} {
+	resp := &common.Response{
+		Action: common.ACTION_IMPORT,
+	}
+	req := &common.ImportPSBTReq{}
+	if err := utils.ParseParams(req, params); err != nil {
+		log.Errorf("[Rest] ImportPSBT: decode params failed, err: %s", err)
+		resp.Error = restful.INVALID_PARAMS
+		resp.Desc = fmt.Sprintf("ImportPSBT: decode params failed, err: %s", err)
+		m, _ := utils.RefactorResp(resp, resp.Error)
+		return m
+	}
+	operator, ok := serv.operatorOf(req.Token, req.Operator)
+	if !ok {
+		log.Errorf("[Rest] ImportPSBT: unauthorized request from %v", params["host"])
+		resp.Error = restful.UNAUTHORIZED
+		resp.Desc = "ImportPSBT: wrong operator token"
+		m, _ := utils.RefactorResp(resp, resp.Error)
+		return m
+	}
+	req.Operator = operator
+	raw, err := base64.StdEncoding.DecodeString(req.PSBT)
+	if err != nil {
+		log.Errorf("[Rest] ImportPSBT: decode psbt failed, err: %s", err)
+		resp.Error = restful.ILLEGAL_DATAFORMAT
+		resp.Desc = fmt.Sprintf("ImportPSBT: decode psbt failed, err: %s", err)
+		m, _ := utils.RefactorResp(resp, resp.Error)
+		return m
+	}
+	p := &locutil.PSBT{}
+	if err = p.Deserialize(raw); err != nil {
+		log.Errorf("[Rest] ImportPSBT: deserialize failed, err: %s", err)
+		resp.Error = restful.ILLEGAL_DATAFORMAT
+		resp.Desc = fmt.Sprintf("ImportPSBT: deserialize failed, err: %s", err)
+		m, _ := utils.RefactorResp(resp, resp.Error)
+		return m
+	}
+	if err = serv.signer.ImportPSBT(p, req.Operator); err != nil {
+		log.Errorf("[Rest] ImportPSBT: %v", err)
+		resp.Error = restful.INTERNAL_ERROR
+		resp.Desc = fmt.Sprintf("ImportPSBT: %v", err)
+		m, _ := utils.RefactorResp(resp, resp.Error)
+		return m
+	}
+
+	m, err := utils.RefactorResp(resp, resp.Error)
+	if err != nil {
+		log.Errorf("[Rest] ImportPSBT: failed, err: %v", err)
+	} else {
+		log.Infof("[Rest] ImportPSBT: tx %s by %s from %v", p.Tx.TxHash().String(), req.Operator, params["host"])
+	}
+	return m
+}
+
+func (serv *Service) Freeze(params map[string]interface{}) map[string]interface{} {
+	return serv.freezeAction(common.ACTION_FREEZE, params, func(req *common.FreezeReq) error {
+		return serv.signer.Freeze(fmt.Sprintf("%s from REST", req.Operator))
+	})
+}
+
+func (serv *Service) Unfreeze(params map[string]interface{}) map[string]interface{} {
+	return serv.freezeAction(common.ACTION_UNFREEZE, params, func(req *common.FreezeReq) error {
+		switch req.Backlog {
+		case common.BACKLOG_SIGN:
+			return serv.signer.Unfreeze(req.Operator, true)
+		case common.BACKLOG_DISCARD:
+			return serv.signer.Unfreeze(req.Operator, false)
+		default:
+			return fmt.Errorf("backlog must be %s or %s", common.BACKLOG_SIGN, common.BACKLOG_DISCARD)
+		}
+	})
+}
+
+func (serv *Service) freezeAction(action string, params map[string]interface{},
+	f func(*common.FreezeReq) error) map[string]interface{} {
+	resp := &common.Response{
+		Action: action,
+	}
+	req := &common.FreezeReq{}
+	if err := utils.ParseParams(req, params); err != nil {
+		log.Errorf("[Rest] %s: decode params failed, err: %s", action, err)
+		resp.Error = restful.INVALID_PARAMS
+		resp.Desc = fmt.Sprintf("%s: decode params failed, err: %s", action, err)
+		m, _ := utils.RefactorResp(resp, resp.Error)
+		return m
+	}
+	operator, ok := serv.operatorOf(req.Token, req.Operator)
+	if !ok {
+		log.Errorf("[Rest] %s: unauthorized request from %v", action, params["host"])
+		resp.Error = restful.UNAUTHORIZED
+		resp.Desc = fmt.Sprintf("%s: wrong operator token", action)
+		m, _ := utils.RefactorResp(resp, resp.Error)
+		return m
+	}
+	req.Operator = operator
+	if err := f(req); err != nil {
+		log.Errorf("[Rest] %s: %v", action, err)
+		resp.Error = restful.INTERNAL_ERROR
+		resp.Desc = fmt.Sprintf("%s: %v", action, err)
+		m, _ := utils.RefactorResp(resp, resp.Error)
+		return m
+	}
+
+	m, err := utils.RefactorResp(resp, resp.Error)
+	if err != nil {
+		log.Errorf("[Rest] %s: failed, err: %v", action, err)
+	} else {
+		log.Infof("[Rest] %s: by %s from %v", action, req.Operator, params["host"])
+	}
+	return m
+}
@@ -0,0 +1,119 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package signer
+
+import (
+	"fmt"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/polynetwork/btc-vendor-tools/log"
+	"github.com/polynetwork/btc-vendor-tools/utils"
+	"time"
+)
+
+const REASON_OPERATOR_REJECTED = "operator_rejected"
+
+func (signer *Signer) needApproval(sum *TxSummary) bool {
+	return signer.policy.conf.ApprovalValue > 0 && sum.Leaving() > signer.policy.conf.ApprovalValue
+}
+
+func (signer *Signer) holdForApproval(item *utils.ToSignItem, sum *TxSummary) error {
+	key := utils.GetUnsignedTxHash(item.Mtx)
+	if old, err := signer.vdb.GetApproval(key[:]); err == nil {
+		log.Infof("[Signer] tx %s already in approval queue with status %d", key.String(), old.Status)
+		return nil
+	}
+	if err := signer.vdb.PutApproval(key[:], &utils.HeldItem{
+		Item:         item,
+		TimeReceived: time.Now(),
+		Status:       utils.HELD_PENDING,
+	}); err != nil {
+		log.Errorf("[Signer] failed to put tx %s into approval queue: %v", key.String(), err)
+		return err
+	}
+	log.Warnf("[Signer] tx %s sends out %d satoshi and waits for approval of the operator", key.String(),
+		sum.Leaving())
+	return nil
+}
+
+// GetApprovals returns all transactions waiting for approval or decided by the operator.
+func (signer *Signer) GetApprovals() (map[chainhash.Hash]*utils.HeldItem, error) {
+	return signer.vdb.GetAllApprovals()
+}
+
+// Approve checks the held tx again and signs it, or puts it into the veto window first if
+// it's big enough to be delayed, so an approval never skips the delay. operator is recorded
+// with the decision.
+func (signer *Signer) Approve(txid *chainhash.Hash, operator string) error {
+	signer.lock.Lock()
+	defer signer.lock.Unlock()
+
+	if signer.IsFrozen() {
+		return fmt.Errorf("[Signer] signing is frozen")
+	}
+	held, err := signer.getPendingApproval(txid)
+	if err != nil {
+		return err
+	}
+	sum, err := signer.check(held.Item)
+	if err != nil {
+		return fmt.Errorf("[Signer] tx %s failed to pass checks: %v", txid.String(), err)
+	}
+	held.Status = utils.HELD_APPROVED
+	held.Operator = operator
+	held.DecisionTime = time.Now()
+	if err = signer.vdb.PutApproval(txid[:], held); err != nil {
+		return fmt.Errorf("[Signer] failed to save approval of tx %s: %v", txid.String(), err)
+	}
+	log.Infof("[Signer] tx %s approved by %s", txid.String(), operator)
+	if signer.needDelay(sum) {
+		return signer.holdForDelay(held.Item, sum)
+	}
+	return signer.sign(held.Item, sum)
+}
+
+// Reject refuses to sign the held tx. operator is recorded with the decision.
+func (signer *Signer) Reject(txid *chainhash.Hash, operator string) error {
+	signer.lock.Lock()
+	defer signer.lock.Unlock()
+
+	held, err := signer.getPendingApproval(txid)
+	if err != nil {
+		return err
+	}
+	held.Status = utils.HELD_REJECTED
+	held.Operator = operator
+	held.DecisionTime = time.Now()
+	if err = signer.vdb.PutApproval(txid[:], held); err != nil {
+		return fmt.Errorf("[Signer] failed to save rejection of tx %s: %v", txid.String(), err)
+	}
+	signer.reject(held.Item, PolicyError{
+		Reason: REASON_OPERATOR_REJECTED,
+		Desc:   fmt.Sprintf("rejected by %s", operator),
+	})
+	return nil
+}
+
+func (signer *Signer) getPendingApproval(txid *chainhash.Hash) (*utils.HeldItem, error) {
+	held, err := signer.vdb.GetApproval(txid[:])
+	if err != nil {
+		return nil, fmt.Errorf("[Signer] failed to get tx %s from approval queue: %v", txid.String(), err)
+	}
+	if held.Status != utils.HELD_PENDING {
+		return nil, fmt.Errorf("[Signer] tx %s already decided by %s", txid.String(), held.Operator)
+	}
+	return held, nil
+}
@@ -0,0 +1,88 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package signer
+
+import (
+	"github.com/polynetwork/btc-vendor-tools/config"
+	"github.com/polynetwork/btc-vendor-tools/db"
+	"github.com/polynetwork/btc-vendor-tools/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestSigner_Approve(t *testing.T) {
+	vdb, err := db.NewVendorDB("./temp")
+	assert.NoError(t, err)
+	defer os.RemoveAll("./temp")
+	signer := getKeySigner(t)
+	signer.vdb = vdb
+	signer.policy = NewPolicy(&config.SignPolicy{ApprovalValue: 10000}, vdb)
+	signer.wake = make(chan struct{}, 1)
+
+	item, _ := getKeyItem(signer)
+	key := utils.GetUnsignedTxHash(item.Mtx)
+	assert.NoError(t, signer.Sign(item))
+	held, err := vdb.GetApproval(key[:])
+	assert.NoError(t, err)
+	assert.Equal(t, utils.HELD_PENDING, held.Status)
+	_, err = vdb.GetOutbox(key[:])
+	assert.Error(t, err)
+
+	assert.NoError(t, signer.Approve(&key, "alice"))
+	held, _ = vdb.GetApproval(key[:])
+	assert.Equal(t, utils.HELD_APPROVED, held.Status)
+	assert.Equal(t, "alice", held.Operator)
+	ob, err := vdb.GetOutbox(key[:])
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(ob.Sigs))
+	assert.Error(t, signer.Reject(&key, "bob"))
+
+	item, _ = getKeyItem(signer)
+	item.Mtx.TxIn[0].PreviousOutPoint.Index = 5
+	item.Mtx.TxIn[1].PreviousOutPoint.Index = 6
+	key = utils.GetUnsignedTxHash(item.Mtx)
+	assert.NoError(t, signer.Sign(item))
+	assert.NoError(t, signer.Reject(&key, "bob"))
+	held, _ = vdb.GetApproval(key[:])
+	assert.Equal(t, utils.HELD_REJECTED, held.Status)
+	rejected, err := vdb.GetRejectedTx(key[:])
+	assert.NoError(t, err)
+	assert.Equal(t, REASON_OPERATOR_REJECTED, rejected.Reason)
+}
+
+func TestSigner_ApproveDelayed(t *testing.T) {
+	vdb, err := db.NewVendorDB("./temp")
+	assert.NoError(t, err)
+	defer os.RemoveAll("./temp")
+	signer := getKeySigner(t)
+	signer.vdb = vdb
+	signer.policy = NewPolicy(&config.SignPolicy{ApprovalValue: 10000, DelayValue: 10000, DelayMinutes: 10}, vdb)
+	signer.wake = make(chan struct{}, 1)
+
+	item, _ := getKeyItem(signer)
+	key := utils.GetUnsignedTxHash(item.Mtx)
+	assert.NoError(t, signer.Sign(item))
+	assert.NoError(t, signer.Approve(&key, "alice"))
+
+	// approved, and still waits for the veto window
+	held, err := vdb.GetDelayed(key[:])
+	assert.NoError(t, err)
+	assert.Equal(t, utils.HELD_PENDING, held.Status)
+	_, err = vdb.GetOutbox(key[:])
+	assert.Error(t, err)
+}
@@ -0,0 +1,91 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package signer
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/polynetwork/btc-vendor-tools/log"
+	"github.com/polynetwork/btc-vendor-tools/utils"
+)
+
+const REASON_DOUBLE_SPEND = "double_spend"
+
+// checkConflicts refuses item if any of its inputs was already signed by us in another
+// transaction, unless the operator has overridden it for this transaction.
+func (signer *Signer) checkConflicts(item *utils.ToSignItem) error {
+	if signer.vdb == nil {
+		return nil
+	}
+	key := utils.GetUnsignedTxHash(item.Mtx)
+	for i, in := range item.Mtx.TxIn {
+		spender, err := signer.vdb.GetOutpointSpender(&in.PreviousOutPoint)
+		if err != nil {
+			return fmt.Errorf("failed to get spender of outpoint %s: %v", in.PreviousOutPoint.String(), err)
+		}
+		if spender == nil || bytes.Equal(spender, key[:]) {
+			continue
+		}
+		ok, err := signer.vdb.IsConflictOverridden(key[:])
+		if err != nil {
+			return fmt.Errorf("failed to check override for tx %s: %v", key.String(), err)
+		}
+		other, _ := chainhash.NewHash(spender)
+		if ok {
+			log.Warnf("[Signer] tx %s spends outpoint %s already signed in tx %s, allowed by operator",
+				key.String(), in.PreviousOutPoint.String(), other.String())
+			return nil
+		}
+		log.Errorf("[Signer][ALERT] tx %s tries to double spend outpoint %s already signed in tx %s",
+			key.String(), in.PreviousOutPoint.String(), other.String())
+		return PolicyError{
+			Reason: REASON_DOUBLE_SPEND,
+			Desc: fmt.Sprintf("No.%d input %s already signed in tx %s", i, in.PreviousOutPoint.String(),
+				other.String()),
+		}
+	}
+	return nil
+}
+
+// recordOutpoints remembers all outpoints of item as spent by it. It fails if the index
+// can't be saved, and then item must not be signed.
+func (signer *Signer) recordOutpoints(item *utils.ToSignItem) error {
+	if signer.vdb == nil {
+		return nil
+	}
+	key := utils.GetUnsignedTxHash(item.Mtx)
+	ops := make([]*wire.OutPoint, len(item.Mtx.TxIn))
+	for i, in := range item.Mtx.TxIn {
+		ops[i] = &in.PreviousOutPoint
+	}
+	if err := signer.vdb.PutSignedOutpoints(key[:], ops); err != nil {
+		return fmt.Errorf("failed to save outpoints of tx %s into db: %v", key.String(), err)
+	}
+	return nil
+}
+
+// AllowConflict lets the tx with unsigned txid be signed even if it conflicts with
+// a transaction we signed before.
+func (signer *Signer) AllowConflict(txid *chainhash.Hash) error {
+	if err := signer.vdb.PutConflictOverride(txid[:]); err != nil {
+		return fmt.Errorf("[Signer] failed to save override for tx %s: %v", txid.String(), err)
+	}
+	log.Infof("[Signer] operator allows tx %s to spend outpoints already signed", txid.String())
+	return nil
+}
@@ -0,0 +1,56 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package signer
+
+import (
+	"github.com/polynetwork/btc-vendor-tools/db"
+	"github.com/polynetwork/btc-vendor-tools/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestSigner_checkConflicts(t *testing.T) {
+	vdb, err := db.NewVendorDB("./temp")
+	assert.NoError(t, err)
+	defer os.RemoveAll("./temp")
+	signer := getKeySigner(t)
+	signer.vdb = vdb
+
+	item, _ := getKeyItem(signer)
+	assert.NoError(t, signer.checkConflicts(item))
+	assert.NoError(t, signer.recordOutpoints(item))
+	assert.NoError(t, signer.checkConflicts(item))
+
+	other, _ := getKeyItem(signer)
+	other.Mtx.TxOut[0].Value = 14000
+	err = signer.checkConflicts(other)
+	assert.Equal(t, REASON_DOUBLE_SPEND, err.(PolicyError).Reason)
+
+	txid := utils.GetUnsignedTxHash(other.Mtx)
+	assert.NoError(t, signer.AllowConflict(&txid))
+	assert.NoError(t, signer.checkConflicts(other))
+
+	// the first spender is kept
+	assert.NoError(t, signer.recordOutpoints(other))
+	key := utils.GetUnsignedTxHash(item.Mtx)
+	spender, _ := vdb.GetOutpointSpender(&item.Mtx.TxIn[0].PreviousOutPoint)
+	assert.Equal(t, key[:], spender)
+
+	vdb.Close()
+	assert.Error(t, signer.recordOutpoints(item))
+}
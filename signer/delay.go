@@ -0,0 +1,195 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package signer
+
+import (
+	"fmt"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/polynetwork/btc-vendor-tools/log"
+	"github.com/polynetwork/btc-vendor-tools/utils"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	REASON_VETOED = "vetoed"
+
+	DELAY_CHECK_INTERVAL = 10 * time.Second
+)
+
+func (signer *Signer) needDelay(sum *TxSummary) bool {
+	return signer.policy.conf.DelayMinutes > 0 && sum.Leaving() > signer.policy.conf.DelayValue
+}
+
+// holdForDelay schedules item to be signed after the veto window. The schedule is saved
+// in db so a restart doesn't change it.
+func (signer *Signer) holdForDelay(item *utils.ToSignItem, sum *TxSummary) error {
+	key := utils.GetUnsignedTxHash(item.Mtx)
+	if old, err := signer.vdb.GetDelayed(key[:]); err == nil {
+		log.Infof("[Signer] tx %s already delayed with status %d", key.String(), old.Status)
+		return nil
+	}
+	now := time.Now()
+	held := &utils.HeldItem{
+		Item:         item,
+		TimeReceived: now,
+		Status:       utils.HELD_PENDING,
+		ReleaseTime:  now.Add(time.Duration(signer.policy.conf.DelayMinutes) * time.Minute),
+	}
+	if err := signer.vdb.PutDelayed(key[:], held); err != nil {
+		log.Errorf("[Signer] failed to put tx %s into delay queue: %v", key.String(), err)
+		return err
+	}
+	log.Warnf("[Signer] tx %s sends out %d satoshi and would be signed at %s unless vetoed", key.String(),
+		sum.Leaving(), held.ReleaseTime.Format(time.RFC3339))
+	return nil
+}
+
+// GetDelayed returns all delayed transactions and their results.
+func (signer *Signer) GetDelayed() (map[chainhash.Hash]*utils.HeldItem, error) {
+	return signer.vdb.GetAllDelayed()
+}
+
+// Veto refuses to sign a delayed tx before its veto window passes.
+func (signer *Signer) Veto(txid *chainhash.Hash, operator string) error {
+	signer.lock.Lock()
+	defer signer.lock.Unlock()
+
+	held, err := signer.vdb.GetDelayed(txid[:])
+	if err != nil {
+		return fmt.Errorf("[Signer] failed to get tx %s from delay queue: %v", txid.String(), err)
+	}
+	if held.Status != utils.HELD_PENDING {
+		return fmt.Errorf("[Signer] tx %s is not waiting in delay queue", txid.String())
+	}
+	held.Status = utils.HELD_REJECTED
+	held.Operator = operator
+	held.DecisionTime = time.Now()
+	if err = signer.vdb.PutDelayed(txid[:], held); err != nil {
+		return fmt.Errorf("[Signer] failed to save veto of tx %s: %v", txid.String(), err)
+	}
+	signer.reject(held.Item, PolicyError{
+		Reason: REASON_VETOED,
+		Desc:   fmt.Sprintf("vetoed by %s", operator),
+	})
+	return nil
+}
+
+// Releasing signs delayed transactions whose veto window passed, and vetoes those
+// with a file in VetoDir.
+func (signer *Signer) Releasing() {
+	log.Infof("[Signer] start releasing delayed transactions")
+	ticker := time.NewTicker(DELAY_CHECK_INTERVAL)
+	defer ticker.Stop()
+	for range ticker.C {
+		signer.releaseDelayed()
+	}
+}
+
+func (signer *Signer) releaseDelayed() {
+	if signer.IsFrozen() {
+		return
+	}
+	items, err := signer.vdb.GetAllDelayed()
+	if err != nil {
+		log.Errorf("[Signer] failed to read delay queue: %v", err)
+		return
+	}
+	vetoes := signer.readVetoDir()
+	for key, held := range items {
+		if held.Status != utils.HELD_PENDING {
+			continue
+		}
+		key := key
+		if operator, ok := vetoes[key.String()]; ok {
+			if err := signer.Veto(&key, operator); err != nil {
+				log.Errorf("[Signer] %v", err)
+			}
+			continue
+		}
+		if time.Now().Before(held.ReleaseTime) {
+			continue
+		}
+		if err := signer.release(&key); err != nil {
+			log.Errorf("[Signer] failed to sign delayed tx %s: %v", key.String(), err)
+		}
+	}
+}
+
+// release checks the delayed tx again and signs it.
+func (signer *Signer) release(txid *chainhash.Hash) error {
+	signer.lock.Lock()
+	defer signer.lock.Unlock()
+
+	if signer.IsFrozen() {
+		return nil
+	}
+	held, err := signer.vdb.GetDelayed(txid[:])
+	if err != nil {
+		return err
+	}
+	if held.Status != utils.HELD_PENDING {
+		return nil
+	}
+	sum, err := signer.check(held.Item)
+	if err != nil {
+		held.Status = utils.HELD_REJECTED
+	} else {
+		held.Status = utils.HELD_APPROVED
+	}
+	held.DecisionTime = time.Now()
+	if err := signer.vdb.PutDelayed(txid[:], held); err != nil {
+		return fmt.Errorf("failed to save result: %v", err)
+	}
+	if err != nil {
+		return err
+	}
+	log.Infof("[Signer] veto window of tx %s passed, sign it", txid.String())
+	return signer.sign(held.Item, sum)
+}
+
+// readVetoDir returns vetoes in VetoDir, from the file name which is an unsigned txid to
+// the operator written in the file.
+func (signer *Signer) readVetoDir() map[string]string {
+	res := make(map[string]string)
+	dir := signer.policy.conf.VetoDir
+	if dir == "" {
+		return res
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Errorf("[Signer] failed to read veto dir %s: %v", dir, err)
+		}
+		return res
+	}
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		operator := "file " + f.Name()
+		if raw, err := ioutil.ReadFile(filepath.Join(dir, f.Name())); err == nil &&
+			strings.TrimSpace(string(raw)) != "" {
+			operator = strings.TrimSpace(string(raw))
+		}
+		res[strings.TrimSuffix(f.Name(), filepath.Ext(f.Name()))] = operator
+	}
+	return res
+}
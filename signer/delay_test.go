@@ -0,0 +1,74 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package signer
+
+import (
+	"github.com/polynetwork/btc-vendor-tools/config"
+	"github.com/polynetwork/btc-vendor-tools/db"
+	"github.com/polynetwork/btc-vendor-tools/utils"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSigner_releaseDelayed(t *testing.T) {
+	vdb, err := db.NewVendorDB("./temp")
+	assert.NoError(t, err)
+	defer os.RemoveAll("./temp")
+	assert.NoError(t, os.MkdirAll("./veto", 0755))
+	defer os.RemoveAll("./veto")
+	signer := getKeySigner(t)
+	signer.vdb = vdb
+	signer.policy = NewPolicy(&config.SignPolicy{DelayValue: 10000, DelayMinutes: 30, VetoDir: "./veto"}, vdb)
+
+	item, _ := getKeyItem(signer)
+	key := utils.GetUnsignedTxHash(item.Mtx)
+	assert.NoError(t, signer.Sign(item))
+	held, err := vdb.GetDelayed(key[:])
+	assert.NoError(t, err)
+	assert.Equal(t, utils.HELD_PENDING, held.Status)
+	assert.True(t, held.ReleaseTime.After(time.Now().Add(29*time.Minute)))
+
+	signer.releaseDelayed()
+	held, _ = vdb.GetDelayed(key[:])
+	assert.Equal(t, utils.HELD_PENDING, held.Status)
+
+	held.ReleaseTime = time.Now()
+	assert.NoError(t, vdb.PutDelayed(key[:], held))
+	signer.releaseDelayed()
+	held, _ = vdb.GetDelayed(key[:])
+	assert.Equal(t, utils.HELD_APPROVED, held.Status)
+	_, err = vdb.GetOutbox(key[:])
+	assert.NoError(t, err)
+
+	item, _ = getKeyItem(signer)
+	item.Mtx.TxIn[0].PreviousOutPoint.Index = 5
+	item.Mtx.TxIn[1].PreviousOutPoint.Index = 6
+	key = utils.GetUnsignedTxHash(item.Mtx)
+	assert.NoError(t, signer.Sign(item))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join("./veto", key.String()), []byte("carol\n"), 0644))
+	signer.releaseDelayed()
+	held, _ = vdb.GetDelayed(key[:])
+	assert.Equal(t, utils.HELD_REJECTED, held.Status)
+	assert.Equal(t, "carol", held.Operator)
+	rejected, err := vdb.GetRejectedTx(key[:])
+	assert.NoError(t, err)
+	assert.Equal(t, REASON_VETOED, rejected.Reason)
+}
@@ -0,0 +1,128 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package signer
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcutil"
+	"github.com/polynetwork/btc-vendor-tools/config"
+	"github.com/polynetwork/btc-vendor-tools/log"
+	"github.com/polynetwork/btc-vendor-tools/utils"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const REASON_DENIED_ADDRESS = "denied_address"
+
+// DenyList is a set of addresses and script hashes we never pay to. It's loaded from a
+// file with one address or hex hash per line, and reloaded when the file changes.
+type DenyList struct {
+	sync.Mutex
+	file    string
+	modTime time.Time
+	loaded  bool
+	addrs   map[string]bool
+	hashes  map[string]bool
+}
+
+func NewDenyList(file string) *DenyList {
+	return &DenyList{
+		file:   file,
+		addrs:  make(map[string]bool),
+		hashes: make(map[string]bool),
+	}
+}
+
+// reload reads the file again if it changed. If it fails, the last list is kept.
+func (d *DenyList) reload() error {
+	fi, err := os.Stat(d.file)
+	if err != nil {
+		return fmt.Errorf("failed to stat deny list %s: %v", d.file, err)
+	}
+	if d.loaded && fi.ModTime().Equal(d.modTime) {
+		return nil
+	}
+	f, err := os.Open(d.file)
+	if err != nil {
+		return fmt.Errorf("failed to open deny list %s: %v", d.file, err)
+	}
+	defer f.Close()
+
+	addrs, hashes := make(map[string]bool), make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if idx := strings.Index(line, "#"); idx >= 0 {
+			line = strings.TrimSpace(line[:idx])
+		}
+		if line == "" {
+			continue
+		}
+		if raw, err := hex.DecodeString(line); err == nil && (len(raw) == 20 || len(raw) == 32) {
+			hashes[strings.ToLower(line)] = true
+			continue
+		}
+		if addr, err := btcutil.DecodeAddress(line, config.BtcNetParam); err == nil {
+			line = addr.EncodeAddress()
+		} else {
+			log.Warnf("[Signer] %s in deny list is not a valid address: %v", line, err)
+		}
+		addrs[line] = true
+	}
+	if err = scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read deny list %s: %v", d.file, err)
+	}
+	d.addrs, d.hashes = addrs, hashes
+	d.modTime, d.loaded = fi.ModTime(), true
+	log.Infof("[Signer] deny list loaded with %d addresses and %d hashes", len(addrs), len(hashes))
+	return nil
+}
+
+// Check returns a PolicyError if any output of item pays an address in the list.
+func (d *DenyList) Check(item *utils.ToSignItem) error {
+	d.Lock()
+	defer d.Unlock()
+
+	if err := d.reload(); err != nil {
+		if !d.loaded {
+			return err
+		}
+		log.Errorf("[Signer] failed to reload deny list and use the last one: %v", err)
+	}
+	for i, out := range item.Mtx.TxOut {
+		_, addrs, _, err := txscript.ExtractPkScriptAddrs(out.PkScript, config.BtcNetParam)
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if d.addrs[addr.EncodeAddress()] || d.hashes[hex.EncodeToString(addr.ScriptAddress())] {
+				log.Errorf("[Signer][ALERT] tx %s pays %s in deny list", utils.GetUnsignedTxHash(item.Mtx).String(),
+					addr.EncodeAddress())
+				return PolicyError{
+					Reason: REASON_DENIED_ADDRESS,
+					Desc:   fmt.Sprintf("No.%d output pays %s in deny list", i, addr.EncodeAddress()),
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package signer
+
+import (
+	"encoding/hex"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcutil"
+	"github.com/polynetwork/btc-vendor-tools/config"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDenyList_Check(t *testing.T) {
+	signer := getKeySigner(t)
+	item, _ := getKeyItem(signer)
+	hash := btcutil.Hash160([]byte("bad"))
+	addr, _ := btcutil.NewAddressPubKeyHash(hash, config.BtcNetParam)
+	item.Mtx.TxOut[0].PkScript, _ = txscript.PayToAddrScript(addr)
+
+	defer os.Remove("./deny")
+	assert.NoError(t, ioutil.WriteFile("./deny", []byte("# nothing\n"), 0644))
+	d := NewDenyList("./deny")
+	assert.NoError(t, d.Check(item))
+
+	assert.NoError(t, ioutil.WriteFile("./deny", []byte(addr.EncodeAddress()+" # bad guy\n"), 0644))
+	os.Chtimes("./deny", time.Now(), time.Now().Add(time.Second))
+	err := d.Check(item)
+	assert.Equal(t, REASON_DENIED_ADDRESS, err.(PolicyError).Reason)
+
+	assert.NoError(t, ioutil.WriteFile("./deny", []byte(hex.EncodeToString(hash)+"\n"), 0644))
+	os.Chtimes("./deny", time.Now(), time.Now().Add(2*time.Second))
+	err = d.Check(item)
+	assert.Equal(t, REASON_DENIED_ADDRESS, err.(PolicyError).Reason)
+
+	os.Remove("./deny")
+	err = d.Check(item)
+	assert.Equal(t, REASON_DENIED_ADDRESS, err.(PolicyError).Reason)
+	assert.Error(t, NewDenyList("./deny").Check(item))
+}
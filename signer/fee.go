@@ -0,0 +1,153 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package signer
+
+import (
+	"fmt"
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/polynetwork/btc-vendor-tools/config"
+	"github.com/polynetwork/btc-vendor-tools/log"
+	"github.com/polynetwork/btc-vendor-tools/utils"
+	"github.com/polynetwork/poly/common"
+	"github.com/polynetwork/poly/native/service/governance/side_chain_manager"
+	putils "github.com/polynetwork/poly/native/service/utils"
+	"sync"
+	"time"
+)
+
+const (
+	REASON_FEE_RATE = "fee_rate"
+
+	FEE_PARAM_CACHE_TIME = 10 * time.Minute
+	// length of a DER signature with sighash type in the worst case
+	MAX_SIG_SIZE = 73
+)
+
+// feeParamCache keeps the BtcTxParam registered on poly for our redeem.
+type feeParamCache struct {
+	sync.Mutex
+	detail  *side_chain_manager.BtcTxParamDetial
+	fetched time.Time
+}
+
+func (signer *Signer) getFeeParam(rd *Redeem) (*side_chain_manager.BtcTxParamDetial, error) {
+	rd.feeParam.Lock()
+	defer rd.feeParam.Unlock()
+
+	if rd.feeParam.detail != nil && time.Since(rd.feeParam.fetched) < FEE_PARAM_CACHE_TIME {
+		return rd.feeParam.detail, nil
+	}
+	rk := btcutil.Hash160(rd.redeem)
+	val, err := signer.poly.GetStorage(putils.SideChainManagerContractAddress.ToHexString(), append(append([]byte(
+		side_chain_manager.BTC_TX_PARAM), rk...), putils.GetUint64Bytes(1)...))
+	if err != nil {
+		if rd.feeParam.detail != nil {
+			log.Warnf("[Signer] failed to refresh btc tx param and use the cached one: %v", err)
+			return rd.feeParam.detail, nil
+		}
+		return nil, fmt.Errorf("failed to get btc tx param: %v", err)
+	}
+	if val == nil {
+		return nil, fmt.Errorf("no btc tx param registered for redeem key %x", rk)
+	}
+	d := &side_chain_manager.BtcTxParamDetial{}
+	if err = d.Deserialization(common.NewZeroCopySource(val)); err != nil {
+		return nil, fmt.Errorf("failed to deserialize btc tx param: %v", err)
+	}
+	rd.feeParam.detail = d
+	rd.feeParam.fetched = time.Now()
+	return d, nil
+}
+
+// checkFeeRate refuses item if its fee rate is too far away from the fee rate registered
+// on poly.
+func (signer *Signer) checkFeeRate(item *utils.ToSignItem, sum *TxSummary) error {
+	factor := signer.policy.conf.MaxFeeRateDeviation
+	if factor <= 0 {
+		return nil
+	}
+	rd, err := signer.redeemOf(item)
+	if err != nil {
+		return err
+	}
+	d, err := signer.getFeeParam(rd)
+	if err != nil {
+		return err
+	}
+	vsize := estimateVsize(rd.redeem, item)
+	rate := float64(sum.Fee) / float64(vsize)
+	expected := float64(d.FeeRate)
+	if rate > expected*factor || rate < expected/factor {
+		return PolicyError{
+			Reason: REASON_FEE_RATE,
+			Desc: fmt.Sprintf("fee rate %.2f sat/vbyte (fee %d, vsize %d) deviates from %d sat/vbyte by more "+
+				"than %.2f times", rate, sum.Fee, vsize, d.FeeRate, factor),
+		}
+	}
+	return nil
+}
+
+// estimateVsize returns the virtual size of item after all m signatures of redeem are collected.
+func estimateVsize(redeem []byte, item *utils.ToSignItem) int {
+	_, _, m, _ := txscript.ExtractPkScriptAddrs(redeem, config.BtcNetParam)
+	redeemPush := len(redeem) + pushDataSize(len(redeem))
+
+	base := 8 + wire.VarIntSerializeSize(uint64(len(item.Mtx.TxIn))) +
+		wire.VarIntSerializeSize(uint64(len(item.Mtx.TxOut)))
+	for _, out := range item.Mtx.TxOut {
+		base += out.SerializeSize()
+	}
+	witness := 0
+	hasWitness := false
+	for _, in := range item.Mtx.TxIn {
+		base += 32 + 4 + 4
+		switch txscript.GetScriptClass(in.SignatureScript) {
+		case txscript.WitnessV0ScriptHashTy:
+			hasWitness = true
+			base += 1
+			witness += wire.VarIntSerializeSize(uint64(m+2)) + 1 + m*(1+MAX_SIG_SIZE) +
+				wire.VarIntSerializeSize(uint64(len(redeem))) + len(redeem)
+		default:
+			ss := 1 + m*(1+MAX_SIG_SIZE) + redeemPush
+			base += wire.VarIntSerializeSize(uint64(ss)) + ss
+			witness += 1
+		}
+	}
+	if !hasWitness {
+		witness = 0
+	} else {
+		witness += 2
+	}
+	weight := base*blockchain.WitnessScaleFactor + witness
+	return (weight + blockchain.WitnessScaleFactor - 1) / blockchain.WitnessScaleFactor
+}
+
+func pushDataSize(l int) int {
+	switch {
+	case l < txscript.OP_PUSHDATA1:
+		return 1
+	case l <= 0xff:
+		return 2
+	case l <= 0xffff:
+		return 3
+	default:
+		return 5
+	}
+}
@@ -0,0 +1,68 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package signer
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/polynetwork/btc-vendor-tools/config"
+	"github.com/polynetwork/poly/native/service/governance/side_chain_manager"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestSigner_estimateVsize(t *testing.T) {
+	signer := getValidateSigner(t)
+	signed := wire.NewMsgTx(wire.TxVersion)
+	buf, _ := hex.DecodeString(swTx)
+	_ = signed.BtcDecode(bytes.NewBuffer(buf), wire.ProtocolVersion, wire.LatestEncoding)
+	weight := blockchain.GetTransactionWeight(btcutil.NewTx(signed))
+	actual := int((weight + 3) / 4)
+
+	est := estimateVsize(theRedeem(signer).redeem, getSwItem())
+	assert.True(t, est >= actual, "estimated %d, actual %d", est, actual)
+	assert.True(t, est-actual <= 5, "estimated %d, actual %d", est, actual)
+}
+
+func TestSigner_checkFeeRate(t *testing.T) {
+	signer := getValidateSigner(t)
+	signer.policy = NewPolicy(&config.SignPolicy{
+		MaxFeeRateDeviation: 2,
+	}, nil)
+	theRedeem(signer).feeParam.detail = &side_chain_manager.BtcTxParamDetial{
+		FeeRate: 10,
+	}
+	theRedeem(signer).feeParam.fetched = time.Now()
+
+	item := getSwItem()
+	vsize := estimateVsize(theRedeem(signer).redeem, item)
+	sum, err := signer.Summarize(item)
+	assert.NoError(t, err)
+
+	sum.Fee = uint64(vsize * 10)
+	assert.NoError(t, signer.checkFeeRate(item, sum))
+	sum.Fee = uint64(vsize * 20)
+	assert.NoError(t, signer.checkFeeRate(item, sum))
+	sum.Fee = uint64(vsize*20 + vsize)
+	assert.Equal(t, REASON_FEE_RATE, signer.checkFeeRate(item, sum).(PolicyError).Reason)
+	sum.Fee = uint64(vsize * 4)
+	assert.Equal(t, REASON_FEE_RATE, signer.checkFeeRate(item, sum).(PolicyError).Reason)
+}
@@ -0,0 +1,187 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package signer
+
+import (
+	"fmt"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/polynetwork/btc-vendor-tools/log"
+	"github.com/polynetwork/btc-vendor-tools/utils"
+	"os"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	REASON_DISCARDED = "discarded"
+
+	FREEZE_CHECK_INTERVAL = time.Second
+)
+
+// IsFrozen tells if signing is frozen. Transactions captured meanwhile are queued in db.
+func (signer *Signer) IsFrozen() bool {
+	return atomic.LoadInt32(&signer.frozen) == 1
+}
+
+// Freeze stops all signing and submitting immediately until Unfreeze is called.
+func (signer *Signer) Freeze(by string) error {
+	if !atomic.CompareAndSwapInt32(&signer.frozen, 0, 1) {
+		return nil
+	}
+	log.Errorf("[Signer][ALERT] signing frozen by %s", by)
+	if err := signer.vdb.SetFreeze(true); err != nil {
+		return fmt.Errorf("[Signer] failed to save freeze into db: %v", err)
+	}
+	return nil
+}
+
+// Unfreeze restarts signing. The backlog queued while frozen is signed if signBacklog
+// is true and discarded otherwise. Items failing to be signed are left pending.
+func (signer *Signer) Unfreeze(operator string, signBacklog bool) error {
+	if file := signer.policy.conf.FreezeFile; file != "" {
+		if _, err := os.Stat(file); err == nil {
+			return fmt.Errorf("[Signer] remove freeze file %s before unfreezing", file)
+		}
+	}
+	items, err := signer.unfreeze()
+	if err != nil {
+		return err
+	}
+	log.Infof("[Signer] signing unfrozen by %s, sign backlog: %v", operator, signBacklog)
+
+	backlog := make([]*utils.HeldItem, 0, len(items))
+	for _, held := range items {
+		if held.Status == utils.HELD_PENDING {
+			backlog = append(backlog, held)
+		}
+	}
+	sort.Slice(backlog, func(i, j int) bool {
+		return backlog[i].TimeReceived.Before(backlog[j].TimeReceived)
+	})
+	for _, held := range backlog {
+		if !signer.releaseFrozen(held, operator, signBacklog) {
+			break
+		}
+	}
+	return nil
+}
+
+// unfreeze reads the backlog and flips the freeze under lock, so no item is queued after
+// the backlog is read.
+func (signer *Signer) unfreeze() (map[chainhash.Hash]*utils.HeldItem, error) {
+	signer.lock.Lock()
+	defer signer.lock.Unlock()
+
+	if !signer.IsFrozen() {
+		return nil, fmt.Errorf("[Signer] signing is not frozen")
+	}
+	items, err := signer.vdb.GetAllFrozen()
+	if err != nil {
+		return nil, fmt.Errorf("[Signer] failed to read frozen transactions: %v", err)
+	}
+	if err = signer.vdb.SetFreeze(false); err != nil {
+		return nil, fmt.Errorf("[Signer] failed to save unfreeze into db: %v", err)
+	}
+	atomic.StoreInt32(&signer.frozen, 0)
+	return items, nil
+}
+
+// releaseFrozen signs or discards one item of the backlog. It returns false if signing
+// is frozen again, and then the rest of the backlog stays pending. Items failing to be
+// signed for other reasons than rejection are moved to the queue to be retried by Signing.
+func (signer *Signer) releaseFrozen(held *utils.HeldItem, operator string, signBacklog bool) bool {
+	signer.lock.Lock()
+	defer signer.lock.Unlock()
+
+	if signer.IsFrozen() {
+		log.Warnf("[Signer] signing frozen again, the rest of the backlog is left pending")
+		return false
+	}
+	key := utils.GetUnsignedTxHash(held.Item.Mtx)
+	if signBacklog {
+		// signing clears the scripts of inputs, keep them for the retry
+		item := *held.Item
+		item.Mtx = held.Item.Mtx.Copy()
+		err := signer.handle(&item)
+		switch {
+		case err == nil:
+			held.Status = utils.HELD_APPROVED
+		case IsRejection(err):
+			held.Status = utils.HELD_REJECTED
+			held.Reason = reasonOf(err)
+		default:
+			if err := signer.vdb.Requeue([]*utils.ToSignItem{held.Item}); err != nil {
+				log.Errorf("[Signer] failed to move frozen tx %s to queue, left pending: %v", key.String(), err)
+				return true
+			}
+			log.Errorf("[Signer] failed to sign frozen tx %s, moved to queue for retry: %v", key.String(), err)
+			held.Status = utils.HELD_APPROVED
+			signer.notifyQueued()
+		}
+	} else {
+		signer.reject(held.Item, PolicyError{
+			Reason: REASON_DISCARDED,
+			Desc:   fmt.Sprintf("discarded by %s after freeze", operator),
+		})
+		held.Status = utils.HELD_REJECTED
+		held.Reason = REASON_DISCARDED
+	}
+	held.Operator = operator
+	held.DecisionTime = time.Now()
+	if err := signer.vdb.PutFrozen(key[:], held); err != nil {
+		log.Errorf("[Signer] failed to save decision for frozen tx %s: %v", key.String(), err)
+	}
+	return true
+}
+
+// GetFrozen returns transactions captured while signing is frozen.
+func (signer *Signer) GetFrozen() (map[chainhash.Hash]*utils.HeldItem, error) {
+	return signer.vdb.GetAllFrozen()
+}
+
+func (signer *Signer) holdForFreeze(item *utils.ToSignItem) error {
+	key := utils.GetUnsignedTxHash(item.Mtx)
+	if err := signer.vdb.PutFrozen(key[:], &utils.HeldItem{
+		Item:         item,
+		TimeReceived: time.Now(),
+		Status:       utils.HELD_PENDING,
+	}); err != nil {
+		log.Errorf("[Signer] failed to queue tx %s while frozen: %v", key.String(), err)
+		return err
+	}
+	log.Warnf("[Signer] signing is frozen, tx %s queued", key.String())
+	return nil
+}
+
+// WatchingFreezeFile freezes signing once FreezeFile appears.
+func (signer *Signer) WatchingFreezeFile() {
+	file := signer.policy.conf.FreezeFile
+	if file == "" {
+		return
+	}
+	log.Infof("[Signer] start watching freeze file %s", file)
+	ticker := time.NewTicker(FREEZE_CHECK_INTERVAL)
+	defer ticker.Stop()
+	for range ticker.C {
+		if _, err := os.Stat(file); err == nil && !signer.IsFrozen() {
+			if err = signer.Freeze("file " + file); err != nil {
+				log.Errorf("%v", err)
+			}
+		}
+	}
+}
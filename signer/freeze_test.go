@@ -0,0 +1,104 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package signer
+
+import (
+	"github.com/polynetwork/btc-vendor-tools/config"
+	"github.com/polynetwork/btc-vendor-tools/db"
+	"github.com/polynetwork/btc-vendor-tools/utils"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestSigner_Freeze(t *testing.T) {
+	vdb, err := db.NewVendorDB("./temp")
+	assert.NoError(t, err)
+	defer os.RemoveAll("./temp")
+	defer os.Remove("./freeze")
+	signer := getKeySigner(t)
+	signer.vdb = vdb
+	signer.policy = NewPolicy(&config.SignPolicy{FreezeFile: "./freeze"}, vdb)
+
+	assert.NoError(t, signer.Freeze("test"))
+	ok, _ := vdb.IsFrozen()
+	assert.True(t, ok)
+
+	item, _ := getKeyItem(signer)
+	key := utils.GetUnsignedTxHash(item.Mtx)
+	assert.NoError(t, signer.Sign(item))
+	foreign, _ := getKeyItem(getKeySigner(t))
+	fkey := utils.GetUnsignedTxHash(foreign.Mtx)
+	assert.NoError(t, signer.Sign(foreign))
+	frozen, err := vdb.GetAllFrozen()
+	assert.NoError(t, err)
+	assert.Equal(t, utils.HELD_PENDING, frozen[key].Status)
+	_, err = vdb.GetOutbox(key[:])
+	assert.Error(t, err)
+
+	assert.NoError(t, ioutil.WriteFile("./freeze", nil, 0644))
+	assert.Error(t, signer.Unfreeze("alice", true))
+	os.Remove("./freeze")
+	assert.NoError(t, signer.Unfreeze("alice", true))
+	assert.False(t, signer.IsFrozen())
+	frozen, _ = vdb.GetAllFrozen()
+	assert.Equal(t, utils.HELD_APPROVED, frozen[key].Status)
+	assert.Equal(t, utils.HELD_REJECTED, frozen[fkey].Status)
+	assert.Equal(t, REASON_FOREIGN_INPUT, frozen[fkey].Reason)
+	_, err = vdb.GetOutbox(key[:])
+	assert.NoError(t, err)
+
+	assert.NoError(t, signer.Freeze("test"))
+	item, _ = getKeyItem(signer)
+	item.Mtx.TxIn[0].PreviousOutPoint.Index = 5
+	item.Mtx.TxIn[1].PreviousOutPoint.Index = 6
+	key = utils.GetUnsignedTxHash(item.Mtx)
+	assert.NoError(t, signer.Sign(item))
+	assert.NoError(t, signer.Unfreeze("bob", false))
+	frozen, _ = vdb.GetAllFrozen()
+	assert.Equal(t, utils.HELD_REJECTED, frozen[key].Status)
+	rejected, err := vdb.GetRejectedTx(key[:])
+	assert.NoError(t, err)
+	assert.Equal(t, REASON_DISCARDED, rejected.Reason)
+	assert.Equal(t, REASON_DISCARDED, frozen[key].Reason)
+	ok, _ = vdb.IsFrozen()
+	assert.False(t, ok)
+
+	// failed on the way, retried through the queue
+	assert.NoError(t, signer.Freeze("test"))
+	item, _ = getKeyItem(signer)
+	item.Mtx.TxIn[0].PreviousOutPoint.Index = 7
+	item.Mtx.TxIn[1].PreviousOutPoint.Index = 8
+	key = utils.GetUnsignedTxHash(item.Mtx)
+	assert.NoError(t, signer.Sign(item))
+	rd := theRedeem(signer)
+	ks := rd.ks
+	rd.ks = brokenKeyStore{ks}
+	assert.NoError(t, signer.Unfreeze("carol", true))
+	frozen, _ = vdb.GetAllFrozen()
+	assert.Equal(t, utils.HELD_APPROVED, frozen[key].Status)
+	_, queued, err := vdb.GetQueue()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(queued))
+	rd.ks = ks
+	signer.consumeQueue()
+	_, err = vdb.GetOutbox(key[:])
+	assert.NoError(t, err)
+	_, queued, _ = vdb.GetQueue()
+	assert.Equal(t, 0, len(queued))
+}
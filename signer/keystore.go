@@ -0,0 +1,191 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package signer
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcutil"
+	"github.com/ontio/ontology-crypto/ec"
+	"github.com/polynetwork/btc-vendor-tools/utils"
+	sdk "github.com/polynetwork/poly-go-sdk"
+	"golang.org/x/crypto/scrypt"
+	"io/ioutil"
+	"strings"
+)
+
+const (
+	KEYSTORE_WALLET = "wallet"
+	KEYSTORE_WIF    = "wif"
+	KEYSTORE_REMOTE = "remote"
+	KEYSTORE_SIGND  = "signd"
+
+	// scrypt params for encrypted WIF files
+	WIF_SCRYPT_N = 1 << 15
+	WIF_SCRYPT_R = 8
+	WIF_SCRYPT_P = 1
+)
+
+// KeyStore holds our btc key and signs with it. The private key never leaves it.
+type KeyStore interface {
+	PubKey() *btcec.PublicKey
+	// Sign signs a sighash and returns the DER signature without sighash type.
+	Sign(hash []byte) ([]byte, error)
+}
+
+// NewKeyStore opens the key store of kind for redeem. file and pwd are used by wallet
+// and wif key stores, and addr by remote and signd. pwd is the shared secret for remote and signd.
+func NewKeyStore(poly *sdk.PolySdk, kind, file, addr string, pwd, redeem []byte) (KeyStore, error) {
+	switch kind {
+	case KEYSTORE_WALLET, "":
+		return NewWalletKeyStore(poly, file, pwd)
+	case KEYSTORE_WIF:
+		return NewWIFKeyStore(file, pwd)
+	case KEYSTORE_REMOTE:
+		return NewRemoteKeyStore(addr, pwd)
+	case KEYSTORE_SIGND:
+		return NewSigndKeyStore(addr, pwd, redeem)
+	default:
+		return nil, fmt.Errorf("unknown key store %s", kind)
+	}
+}
+
+// PrivKeyStore keeps the key in memory of this process.
+type PrivKeyStore struct {
+	privk *btcec.PrivateKey
+}
+
+func NewPrivKeyStore(privk *btcec.PrivateKey) *PrivKeyStore {
+	return &PrivKeyStore{
+		privk: privk,
+	}
+}
+
+func (ks *PrivKeyStore) PubKey() *btcec.PublicKey {
+	return ks.privk.PubKey()
+}
+
+func (ks *PrivKeyStore) Sign(hash []byte) ([]byte, error) {
+	sig, err := ks.privk.Sign(hash)
+	if err != nil {
+		return nil, err
+	}
+	return sig.Serialize(), nil
+}
+
+// NewWalletKeyStore decrypts our btc key from a poly wallet file.
+func NewWalletKeyStore(poly *sdk.PolySdk, file string, pwd []byte) (*PrivKeyStore, error) {
+	btcAcct, err := utils.GetAccountByPassword(poly, file, pwd)
+	if err != nil {
+		return nil, fmt.Errorf("[NewWalletKeyStore] failed to get btc account: %v", err)
+	}
+	privkP256 := btcec.PrivateKey(*btcAcct.GetPrivateKey().(*ec.PrivateKey).PrivateKey)
+	privk, _ := btcec.PrivKeyFromBytes(btcec.S256(), (&privkP256).Serialize())
+	return NewPrivKeyStore(privk), nil
+}
+
+// wifFile is a WIF encrypted by AES-GCM with a key derived from password by scrypt.
+type wifFile struct {
+	N     int    `json:"n"`
+	R     int    `json:"r"`
+	P     int    `json:"p"`
+	Salt  string `json:"salt"`
+	Nonce string `json:"nonce"`
+	Data  string `json:"data"`
+}
+
+// EncryptWIF returns the content of an encrypted WIF file for NewWIFKeyStore.
+func EncryptWIF(wif *btcutil.WIF, pwd []byte) ([]byte, error) {
+	f := &wifFile{
+		N: WIF_SCRYPT_N,
+		R: WIF_SCRYPT_R,
+		P: WIF_SCRYPT_P,
+	}
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := f.aead(pwd, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	f.Salt = hex.EncodeToString(salt)
+	f.Nonce = hex.EncodeToString(nonce)
+	f.Data = hex.EncodeToString(aead.Seal(nil, nonce, []byte(wif.String()), nil))
+	return json.MarshalIndent(f, "", "\t")
+}
+
+// NewWIFKeyStore decrypts our btc key from a file made by EncryptWIF.
+func NewWIFKeyStore(file string, pwd []byte) (*PrivKeyStore, error) {
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("[NewWIFKeyStore] failed to read %s: %v", file, err)
+	}
+	f := &wifFile{}
+	if err = json.Unmarshal(raw, f); err != nil {
+		return nil, fmt.Errorf("[NewWIFKeyStore] failed to unmarshal %s: %v", file, err)
+	}
+	salt, err := hex.DecodeString(f.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("[NewWIFKeyStore] wrong salt: %v", err)
+	}
+	nonce, err := hex.DecodeString(f.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("[NewWIFKeyStore] wrong nonce: %v", err)
+	}
+	data, err := hex.DecodeString(f.Data)
+	if err != nil {
+		return nil, fmt.Errorf("[NewWIFKeyStore] wrong data: %v", err)
+	}
+	aead, err := f.aead(pwd, salt)
+	if err != nil {
+		return nil, fmt.Errorf("[NewWIFKeyStore] %v", err)
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("[NewWIFKeyStore] wrong length of nonce: %d", len(nonce))
+	}
+	plain, err := aead.Open(nil, nonce, data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("[NewWIFKeyStore] failed to decrypt, wrong password?")
+	}
+	wif, err := btcutil.DecodeWIF(strings.TrimSpace(string(plain)))
+	if err != nil {
+		return nil, fmt.Errorf("[NewWIFKeyStore] failed to decode WIF: %v", err)
+	}
+	return NewPrivKeyStore(wif.PrivKey), nil
+}
+
+func (f *wifFile) aead(pwd, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(pwd, salt, f.N, f.R, f.P, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %v", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,117 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package signer
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcutil"
+	"github.com/polynetwork/btc-vendor-tools/config"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWIFKeyStore(t *testing.T) {
+	signer := getKeySigner(t)
+	privk := theRedeem(signer).ks.(*PrivKeyStore).privk
+	wif, err := btcutil.NewWIF(privk, config.BtcNetParam, true)
+	assert.NoError(t, err)
+	data, err := EncryptWIF(wif, []byte("pwd"))
+	assert.NoError(t, err)
+	defer os.Remove("./wif")
+	assert.NoError(t, ioutil.WriteFile("./wif", data, 0600))
+
+	ks, err := NewWIFKeyStore("./wif", []byte("pwd"))
+	assert.NoError(t, err)
+	assert.True(t, ks.PubKey().IsEqual(privk.PubKey()))
+	_, err = NewWIFKeyStore("./wif", []byte("wrong"))
+	assert.Error(t, err)
+}
+
+func TestRemoteKeyStore(t *testing.T) {
+	signer := getKeySigner(t)
+	local := theRedeem(signer)
+	item, _ := getKeyItem(signer)
+	expected, err := signer.getSigs(local, item)
+	assert.NoError(t, err)
+
+	secret := []byte("0123456789abcdef")
+	_, err = KeyStoreHandler(local.ks, []byte("short"))
+	assert.Error(t, err)
+	h, err := KeyStoreHandler(local.ks, secret)
+	assert.NoError(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	ks, err := NewRemoteKeyStore(srv.URL, secret)
+	assert.NoError(t, err)
+	rd, err := NewRedeem(local.redeem, ks)
+	assert.NoError(t, err)
+	item, pkScripts := getKeyItem(signer)
+	sigs, err := signer.getSigs(rd, item)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, sigs)
+	assert.NoError(t, signer.verifySigs(rd, item, pkScripts, sigs))
+
+	// wrong secret, or no auth at all
+	_, err = NewRemoteKeyStore(srv.URL, []byte("fedcba9876543210"))
+	assert.Error(t, err)
+	resp, err := http.Post(srv.URL+KEYSTORE_SIGN_PATH, "application/json",
+		strings.NewReader(`{"hash":"`+strings.Repeat("00", 32)+`"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp.Body.Close()
+
+	// replayed request
+	data := []byte(fmt.Sprintf(`{"time":%d,"nonce":"%s"}`, time.Now().Unix(), strings.Repeat("ab", 16)))
+	for i, status := range []int{http.StatusOK, http.StatusBadRequest} {
+		hr, _ := http.NewRequest(http.MethodPost, srv.URL+KEYSTORE_PUBKEY_PATH, bytes.NewReader(data))
+		hr.Header.Set(KEYSTORE_AUTH_HEADER, hex.EncodeToString(mac(secret, data)))
+		resp, err = http.DefaultClient.Do(hr)
+		assert.NoError(t, err)
+		assert.Equal(t, status, resp.StatusCode, "No.%d request", i)
+		resp.Body.Close()
+	}
+
+	defer os.Remove("./ks.sock")
+	go ServeKeyStore(local.ks, secret, UNIX_SCHEME+"./ks.sock")
+	time.Sleep(100 * time.Millisecond)
+	fi, err := os.Stat("./ks.sock")
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	ks, err = NewRemoteKeyStore(UNIX_SCHEME+"./ks.sock", secret)
+	assert.NoError(t, err)
+	assert.True(t, ks.PubKey().IsEqual(local.ks.PubKey()))
+	_, err = NewRemoteKeyStore(UNIX_SCHEME+"./ks.sock", []byte("short"))
+	assert.Error(t, err)
+
+	// remote returns signatures of another key
+	other, _ := btcec.NewPrivateKey(btcec.S256())
+	h, _ = KeyStoreHandler(NewPrivKeyStore(other), secret)
+	bad := httptest.NewServer(h)
+	defer bad.Close()
+	ks.url, ks.cli = bad.URL, http.DefaultClient
+	_, err = ks.Sign(make([]byte, 32))
+	assert.Error(t, err)
+}
@@ -0,0 +1,40 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package signer
+
+import (
+	"expvar"
+	"github.com/polynetwork/btc-vendor-tools/utils"
+)
+
+// counters by redeem key, published at /debug/vars
+var (
+	metricSigned    = expvar.NewMap("signer_signed")
+	metricRejected  = expvar.NewMap("signer_rejected")
+	metricConfirmed = expvar.NewMap("signer_confirmed")
+	metricFailed    = expvar.NewMap("signer_failed")
+	// satoshi locked under retiring redeems
+	metricLocked = expvar.NewMap("signer_locked_value")
+)
+
+// redeemTag is the redeem key of item for metrics.
+func redeemTag(item *utils.ToSignItem) string {
+	if item.RedeemKey == "" {
+		return "unknown"
+	}
+	return item.RedeemKey
+}
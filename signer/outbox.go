@@ -0,0 +1,292 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package signer
+
+import (
+	"fmt"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/polynetwork/btc-vendor-tools/config"
+	"github.com/polynetwork/btc-vendor-tools/log"
+	"github.com/polynetwork/btc-vendor-tools/utils"
+	"github.com/polynetwork/poly-go-sdk/client"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+const (
+	OUTBOX_CHECK_INTERVAL   = time.Second
+	OUTBOX_MAX_BACKOFF      = 10 * time.Minute
+	OUTBOX_MAX_ATTEMPTS     = 10
+	OUTBOX_CONFIRM_INTERVAL = 5 * time.Second
+	OUTBOX_CONFIRM_TIMEOUT  = 10 * time.Minute
+)
+
+// FATAL_ERRORS are errors from poly meaning our signatures would never be accepted.
+var FATAL_ERRORS = []string{
+	"already sign",
+	"utxo is not enough",
+}
+
+// enqueue saves sigs into outbox so that they survive restarts, and wakes up the submitter.
+// txHash is the hash of the tx known by poly.
+func (signer *Signer) enqueue(item *utils.ToSignItem, txHash chainhash.Hash, sigs [][]byte) error {
+	key := utils.GetUnsignedTxHash(item.Mtx)
+	if old, err := signer.vdb.GetOutbox(key[:]); err == nil && old.Status != utils.OUTBOX_FAILED {
+		log.Infof("[Signer] tx %s already in outbox", key.String())
+		return nil
+	}
+	if err := signer.vdb.PutOutbox(key[:], &utils.OutboxItem{
+		Item:         item,
+		TxHash:       txHash,
+		Sigs:         sigs,
+		TimeReceived: time.Now(),
+		Status:       utils.OUTBOX_PENDING,
+		NextTry:      time.Now(),
+	}); err != nil {
+		return fmt.Errorf("failed to put tx %s into outbox: %v", key.String(), err)
+	}
+	// the observer may see the tx relayed before we see our poly tx confirmed,
+	// so the signed record must exist by then for SetTxDone to find it.
+	if err := signer.vdb.PutSignedTx(key[:], &utils.SavedItem{
+		Item:         item,
+		TimeReceived: time.Now(),
+		Done:         false,
+	}); err != nil {
+		log.Errorf("[Signer] failed to save item key:%s into db: %v", key.String(), err)
+	}
+	select {
+	case signer.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Submitting sends signatures in outbox to poly and checks that poly accepted them.
+// Retryable errors are retried with exponential backoff, others mark the item failed
+// and leave it in outbox for the operator. Nothing is sent while signing is frozen.
+func (signer *Signer) Submitting() {
+	log.Infof("[Signer] start submitting")
+	ticker := time.NewTicker(OUTBOX_CHECK_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-signer.wake:
+		case <-ticker.C:
+		}
+		if signer.IsFrozen() {
+			continue
+		}
+		items, err := signer.vdb.GetAllOutbox()
+		if err != nil {
+			log.Errorf("[Signer] failed to read outbox: %v", err)
+			continue
+		}
+		now := time.Now()
+		for key, ob := range items {
+			if ob.NextTry.After(now) {
+				continue
+			}
+			switch ob.Status {
+			case utils.OUTBOX_PENDING:
+				signer.submit(key, ob)
+			case utils.OUTBOX_SENT:
+				signer.confirm(key, ob)
+			}
+		}
+	}
+}
+
+func (signer *Signer) submit(key chainhash.Hash, ob *utils.OutboxItem) {
+	rd, err := signer.redeemOf(ob.Item)
+	if err != nil {
+		ob.Status = utils.OUTBOX_FAILED
+		ob.Err = err.Error()
+		log.Errorf("[Signer][ALERT] no redeem to submit btc tx %s: %v", key.String(), err)
+		signer.putOutbox(key, ob)
+		return
+	}
+	if n, m, err := signer.getSigCount(rd, ob.TxHash); err != nil {
+		log.Warnf("[Signer] failed to check signatures of btc tx %s on poly and submit anyway: %v",
+			key.String(), err)
+	} else if n >= m {
+		signer.skip(key, ob, n)
+		return
+	}
+
+	txid, err := signer.poly.Native.Ccm.BtcMultiSign(1, rd.Key, ob.TxHash[:],
+		rd.addr.EncodeAddress(), ob.Sigs, signer.acct)
+	if err != nil {
+		if _, ok := err.(client.PostErr); ok {
+			ob.Attempts++
+			ob.Err = err.Error()
+			ob.NextTry = time.Now().Add(backoff(ob.Attempts))
+			log.Errorf("[Signer] post err and would retry tx %s after %v: %v", key.String(),
+				ob.NextTry.Sub(time.Now()).Round(time.Second), err)
+		} else if signer.retry(key, ob, err.Error()) {
+			return
+		}
+		signer.putOutbox(key, ob)
+		return
+	}
+
+	ob.Status = utils.OUTBOX_SENT
+	ob.PolyTx = txid.ToHexString()
+	ob.SentTime = time.Now()
+	ob.NextTry = ob.SentTime.Add(OUTBOX_CONFIRM_INTERVAL)
+	signer.putOutbox(key, ob)
+	log.Infof("[Signer] signed for btc tx %s (db-key: %s) and send tx %s to polygon", ob.TxHash.String(),
+		key.String(), ob.PolyTx)
+}
+
+// confirm checks the result of our BtcMultiSign tx on poly.
+func (signer *Signer) confirm(key chainhash.Hash, ob *utils.OutboxItem) {
+	event, err := signer.poly.GetSmartContractEvent(ob.PolyTx)
+	switch {
+	case err != nil:
+		log.Errorf("[Signer] failed to get event of poly tx %s: %v", ob.PolyTx, err)
+		ob.NextTry = time.Now().Add(OUTBOX_CONFIRM_INTERVAL)
+	case event == nil && time.Since(ob.SentTime) > OUTBOX_CONFIRM_TIMEOUT:
+		log.Warnf("[Signer] poly tx %s for btc tx %s not found after %v, resubmit it", ob.PolyTx,
+			key.String(), OUTBOX_CONFIRM_TIMEOUT)
+		ob.Status = utils.OUTBOX_PENDING
+		ob.NextTry = time.Now()
+	case event == nil:
+		ob.NextTry = time.Now().Add(OUTBOX_CONFIRM_INTERVAL)
+	case event.State == 1:
+		signer.done(key, ob)
+		return
+	default:
+		if signer.retry(key, ob, "reverted: "+signer.revertReason(ob)) {
+			return
+		}
+	}
+	signer.putOutbox(key, ob)
+}
+
+// skip records that we don't need to submit ob since other vendors already signed enough.
+func (signer *Signer) skip(key chainhash.Hash, ob *utils.OutboxItem, n int) {
+	ob.Status = utils.OUTBOX_SKIPPED
+	ob.Err = THRESHOLD_REACHED
+	log.Infof("[Signer] btc tx %s already has %d signatures on poly, skip submitting", key.String(), n)
+	signer.putOutbox(key, ob)
+}
+
+// retry schedules a failed submission again, or escalates it if it's never going to succeed.
+// It returns true if ob turned out to be confirmed and was moved out of outbox.
+func (signer *Signer) retry(key chainhash.Hash, ob *utils.OutboxItem, reason string) bool {
+	ob.Attempts++
+	ob.Err = reason
+	if signer.signedByUs(ob, reason) {
+		// e.g. resubmitted after a confirm timeout while the first poly tx made it
+		log.Infof("[Signer] our signatures for btc tx %s are already on poly: %s", key.String(), reason)
+		signer.done(key, ob)
+		return true
+	}
+	if strings.Contains(reason, "already enough signature") {
+		ob.Status = utils.OUTBOX_SKIPPED
+		log.Infof("[Signer] btc tx %s already has enough signatures on poly: %s", key.String(), reason)
+		return false
+	}
+	if isFatal(reason) || ob.Attempts >= OUTBOX_MAX_ATTEMPTS {
+		ob.Status = utils.OUTBOX_FAILED
+		metricFailed.Add(redeemTag(ob.Item), 1)
+		log.Errorf("[Signer][ALERT] failed to sign btc tx %s of redeem %s on polygon after %d attempts, "+
+			"need to check it manually: %s", key.String(), redeemTag(ob.Item), ob.Attempts, reason)
+		return false
+	}
+	ob.Status = utils.OUTBOX_PENDING
+	ob.NextTry = time.Now().Add(backoff(ob.Attempts))
+	log.Errorf("[Signer] failed to sign btc tx %s on polygon and would retry after %v: %s", key.String(),
+		ob.NextTry.Sub(time.Now()).Round(time.Second), reason)
+	return false
+}
+
+// signedByUs tells if poly refused ob because the signing address, which is ours, already signed it.
+func (signer *Signer) signedByUs(ob *utils.OutboxItem, reason string) bool {
+	rd, err := signer.redeemOf(ob.Item)
+	if err != nil {
+		return false
+	}
+	return strings.Contains(reason, fmt.Sprintf("address %s already sign", rd.addr.EncodeAddress()))
+}
+
+// done moves a confirmed item from outbox to signed txs. The signed record is normally
+// written by enqueue already, this only covers items enqueued before it did.
+func (signer *Signer) done(key chainhash.Hash, ob *utils.OutboxItem) {
+	if err := signer.vdb.PutSignedTx(key[:], &utils.SavedItem{
+		Item:         ob.Item,
+		TimeReceived: ob.TimeReceived,
+		Done:         false,
+	}); err != nil {
+		log.Errorf("[Signer] failed to save item key:%s into db: %v", key.String(), err)
+		return
+	}
+	if err := signer.vdb.DelOutbox(key[:]); err != nil {
+		log.Errorf("[Signer] failed to delete tx %s from outbox: %v", key.String(), err)
+		return
+	}
+	metricConfirmed.Add(redeemTag(ob.Item), 1)
+	log.Infof("[Signer] poly tx %s for btc tx %s (db-key: %s) confirmed", ob.PolyTx, ob.TxHash.String(),
+		key.String())
+}
+
+// revertReason pre-executes our BtcMultiSign tx again to find out why it failed.
+func (signer *Signer) revertReason(ob *utils.OutboxItem) string {
+	rd, err := signer.redeemOf(ob.Item)
+	if err != nil {
+		return fmt.Sprintf("unknown (%v)", err)
+	}
+	tx, err := signer.poly.Native.Ccm.NewBtcMultiSignTransaction(1, rd.Key,
+		ob.TxHash[:], rd.addr.EncodeAddress(), ob.Sigs)
+	if err != nil {
+		return fmt.Sprintf("unknown (failed to build tx: %v)", err)
+	}
+	if _, err = signer.poly.PreExecTransaction(tx); err != nil {
+		return err.Error()
+	}
+	return "unknown"
+}
+
+func (signer *Signer) putOutbox(key chainhash.Hash, ob *utils.OutboxItem) {
+	if err := signer.vdb.PutOutbox(key[:], ob); err != nil {
+		log.Errorf("[Signer] failed to update tx %s in outbox: %v", key.String(), err)
+	}
+}
+
+// isFatal tells if poly refused our signatures for a reason that retrying can't fix.
+func isFatal(reason string) bool {
+	for _, v := range FATAL_ERRORS {
+		if strings.Contains(reason, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the time to wait before the n-th retry: SleepTime doubled for each
+// attempt up to OUTBOX_MAX_BACKOFF, with half of it randomized.
+func backoff(n uint32) time.Duration {
+	d := config.SleepTime
+	for i := uint32(1); i < n && d < OUTBOX_MAX_BACKOFF; i++ {
+		d *= 2
+	}
+	if d > OUTBOX_MAX_BACKOFF {
+		d = OUTBOX_MAX_BACKOFF
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
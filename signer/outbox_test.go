@@ -0,0 +1,113 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package signer
+
+import (
+	"github.com/polynetwork/btc-vendor-tools/config"
+	"github.com/polynetwork/btc-vendor-tools/db"
+	"github.com/polynetwork/btc-vendor-tools/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	config.SleepTime = 10 * time.Second
+	for n, max := range map[uint32]time.Duration{
+		1:  10 * time.Second,
+		2:  20 * time.Second,
+		4:  80 * time.Second,
+		20: OUTBOX_MAX_BACKOFF,
+	} {
+		d := backoff(n)
+		assert.True(t, d >= max/2 && d <= max, "attempt %d: %v", n, d)
+	}
+}
+
+func TestSigner_retry(t *testing.T) {
+	vdb, err := db.NewVendorDB("./temp")
+	assert.NoError(t, err)
+	defer os.RemoveAll("./temp")
+	signer := getKeySigner(t)
+	signer.vdb = vdb
+
+	item, _ := getKeyItem(signer)
+	assert.NoError(t, signer.enqueue(item, item.Mtx.TxHash(), [][]byte{{1}, {2}}))
+	key := utils.GetUnsignedTxHash(item.Mtx)
+	ob, err := vdb.GetOutbox(key[:])
+	assert.NoError(t, err)
+	assert.Equal(t, utils.OUTBOX_PENDING, ob.Status)
+	// the observer may mark it relayed before poly confirms our tx
+	assert.NoError(t, vdb.SetTxDone(key[:]))
+
+	signer.retry(key, ob, "reverted: unknown")
+	assert.Equal(t, utils.OUTBOX_PENDING, ob.Status)
+	assert.Equal(t, uint32(1), ob.Attempts)
+	assert.True(t, ob.NextTry.After(time.Now()))
+
+	assert.False(t, signer.retry(key, ob, "reverted: MultiSign, address mvTsMUHNd4jTkuyxWU76K3U3xWERoeHfTp already sign"))
+	assert.Equal(t, utils.OUTBOX_FAILED, ob.Status)
+
+	ob.Status = utils.OUTBOX_PENDING
+	signer.retry(key, ob, "MultiSign, already enough signature: 5")
+	assert.Equal(t, utils.OUTBOX_SKIPPED, ob.Status)
+
+	ob.Status, ob.Attempts = utils.OUTBOX_PENDING, OUTBOX_MAX_ATTEMPTS-1
+	signer.retry(key, ob, "reverted: unknown")
+	assert.Equal(t, utils.OUTBOX_FAILED, ob.Status)
+
+	// a resubmit after a confirm timeout finds our own earlier signatures
+	ob.Status = utils.OUTBOX_PENDING
+	assert.True(t, signer.retry(key, ob, "reverted: MultiSign, address "+
+		theRedeem(signer).addr.EncodeAddress()+" already sign"))
+	_, err = vdb.GetOutbox(key[:])
+	assert.Error(t, err)
+	res, err := vdb.GetSignedTx(key[:])
+	assert.NoError(t, err)
+	assert.True(t, res.Done)
+}
+
+func TestSigner_SignShadow(t *testing.T) {
+	vdb, err := db.NewVendorDB("./temp")
+	assert.NoError(t, err)
+	defer os.RemoveAll("./temp")
+	signer := getKeySigner(t)
+	signer.vdb = vdb
+	signer.policy = NewPolicy(nil, vdb)
+	signer.SetShadow(true)
+
+	item, _ := getKeyItem(signer)
+	txHash := item.Mtx.TxHash()
+	assert.NoError(t, signer.Sign(item))
+
+	key := utils.GetUnsignedTxHash(item.Mtx)
+	res, err := vdb.GetShadowTx(key[:])
+	assert.NoError(t, err)
+	assert.Equal(t, txHash, res.TxHash)
+	assert.Equal(t, 2, len(res.Sigs))
+	_, err = vdb.GetOutbox(key[:])
+	assert.Error(t, err)
+
+	// nothing counts for double spend checks or limits
+	spender, err := vdb.GetOutpointSpender(&item.Mtx.TxIn[0].PreviousOutPoint)
+	assert.NoError(t, err)
+	assert.Nil(t, spender)
+	spent, err := vdb.GetSpentSince(time.Now().Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), spent)
+}
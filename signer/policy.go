@@ -0,0 +1,139 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package signer
+
+import (
+	"fmt"
+	"github.com/polynetwork/btc-vendor-tools/config"
+	"github.com/polynetwork/btc-vendor-tools/db"
+	"github.com/polynetwork/btc-vendor-tools/utils"
+	"time"
+)
+
+const (
+	REASON_MAX_TX_VALUE     = "max_tx_value"
+	REASON_MAX_HOURLY_VALUE = "max_hourly_value"
+	REASON_MAX_DAILY_VALUE  = "max_daily_value"
+	REASON_OUTPUT_COUNT     = "output_count"
+	REASON_MAX_FEE          = "max_fee"
+	REASON_BAD_OUTPUT       = "bad_output"
+)
+
+// PolicyError means a transaction is refused by policy and must not be signed.
+type PolicyError struct {
+	Reason string
+	Desc   string
+}
+
+func (err PolicyError) Error() string {
+	return fmt.Sprintf("%s: %s", err.Reason, err.Desc)
+}
+
+type Policy struct {
+	conf *config.SignPolicy
+	vdb  *db.VendorDB
+	deny *DenyList
+	hook *Webhook
+}
+
+func NewPolicy(conf *config.SignPolicy, vdb *db.VendorDB) *Policy {
+	if conf == nil {
+		conf = &config.SignPolicy{}
+	}
+	p := &Policy{
+		conf: conf,
+		vdb:  vdb,
+	}
+	if conf.DenyListFile != "" {
+		p.deny = NewDenyList(conf.DenyListFile)
+	}
+	if conf.WebhookURL != "" {
+		p.hook = NewWebhook(conf.WebhookURL, conf.WebhookTimeout, conf.WebhookFailOpen)
+	}
+	return p
+}
+
+// Check returns a PolicyError if item violates the policy. Other errors mean the
+// check itself failed and the item should not be signed either.
+//
+// Check and Record on the rolling windows are not atomic, so the caller must hold
+// Signer.lock from Check until the item is recorded, as Signer.Sign does.
+func (p *Policy) Check(item *utils.ToSignItem, sum *TxSummary) error {
+	if p.deny != nil {
+		if err := p.deny.Check(item); err != nil {
+			return err
+		}
+	}
+	n := len(item.Mtx.TxOut)
+	if n < p.conf.MinOutputs || (p.conf.MaxOutputs > 0 && n > p.conf.MaxOutputs) {
+		return PolicyError{
+			Reason: REASON_OUTPUT_COUNT,
+			Desc:   fmt.Sprintf("%d outputs not in range [%d, %d]", n, p.conf.MinOutputs, p.conf.MaxOutputs),
+		}
+	}
+
+	if p.conf.MaxFee > 0 && sum.Fee > p.conf.MaxFee {
+		return PolicyError{
+			Reason: REASON_MAX_FEE,
+			Desc:   fmt.Sprintf("fee %d exceeds %d", sum.Fee, p.conf.MaxFee),
+		}
+	}
+	val := sum.Leaving()
+	if p.conf.MaxTxValue > 0 && val > p.conf.MaxTxValue {
+		return PolicyError{
+			Reason: REASON_MAX_TX_VALUE,
+			Desc:   fmt.Sprintf("value %d not sent back to multisig exceeds %d", val, p.conf.MaxTxValue),
+		}
+	}
+	if err := p.checkWindow(val, time.Hour, p.conf.MaxHourlyValue, REASON_MAX_HOURLY_VALUE); err != nil {
+		return err
+	}
+	if err := p.checkWindow(val, 24*time.Hour, p.conf.MaxDailyValue, REASON_MAX_DAILY_VALUE); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Record adds item to the rolling windows after we signed it. The caller must hold
+// Signer.lock since Check on the same item, see Check.
+func (p *Policy) Record(item *utils.ToSignItem, sum *TxSummary) error {
+	if p.vdb == nil {
+		return nil
+	}
+	txid := utils.GetUnsignedTxHash(item.Mtx)
+	return p.vdb.PutSpent(txid[:], time.Now(), sum.Leaving())
+}
+
+func (p *Policy) checkWindow(val uint64, dura time.Duration, limit uint64, reason string) error {
+	if limit == 0 {
+		return nil
+	}
+	if p.vdb == nil {
+		return fmt.Errorf("no db to check %s", reason)
+	}
+	spent, err := p.vdb.GetSpentSince(time.Now().Add(-dura))
+	if err != nil {
+		return fmt.Errorf("failed to get value spent in last %s: %v", dura.String(), err)
+	}
+	if spent+val > limit {
+		return PolicyError{
+			Reason: reason,
+			Desc:   fmt.Sprintf("value %d plus %d spent in last %s exceeds %d", val, spent, dura.String(), limit),
+		}
+	}
+	return nil
+}
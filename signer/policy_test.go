@@ -0,0 +1,108 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package signer
+
+import (
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/polynetwork/btc-vendor-tools/config"
+	"github.com/polynetwork/btc-vendor-tools/db"
+	"github.com/polynetwork/btc-vendor-tools/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+// getPolicyItem returns an item spending 100000 satoshi, paying vals to outside and
+// sending the rest minus fee 100 back to our multisig.
+func getPolicyItem(signer *Signer, idx uint32, vals ...int64) (*utils.ToSignItem, *TxSummary) {
+	mtx := wire.NewMsgTx(wire.TxVersion)
+	mtx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, idx), nil, nil))
+	change := int64(100000 - 100)
+	for _, v := range vals {
+		mtx.AddTxOut(wire.NewTxOut(v, []byte{0x51}))
+		change -= v
+	}
+	mtx.AddTxOut(wire.NewTxOut(change, theRedeem(signer).p2wshScript))
+	item := &utils.ToSignItem{
+		Mtx:  mtx,
+		Amts: []uint64{100000},
+	}
+	sum, err := signer.Summarize(item)
+	if err != nil {
+		panic(err)
+	}
+	return item, sum
+}
+
+func TestSigner_Summarize(t *testing.T) {
+	signer := getValidateSigner(t)
+	item, sum := getPolicyItem(signer, 0, 1000, 2000)
+	assert.Equal(t, uint64(100000), sum.InValue)
+	assert.Equal(t, uint64(100), sum.Fee)
+	assert.Equal(t, uint64(100000-3100), sum.Change)
+	assert.Equal(t, uint64(3100), sum.Leaving())
+	assert.Equal(t, true, sum.Outs[2].Change)
+	assert.Equal(t, theRedeem(signer).p2wshAddr, sum.Outs[2].Addr)
+	assert.Equal(t, false, sum.Outs[0].Change)
+
+	item.Mtx.TxOut[0].Value = 100000
+	_, err := signer.Summarize(item)
+	assert.Equal(t, REASON_BAD_OUTPUT, err.(PolicyError).Reason)
+}
+
+func TestPolicy_Check(t *testing.T) {
+	vdb, err := db.NewVendorDB("./temp")
+	assert.NoError(t, err)
+	defer os.RemoveAll("./temp")
+	defer vdb.Close()
+
+	signer := getValidateSigner(t)
+	p := NewPolicy(&config.SignPolicy{
+		MaxTxValue:     1000,
+		MaxHourlyValue: 1500,
+		MaxFee:         100,
+		MinOutputs:     2,
+		MaxOutputs:     3,
+	}, vdb)
+	check := func(idx uint32, vals ...int64) error {
+		item, sum := getPolicyItem(signer, idx, vals...)
+		return p.Check(item, sum)
+	}
+
+	assert.Equal(t, REASON_OUTPUT_COUNT, check(0).(PolicyError).Reason)
+	assert.Equal(t, REASON_OUTPUT_COUNT, check(0, 1, 1, 1).(PolicyError).Reason)
+	assert.Equal(t, REASON_MAX_TX_VALUE, check(0, 901).(PolicyError).Reason)
+
+	item, sum := getPolicyItem(signer, 0, 600, 200)
+	assert.NoError(t, p.Check(item, sum))
+	assert.NoError(t, p.Record(item, sum))
+	assert.NoError(t, check(1, 500))
+	assert.Equal(t, REASON_MAX_HOURLY_VALUE, check(1, 501).(PolicyError).Reason)
+
+	item, sum = getPolicyItem(signer, 2, 10)
+	item.Mtx.TxOut[1].Value -= 1
+	sum, _ = signer.Summarize(item)
+	assert.Equal(t, REASON_MAX_FEE, p.Check(item, sum).(PolicyError).Reason)
+}
+
+func TestPolicy_CheckNoLimit(t *testing.T) {
+	p := NewPolicy(nil, nil)
+	item, sum := getPolicyItem(getValidateSigner(t), 0, 90000, 9000)
+	assert.NoError(t, p.Check(item, sum))
+	assert.NoError(t, p.Record(item, sum))
+}
@@ -0,0 +1,257 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package signer
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/polynetwork/btc-vendor-tools/log"
+	"github.com/polynetwork/btc-vendor-tools/utils"
+	"github.com/syndtr/goleveldb/leveldb"
+	"time"
+)
+
+// ExportPSBT returns the captured tx txid as a PSBT, with our partial signatures if we
+// have signed it. The tx is looked up in outbox and in the queues of approval, delay
+// and freeze.
+func (signer *Signer) ExportPSBT(txid *chainhash.Hash) (*utils.PSBT, error) {
+	item, sigs, err := signer.findItem(txid)
+	if err != nil {
+		return nil, err
+	}
+	rd, err := signer.redeemOf(item)
+	if err != nil {
+		return nil, fmt.Errorf("[Signer] no redeem for tx %s: %v", txid.String(), err)
+	}
+	if sigs != nil {
+		if err = rd.fillPkScripts(item, sigs); err != nil {
+			return nil, fmt.Errorf("[Signer] failed to find scripts of inputs of tx %s: %v", txid.String(), err)
+		}
+	}
+	p, err := utils.NewPSBT(item, rd.redeem)
+	if err != nil {
+		return nil, fmt.Errorf("[Signer] failed to convert tx %s to psbt: %v", txid.String(), err)
+	}
+	pubk := hex.EncodeToString(rd.ks.PubKey().SerializeCompressed())
+	for i, sig := range sigs {
+		p.Inputs[i].PartialSigs[pubk] = sig
+	}
+	return p, nil
+}
+
+// ImportPSBT takes our partial signatures in p, e.g. signed by external software with our
+// key, and puts them into outbox after the tx passes all checks. The tx must be captured
+// from poly and goes through the same holds as Sign. operator is logged.
+func (signer *Signer) ImportPSBT(p *utils.PSBT, operator string) error {
+	signer.lock.Lock()
+	defer signer.lock.Unlock()
+
+	if signer.IsFrozen() {
+		return fmt.Errorf("[Signer] signing is frozen")
+	}
+	item, err := p.ToSignItem()
+	if err != nil {
+		return err
+	}
+	key := utils.GetUnsignedTxHash(item.Mtx)
+	captured, _, err := signer.findItem(&key)
+	if err != nil {
+		return fmt.Errorf("[Signer] tx %s is not captured from poly: %v", key.String(), err)
+	}
+	if err = matchCaptured(item, captured); err != nil {
+		return fmt.Errorf("[Signer] tx %s not match the captured one: %v", key.String(), err)
+	}
+	sum, err := signer.check(item)
+	if err != nil {
+		return fmt.Errorf("[Signer] tx %s failed to pass checks: %v", key.String(), err)
+	}
+	frozen, err := signer.checkHolds(item, sum)
+	if err != nil {
+		return err
+	}
+	rd, err := signer.redeemOf(item)
+	if err != nil {
+		return err
+	}
+	txHash := item.Mtx.TxHash()
+	pubk := rd.ks.PubKey()
+	pkScripts := make([][]byte, len(item.Mtx.TxIn))
+	sigs := make([][]byte, len(item.Mtx.TxIn))
+	for i, in := range item.Mtx.TxIn {
+		sig, ok := p.Inputs[i].PartialSigs[hex.EncodeToString(pubk.SerializeCompressed())]
+		if !ok {
+			sig, ok = p.Inputs[i].PartialSigs[hex.EncodeToString(pubk.SerializeUncompressed())]
+		}
+		if !ok {
+			return fmt.Errorf("[Signer] no signature of our key for input %d of tx %s", i, key.String())
+		}
+		pkScripts[i] = in.SignatureScript
+		sigs[i] = sig
+		in.SignatureScript = nil
+	}
+	log.Infof("[Signer] signatures for tx %s imported from psbt by %s", key.String(), operator)
+	if err = signer.accept(rd, item, sum, txHash, pkScripts, sigs); err != nil {
+		return err
+	}
+	if frozen != nil {
+		frozen.Status = utils.HELD_APPROVED
+		frozen.Operator = operator
+		frozen.DecisionTime = time.Now()
+		if err = signer.vdb.PutFrozen(key[:], frozen); err != nil {
+			log.Errorf("[Signer] failed to save decision for frozen tx %s: %v", key.String(), err)
+		}
+	}
+	return nil
+}
+
+// matchCaptured checks item from a psbt against the one captured from poly. Amounts of
+// p2sh inputs aren't covered by their signatures, so a psbt could lie about them. Scripts
+// of the captured one may be cleared after signing and the unsigned tx already matches by
+// the key, so only the amounts are compared.
+func matchCaptured(item, captured *utils.ToSignItem) error {
+	if len(item.Amts) != len(captured.Amts) || len(item.Mtx.TxIn) != len(captured.Mtx.TxIn) {
+		return fmt.Errorf("%d inputs while %d captured", len(item.Mtx.TxIn), len(captured.Mtx.TxIn))
+	}
+	for i := range item.Mtx.TxIn {
+		if item.Amts[i] != captured.Amts[i] {
+			return fmt.Errorf("amount %d of input %d while %d captured", item.Amts[i], i, captured.Amts[i])
+		}
+	}
+	return nil
+}
+
+// checkHolds puts the imported item through the holds of Sign. It returns an error while
+// the tx waits for approval or its veto window, holding it first if it's new to the queue.
+// The copy pending in the backlog of freeze is returned to be marked as decided.
+func (signer *Signer) checkHolds(item *utils.ToSignItem, sum *TxSummary) (*utils.HeldItem, error) {
+	key := utils.GetUnsignedTxHash(item.Mtx)
+	approval, err := getReleased(signer.vdb.GetApproval, key, "approval")
+	if err != nil {
+		return nil, err
+	}
+	delayed, err := getReleased(signer.vdb.GetDelayed, key, "delay")
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case signer.needApproval(sum) && approval == nil:
+		if err = signer.holdForApproval(item, sum); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("[Signer] tx %s put into approval queue, import it after approval", key.String())
+	case !signer.needApproval(sum) && signer.needDelay(sum) && delayed == nil:
+		if err = signer.holdForDelay(item, sum); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("[Signer] tx %s put into delay queue, import it after the veto window",
+			key.String())
+	}
+	items, err := signer.vdb.GetAllFrozen()
+	if err != nil {
+		return nil, fmt.Errorf("[Signer] failed to get frozen txs: %v", err)
+	}
+	if held, ok := items[key]; ok && held.Status == utils.HELD_PENDING {
+		return held, nil
+	}
+	return nil, nil
+}
+
+// getReleased returns the record of key in a hold queue if the tx is released from it, or
+// nil if it's not in the queue. It's an error if the tx is still held or refused there.
+func getReleased(get func([]byte) (*utils.HeldItem, error), key chainhash.Hash,
+	queue string) (*utils.HeldItem, error) {
+	held, err := get(key[:])
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[Signer] failed to get tx %s from %s queue: %v", key.String(), queue, err)
+	}
+	switch held.Status {
+	case utils.HELD_PENDING:
+		return nil, fmt.Errorf("[Signer] tx %s is waiting in %s queue", key.String(), queue)
+	case utils.HELD_REJECTED:
+		return nil, fmt.Errorf("[Signer] tx %s was refused in %s queue by %s", key.String(), queue,
+			held.Operator)
+	}
+	return held, nil
+}
+
+// findItem returns the item of txid and our signatures if it's in outbox.
+func (signer *Signer) findItem(txid *chainhash.Hash) (*utils.ToSignItem, [][]byte, error) {
+	if ob, err := signer.vdb.GetOutbox(txid[:]); err == nil {
+		return ob.Item, ob.Sigs, nil
+	}
+	if held, err := signer.vdb.GetApproval(txid[:]); err == nil {
+		return held.Item, nil, nil
+	}
+	if held, err := signer.vdb.GetDelayed(txid[:]); err == nil {
+		return held.Item, nil, nil
+	}
+	frozen, err := signer.vdb.GetAllFrozen()
+	if err != nil {
+		return nil, nil, fmt.Errorf("[Signer] failed to get frozen txs: %v", err)
+	}
+	if held, ok := frozen[*txid]; ok {
+		return held.Item, nil, nil
+	}
+	return nil, nil, fmt.Errorf("[Signer] tx %s not found", txid.String())
+}
+
+// fillPkScripts sets the scripts spent by item, which are cleared after signing, by
+// checking which kind of sighash our signatures are made for.
+func (rd *Redeem) fillPkScripts(item *utils.ToSignItem, sigs [][]byte) error {
+	if len(sigs) != len(item.Mtx.TxIn) || len(item.Amts) != len(item.Mtx.TxIn) {
+		return fmt.Errorf("%d sigs and %d amounts for %d inputs", len(sigs), len(item.Amts), len(item.Mtx.TxIn))
+	}
+	mtx := item.Mtx.Copy()
+	for _, in := range mtx.TxIn {
+		in.SignatureScript = nil
+		in.Witness = nil
+	}
+	sh := txscript.NewTxSigHashes(mtx)
+	pubk := rd.ks.PubKey()
+	for i, sig := range sigs {
+		if len(sig) < 2 {
+			return fmt.Errorf("signature of input %d too short", i)
+		}
+		pSig, err := btcec.ParseDERSignature(sig[:len(sig)-1], btcec.S256())
+		if err != nil {
+			return fmt.Errorf("failed to parse signature of input %d: %v", i, err)
+		}
+		hash, err := txscript.CalcSignatureHash(rd.redeem, txscript.SigHashAll, mtx, i)
+		if err != nil {
+			return err
+		}
+		if pSig.Verify(hash, pubk) {
+			item.Mtx.TxIn[i].SignatureScript = rd.p2shScript
+			continue
+		}
+		hash, err = txscript.CalcWitnessSigHash(rd.redeem, sh, txscript.SigHashAll, mtx, i, int64(item.Amts[i]))
+		if err != nil {
+			return err
+		}
+		if !pSig.Verify(hash, pubk) {
+			return fmt.Errorf("signature of input %d not match", i)
+		}
+		item.Mtx.TxIn[i].SignatureScript = rd.p2wshScript
+	}
+	return nil
+}
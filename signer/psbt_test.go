@@ -0,0 +1,152 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package signer
+
+import (
+	"encoding/hex"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/polynetwork/btc-vendor-tools/config"
+	"github.com/polynetwork/btc-vendor-tools/db"
+	"github.com/polynetwork/btc-vendor-tools/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+// getWitnessItem returns an item of the redeem spending p2wsh only, which can be exported.
+func getWitnessItem(signer *Signer) (*utils.ToSignItem, [][]byte) {
+	item, _ := getKeyItem(signer)
+	item.Mtx.TxIn[0].PreviousOutPoint = *wire.NewOutPoint(&chainhash.Hash{3}, 0)
+	item.Mtx.TxIn[0].SignatureScript = theRedeem(signer).p2wshScript
+	item.Mtx.TxIn[1].PreviousOutPoint = *wire.NewOutPoint(&chainhash.Hash{4}, 1)
+	return item, [][]byte{theRedeem(signer).p2wshScript, theRedeem(signer).p2wshScript}
+}
+
+func TestSigner_ExportPSBT(t *testing.T) {
+	vdb, err := db.NewVendorDB("./temp")
+	assert.NoError(t, err)
+	defer os.RemoveAll("./temp")
+	signer := getKeySigner(t)
+	signer.vdb = vdb
+	signer.policy = NewPolicy(nil, vdb)
+	signer.wake = make(chan struct{}, 1)
+
+	// previous tx of the p2sh input is unknown
+	item, _ := getKeyItem(signer)
+	key := utils.GetUnsignedTxHash(item.Mtx)
+	assert.NoError(t, signer.Sign(item))
+	_, err = signer.ExportPSBT(&key)
+	assert.Error(t, err)
+
+	item, pkScripts := getWitnessItem(signer)
+	key = utils.GetUnsignedTxHash(item.Mtx)
+	_, err = signer.ExportPSBT(&key)
+	assert.Error(t, err)
+	assert.NoError(t, signer.Sign(item))
+
+	p, err := signer.ExportPSBT(&key)
+	assert.NoError(t, err)
+	ob, err := vdb.GetOutbox(key[:])
+	assert.NoError(t, err)
+	pubk := hex.EncodeToString(theRedeem(signer).ks.PubKey().SerializeCompressed())
+	for i, pi := range p.Inputs {
+		assert.Equal(t, ob.Sigs[i], pi.PartialSigs[pubk])
+	}
+	exported, err := p.ToSignItem()
+	assert.NoError(t, err)
+	for i, in := range exported.Mtx.TxIn {
+		assert.Equal(t, pkScripts[i], in.SignatureScript)
+	}
+
+	// the outboxed item has no scripts any more
+	assert.NoError(t, signer.ImportPSBT(p, "alice"))
+}
+
+func TestSigner_ImportPSBT(t *testing.T) {
+	vdb, err := db.NewVendorDB("./temp")
+	assert.NoError(t, err)
+	defer os.RemoveAll("./temp")
+	signer := getKeySigner(t)
+	signer.vdb = vdb
+	signer.policy = NewPolicy(&config.SignPolicy{ApprovalValue: 10000}, vdb)
+	signer.wake = make(chan struct{}, 1)
+	rd := theRedeem(signer)
+
+	// signed by external software, with the previous tx of the p2sh input
+	prev := wire.NewMsgTx(wire.TxVersion)
+	prev.AddTxOut(wire.NewTxOut(10000, rd.p2shScript))
+	prevHash := prev.TxHash()
+	getItem := func() *utils.ToSignItem {
+		item, _ := getKeyItem(signer)
+		item.Mtx.TxIn[0].PreviousOutPoint = *wire.NewOutPoint(&prevHash, 0)
+		return item
+	}
+	item := getItem()
+	txHash := item.Mtx.TxHash()
+	p := &utils.PSBT{
+		Tx: item.Mtx.Copy(),
+		Inputs: []*utils.PSBTInput{
+			{NonWitnessUtxo: prev, RedeemScript: rd.redeem, PartialSigs: make(map[string][]byte)},
+			{WitnessUtxo: wire.NewTxOut(10000, rd.p2wshScript), WitnessScript: rd.redeem,
+				PartialSigs: make(map[string][]byte)},
+		},
+		Outputs: []*utils.PSBTOutput{{}, {WitnessScript: rd.redeem}},
+	}
+	for _, in := range p.Tx.TxIn {
+		in.SignatureScript = nil
+	}
+	sigs, err := signer.getSigs(rd, item)
+	assert.NoError(t, err)
+	pubk := hex.EncodeToString(rd.ks.PubKey().SerializeCompressed())
+	key := utils.GetUnsignedTxHash(item.Mtx)
+
+	// not captured from poly
+	p.Inputs[0].PartialSigs[pubk] = sigs[0]
+	p.Inputs[1].PartialSigs[pubk] = sigs[1]
+	assert.Error(t, signer.ImportPSBT(p, "alice"))
+
+	// held for approval
+	assert.NoError(t, signer.Sign(getItem()))
+	assert.Error(t, signer.ImportPSBT(p, "alice"))
+	_, err = vdb.GetOutbox(key[:])
+	assert.Error(t, err)
+
+	// approved while our key was away
+	held, err := vdb.GetApproval(key[:])
+	assert.NoError(t, err)
+	held.Status = utils.HELD_APPROVED
+	assert.NoError(t, vdb.PutApproval(key[:], held))
+
+	// amounts differ from the captured ones
+	p.Inputs[1].WitnessUtxo.Value += 1000
+	assert.Error(t, signer.ImportPSBT(p, "alice"))
+	p.Inputs[1].WitnessUtxo.Value -= 1000
+
+	delete(p.Inputs[1].PartialSigs, pubk)
+	assert.Error(t, signer.ImportPSBT(p, "alice"))
+	p.Inputs[1].PartialSigs[pubk] = sigs[0]
+	assert.Error(t, signer.ImportPSBT(p, "alice"))
+	p.Inputs[1].PartialSigs[pubk] = sigs[1]
+	assert.NoError(t, signer.ImportPSBT(p, "alice"))
+
+	ob, err := vdb.GetOutbox(key[:])
+	assert.NoError(t, err)
+	assert.Equal(t, sigs, ob.Sigs)
+	assert.Equal(t, txHash, ob.TxHash)
+	assert.Equal(t, utils.OUTBOX_PENDING, ob.Status)
+}
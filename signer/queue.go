@@ -0,0 +1,77 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package signer
+
+import (
+	"github.com/polynetwork/btc-vendor-tools/log"
+	"github.com/polynetwork/btc-vendor-tools/utils"
+	"time"
+)
+
+const QUEUE_RETRY_INTERVAL = time.Minute
+
+// Signing signs items queued in db by the observer in order of their capture height.
+// An item is removed from the queue once it's handled, and it stays for the next round
+// if signing failed for other reasons than rejection.
+func (signer *Signer) Signing() {
+	log.Infof("[Signer] start signing")
+	ticker := time.NewTicker(QUEUE_RETRY_INTERVAL)
+	defer ticker.Stop()
+	for {
+		signer.consumeQueue()
+		select {
+		case <-signer.queued:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (signer *Signer) consumeQueue() {
+	keys, items, err := signer.vdb.GetQueue()
+	if err != nil {
+		log.Errorf("[Signer] failed to read queue from db: %v", err)
+		return
+	}
+	for i, item := range items {
+		key := utils.GetUnsignedTxHash(item.Mtx)
+		if err := signer.Sign(item); err != nil && !IsRejection(err) {
+			log.Errorf("[Signer] failed to sign tx %s in queue, retry later: %v", key.String(), err)
+			continue
+		}
+		if err := signer.vdb.AckQueue(keys[i]); err != nil {
+			log.Errorf("[Signer] failed to remove tx %s from queue: %v", key.String(), err)
+		}
+	}
+}
+
+// notifyQueued wakes up Signing for items just put into the queue.
+func (signer *Signer) notifyQueued() {
+	select {
+	case signer.queued <- struct{}{}:
+	default:
+	}
+}
+
+// IsRejection tells if err is our decision on the tx, which won't change by retrying,
+// rather than a failure on the way like db or network errors.
+func IsRejection(err error) bool {
+	switch err.(type) {
+	case PolicyError, InputError:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package signer
+
+import (
+	"errors"
+	"github.com/polynetwork/btc-vendor-tools/db"
+	"github.com/polynetwork/btc-vendor-tools/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestSigner_consumeQueue(t *testing.T) {
+	vdb, err := db.NewVendorDB("./temp")
+	assert.NoError(t, err)
+	defer os.RemoveAll("./temp")
+	signer := getKeySigner(t)
+	signer.vdb = vdb
+	signer.policy = NewPolicy(nil, vdb)
+	signer.wake = make(chan struct{}, 1)
+
+	item, _ := getKeyItem(signer)
+	foreign, _ := getKeyItem(getKeySigner(t))
+	key := utils.GetUnsignedTxHash(item.Mtx)
+	fkey := utils.GetUnsignedTxHash(foreign.Mtx)
+	assert.NoError(t, vdb.PutQueue(10, make([]byte, 32), []*utils.ToSignItem{item, foreign}))
+
+	signer.consumeQueue()
+	_, err = vdb.GetOutbox(key[:])
+	assert.NoError(t, err)
+	_, err = vdb.GetRejectedTx(fkey[:])
+	assert.NoError(t, err)
+	_, items, err := vdb.GetQueue()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(items))
+
+	// failed on the way, retry later
+	rd := theRedeem(signer)
+	ks := rd.ks
+	rd.ks = brokenKeyStore{ks}
+	item, _ = getKeyItem(signer)
+	item.Mtx.TxIn[0].PreviousOutPoint.Index = 5
+	item.Mtx.TxIn[1].PreviousOutPoint.Index = 6
+	key = utils.GetUnsignedTxHash(item.Mtx)
+	assert.NoError(t, vdb.PutQueue(11, make([]byte, 32), []*utils.ToSignItem{item}))
+	signer.consumeQueue()
+	_, items, err = vdb.GetQueue()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(items))
+	_, err = vdb.GetRejectedTx(key[:])
+	assert.Error(t, err)
+
+	rd.ks = ks
+	signer.consumeQueue()
+	_, err = vdb.GetOutbox(key[:])
+	assert.NoError(t, err)
+	_, items, err = vdb.GetQueue()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(items))
+}
+
+// brokenKeyStore fails to sign, like a remote key store that's down.
+type brokenKeyStore struct {
+	KeyStore
+}
+
+func (ks brokenKeyStore) Sign(hash []byte) ([]byte, error) {
+	return nil, errors.New("key store down")
+}
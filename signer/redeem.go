@@ -0,0 +1,140 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package signer
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcutil"
+	"github.com/polynetwork/btc-vendor-tools/config"
+	"github.com/polynetwork/btc-vendor-tools/utils"
+	"time"
+)
+
+// Redeem is a multisig redeem script with our key in it. A signer can serve several
+// of them, and every item is signed by the one its inputs are locked by.
+type Redeem struct {
+	// utxo key of the redeem on poly, the same as the hash key in notifies
+	Key    string
+	redeem []byte
+	ks     KeyStore
+	addr   *btcutil.AddressPubKey
+
+	feeParam feeParamCache
+
+	p2shScript  []byte
+	p2wshScript []byte
+	p2shAddr    string
+	p2wshAddr   string
+
+	// old redeem of a key rotation, retired once nothing is locked under it
+	retiring  bool
+	zeroSince time.Time
+}
+
+// NewRedeem returns a redeem signed with the key in ks.
+func NewRedeem(redeem []byte, ks KeyStore) (*Redeem, error) {
+	addr, err := btcutil.NewAddressPubKey(ks.PubKey().SerializeCompressed(), config.BtcNetParam)
+	if err != nil {
+		return nil, fmt.Errorf("[NewRedeem] failed to new AddressPubKey: %v", err)
+	}
+	p2sh, p2wsh, err := utils.GetRedeemAddrs(redeem, config.BtcNetParam)
+	if err != nil {
+		return nil, fmt.Errorf("[NewRedeem] failed to get addresses of redeem: %v", err)
+	}
+	p2shScript, err := txscript.PayToAddrScript(p2sh)
+	if err != nil {
+		return nil, fmt.Errorf("[NewRedeem] failed to get p2sh script: %v", err)
+	}
+	p2wshScript, err := txscript.PayToAddrScript(p2wsh)
+	if err != nil {
+		return nil, fmt.Errorf("[NewRedeem] failed to get p2wsh script: %v", err)
+	}
+	return &Redeem{
+		Key:         utils.GetUtxoKey(redeem),
+		redeem:      redeem,
+		ks:          ks,
+		addr:        addr,
+		p2shScript:  p2shScript,
+		p2wshScript: p2wshScript,
+		p2shAddr:    p2sh.EncodeAddress(),
+		p2wshAddr:   p2wsh.EncodeAddress(),
+	}, nil
+}
+
+// SetRetiring marks rd as the old redeem of a key rotation. It's still used to sign
+// until all value locked under it is moved away, and then it's retired.
+func (rd *Redeem) SetRetiring(retiring bool) {
+	rd.retiring = retiring
+}
+
+// locks tells if pkScript is the p2sh or p2wsh script of the redeem.
+func (rd *Redeem) locks(pkScript []byte) bool {
+	return bytes.Equal(pkScript, rd.p2shScript) || bytes.Equal(pkScript, rd.p2wshScript)
+}
+
+// isChange tells if addr is one of the multisig addresses of the redeem.
+func (rd *Redeem) isChange(addr string) bool {
+	return addr == rd.p2shAddr || addr == rd.p2wshAddr
+}
+
+func (signer *Signer) getRedeem(key string) (*Redeem, bool) {
+	signer.rlock.RLock()
+	defer signer.rlock.RUnlock()
+
+	rd, ok := signer.redeems[key]
+	return rd, ok
+}
+
+// redeemOf returns the redeem that item should be signed with. Items are tagged with the
+// key of their redeem once their inputs are validated. Untagged items are matched by the
+// script of their first input, and items saved before redeems were tagged can only belong
+// to the one redeem we used to serve.
+func (signer *Signer) redeemOf(item *utils.ToSignItem) (*Redeem, error) {
+	signer.rlock.RLock()
+	defer signer.rlock.RUnlock()
+
+	if item.RedeemKey != "" {
+		rd, ok := signer.redeems[item.RedeemKey]
+		if !ok {
+			return nil, InputError{
+				Reason: REASON_FOREIGN_INPUT,
+				Index:  -1,
+				Desc:   fmt.Sprintf("redeem %s is not served by us", item.RedeemKey),
+			}
+		}
+		return rd, nil
+	}
+	if len(item.Mtx.TxIn) > 0 {
+		for _, rd := range signer.redeems {
+			if rd.locks(item.Mtx.TxIn[0].SignatureScript) {
+				return rd, nil
+			}
+		}
+	}
+	if len(signer.redeems) == 1 {
+		for _, rd := range signer.redeems {
+			return rd, nil
+		}
+	}
+	return nil, InputError{
+		Reason: REASON_FOREIGN_INPUT,
+		Index:  0,
+		Desc:   "not locked by any redeem of us",
+	}
+}
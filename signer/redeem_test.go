@@ -0,0 +1,56 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package signer
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSigner_redeemOf(t *testing.T) {
+	s1, s2 := getKeySigner(t), getKeySigner(t)
+	rd1, rd2 := theRedeem(s1), theRedeem(s2)
+	signer := newTestSigner(rd1, rd2)
+
+	item, pkScripts := getKeyItem(s2)
+	assert.NoError(t, signer.validateInputs(item))
+	assert.Equal(t, rd2.Key, item.RedeemKey)
+	rd, err := signer.redeemOf(item)
+	assert.NoError(t, err)
+	assert.Equal(t, rd2, rd)
+
+	sum, err := signer.Summarize(item)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(4000), sum.Change)
+	sigs, err := signer.getSigs(rd, item)
+	assert.NoError(t, err)
+	assert.NoError(t, signer.verifySigs(rd, item, pkScripts, sigs))
+
+	// tagged by observer with a redeem not locking the inputs
+	item, _ = getKeyItem(s2)
+	item.RedeemKey = rd1.Key
+	assert.Equal(t, REASON_FOREIGN_INPUT, signer.validateInputs(item).(InputError).Reason)
+	item.RedeemKey = "00"
+	assert.Equal(t, REASON_FOREIGN_INPUT, signer.validateInputs(item).(InputError).Reason)
+
+	// mixing inputs of two redeems
+	item, _ = getKeyItem(s1)
+	item.Mtx.TxIn[1].SignatureScript = rd2.p2wshScript
+	err = signer.validateInputs(item)
+	assert.Equal(t, REASON_FOREIGN_INPUT, err.(InputError).Reason)
+	assert.Equal(t, 1, err.(InputError).Index)
+}
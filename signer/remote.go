@@ -0,0 +1,279 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package signer
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/polynetwork/btc-vendor-tools/log"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	REMOTE_KEYSTORE_TIMEOUT = 30 * time.Second
+	// path of a unix socket starts after this in the address of a remote key store
+	UNIX_SCHEME = "unix://"
+
+	KEYSTORE_PUBKEY_PATH = "/pubkey"
+	KEYSTORE_SIGN_PATH   = "/sign"
+	KEYSTORE_AUTH_HEADER = "X-KeyStore-Auth"
+)
+
+// keyStoreReq is authenticated the same way as SigndReq, by HMAC-SHA256 of the body
+// with the shared secret in header X-KeyStore-Auth, a time and a nonce used only once.
+type keyStoreReq struct {
+	Time  int64  `json:"time"`
+	Nonce string `json:"nonce"`
+	Hash  string `json:"hash,omitempty"`
+}
+
+// keyStoreResp echoes the nonce of the request and is authenticated the same way.
+type keyStoreResp struct {
+	Nonce  string `json:"nonce"`
+	PubKey string `json:"pubkey,omitempty"`
+	Sig    string `json:"sig,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// RemoteKeyStore signs with a key held by another process, reached over a unix
+// socket like unix:///path/to/sock or by HTTP like http://127.0.0.1:port. That
+// process serves KeyStoreHandler with the same secret.
+type RemoteKeyStore struct {
+	url    string
+	cli    *http.Client
+	secret []byte
+	pubk   *btcec.PublicKey
+}
+
+func NewRemoteKeyStore(addr string, secret []byte) (*RemoteKeyStore, error) {
+	if len(secret) < SIGND_MIN_SECRET {
+		return nil, fmt.Errorf("[NewRemoteKeyStore] secret shorter than %d bytes", SIGND_MIN_SECRET)
+	}
+	ks := &RemoteKeyStore{secret: secret}
+	ks.url, ks.cli = newKeyStoreClient(addr)
+	resp, err := ks.call(KEYSTORE_PUBKEY_PATH, nil)
+	if err != nil {
+		return nil, fmt.Errorf("[NewRemoteKeyStore] failed to get pubkey from %s: %v", addr, err)
+	}
+	raw, err := hex.DecodeString(resp.PubKey)
+	if err != nil {
+		return nil, fmt.Errorf("[NewRemoteKeyStore] wrong pubkey %s: %v", resp.PubKey, err)
+	}
+	if ks.pubk, err = btcec.ParsePubKey(raw, btcec.S256()); err != nil {
+		return nil, fmt.Errorf("[NewRemoteKeyStore] failed to parse pubkey %s: %v", resp.PubKey, err)
+	}
+	return ks, nil
+}
+
+func (ks *RemoteKeyStore) PubKey() *btcec.PublicKey {
+	return ks.pubk
+}
+
+// Sign asks the remote for a signature and checks it before returning.
+func (ks *RemoteKeyStore) Sign(hash []byte) ([]byte, error) {
+	resp, err := ks.call(KEYSTORE_SIGN_PATH, hash)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := hex.DecodeString(resp.Sig)
+	if err != nil {
+		return nil, fmt.Errorf("wrong signature from remote: %v", err)
+	}
+	sig, err := btcec.ParseDERSignature(raw, btcec.S256())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signature from remote: %v", err)
+	}
+	if !sig.Verify(hash, ks.pubk) {
+		return nil, fmt.Errorf("signature from remote not match with its pubkey")
+	}
+	return raw, nil
+}
+
+func (ks *RemoteKeyStore) call(path string, hash []byte) (*keyStoreResp, error) {
+	nonce, err := newNonce()
+	if err != nil {
+		return nil, err
+	}
+	req := &keyStoreReq{
+		Time:  time.Now().Unix(),
+		Nonce: nonce,
+	}
+	if hash != nil {
+		req.Hash = hex.EncodeToString(hash)
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	hr, err := http.NewRequest(http.MethodPost, ks.url+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	hr.Header.Set("Content-Type", "application/json")
+	hr.Header.Set(KEYSTORE_AUTH_HEADER, hex.EncodeToString(mac(ks.secret, data)))
+	r, err := ks.cli.Do(hr)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, SIGND_MAX_BODY))
+	if err != nil {
+		return nil, err
+	}
+	if !checkMAC(ks.secret, body, r.Header.Get(KEYSTORE_AUTH_HEADER)) {
+		return nil, fmt.Errorf("response from remote not authenticated (status %d)", r.StatusCode)
+	}
+	resp := &keyStoreResp{}
+	if err = json.Unmarshal(body, resp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response (status %d): %v", r.StatusCode, err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("remote error: %s", resp.Error)
+	}
+	if resp.Nonce != req.Nonce {
+		return nil, fmt.Errorf("response from remote not for our request")
+	}
+	return resp, nil
+}
+
+// KeyStoreHandler serves ks to RemoteKeyStore, so the key can be kept in a separate process.
+// Only requests authenticated with secret are served.
+func KeyStoreHandler(ks KeyStore, secret []byte) (http.Handler, error) {
+	if len(secret) < SIGND_MIN_SECRET {
+		return nil, fmt.Errorf("[KeyStoreHandler] secret shorter than %d bytes", SIGND_MIN_SECRET)
+	}
+	guard := newReplayGuard()
+	// authenticate returns the request, or nil when it's refused and answered
+	authenticate := func(w http.ResponseWriter, r *http.Request) *keyStoreReq {
+		if r.Method != http.MethodPost {
+			writeKeyStoreResp(w, secret, http.StatusMethodNotAllowed, &keyStoreResp{Error: "POST only"})
+			return nil
+		}
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, SIGND_MAX_BODY))
+		if err != nil {
+			writeKeyStoreResp(w, secret, http.StatusBadRequest, &keyStoreResp{Error: "failed to read body"})
+			return nil
+		}
+		if !checkMAC(secret, body, r.Header.Get(KEYSTORE_AUTH_HEADER)) {
+			log.Errorf("[KeyStore][ALERT] request not authenticated from %s", r.RemoteAddr)
+			writeKeyStoreResp(w, secret, http.StatusUnauthorized, &keyStoreResp{Error: "not authenticated"})
+			return nil
+		}
+		req := &keyStoreReq{}
+		if err = json.Unmarshal(body, req); err != nil {
+			writeKeyStoreResp(w, secret, http.StatusBadRequest, &keyStoreResp{Error: err.Error()})
+			return nil
+		}
+		if err = guard.check(req.Time, req.Nonce); err != nil {
+			writeKeyStoreResp(w, secret, http.StatusBadRequest, &keyStoreResp{Nonce: req.Nonce,
+				Error: err.Error()})
+			return nil
+		}
+		return req
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(KEYSTORE_PUBKEY_PATH, func(w http.ResponseWriter, r *http.Request) {
+		req := authenticate(w, r)
+		if req == nil {
+			return
+		}
+		writeKeyStoreResp(w, secret, http.StatusOK, &keyStoreResp{
+			Nonce:  req.Nonce,
+			PubKey: hex.EncodeToString(ks.PubKey().SerializeCompressed()),
+		})
+	})
+	mux.HandleFunc(KEYSTORE_SIGN_PATH, func(w http.ResponseWriter, r *http.Request) {
+		req := authenticate(w, r)
+		if req == nil {
+			return
+		}
+		resp := &keyStoreResp{Nonce: req.Nonce}
+		hash, err := hex.DecodeString(req.Hash)
+		if err != nil || len(hash) != 32 {
+			resp.Error = "hash must be 32 bytes in hex"
+			writeKeyStoreResp(w, secret, http.StatusBadRequest, resp)
+			return
+		}
+		sig, err := ks.Sign(hash)
+		if err != nil {
+			resp.Error = err.Error()
+			writeKeyStoreResp(w, secret, http.StatusInternalServerError, resp)
+			return
+		}
+		resp.Sig = hex.EncodeToString(sig)
+		writeKeyStoreResp(w, secret, http.StatusOK, resp)
+	})
+	return mux, nil
+}
+
+// ServeKeyStore serves ks to RemoteKeyStore at addr, which is a unix socket like
+// unix:///path/to/sock that only our user can connect to, or host:port for HTTP.
+func ServeKeyStore(ks KeyStore, secret []byte, addr string) error {
+	h, err := KeyStoreHandler(ks, secret)
+	if err != nil {
+		return err
+	}
+	var l net.Listener
+	if strings.HasPrefix(addr, UNIX_SCHEME) {
+		l, err = listenUnix(strings.TrimPrefix(addr, UNIX_SCHEME))
+	} else {
+		l, err = net.Listen("tcp", strings.TrimPrefix(addr, "http://"))
+	}
+	if err != nil {
+		return fmt.Errorf("[ServeKeyStore] %v", err)
+	}
+	defer l.Close()
+	log.Infof("[KeyStore] serving key %x on %s", ks.PubKey().SerializeCompressed(), addr)
+	return http.Serve(l, h)
+}
+
+// newKeyStoreClient returns the base url and the client to reach addr, which is a unix
+// socket or an HTTP url.
+func newKeyStoreClient(addr string) (string, *http.Client) {
+	cli := &http.Client{
+		Timeout: REMOTE_KEYSTORE_TIMEOUT,
+	}
+	if !strings.HasPrefix(addr, UNIX_SCHEME) {
+		return addr, cli
+	}
+	sock := strings.TrimPrefix(addr, UNIX_SCHEME)
+	cli.Transport = &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", sock)
+		},
+	}
+	return "http://unix", cli
+}
+
+func writeKeyStoreResp(w http.ResponseWriter, secret []byte, status int, resp *keyStoreResp) {
+	data, _ := json.Marshal(resp)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(KEYSTORE_AUTH_HEADER, hex.EncodeToString(mac(secret, data)))
+	w.WriteHeader(status)
+	w.Write(data)
+}
@@ -0,0 +1,180 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package signer
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcutil"
+	"github.com/polynetwork/btc-vendor-tools/log"
+	"github.com/polynetwork/btc-vendor-tools/utils"
+	"github.com/polynetwork/poly/common"
+	"github.com/polynetwork/poly/native/service/cross_chain_manager/btc"
+	putils "github.com/polynetwork/poly/native/service/utils"
+	"time"
+)
+
+const (
+	ROTATION_CHECK_INTERVAL = 10 * time.Minute
+	// a retiring redeem is retired only after nothing is locked under it for this long,
+	// so the transactions spending its last utxos have time to be captured and signed
+	ROTATION_RETIRE_GRACE = time.Hour
+)
+
+// Rotating watches the value locked under retiring redeems and retires them once
+// it's all moved away and none of their transactions is pending with us.
+func (signer *Signer) Rotating() {
+	if len(signer.getRetiring()) == 0 {
+		return
+	}
+	log.Infof("[Signer] start watching retiring redeems")
+	ticker := time.NewTicker(ROTATION_CHECK_INTERVAL)
+	defer ticker.Stop()
+	for {
+		signer.checkRetiring()
+		if len(signer.getRetiring()) == 0 {
+			log.Infof("[Signer] all retiring redeems are retired")
+			return
+		}
+		<-ticker.C
+	}
+}
+
+func (signer *Signer) checkRetiring() {
+	for _, rd := range signer.getRetiring() {
+		val, err := signer.getLockedValue(rd)
+		if err != nil {
+			log.Errorf("[Signer] failed to get value locked under retiring redeem %s: %v", rd.Key, err)
+			continue
+		}
+		metricLocked.Set(rd.Key, utils.ExpvarInt(int64(val)))
+		if val > 0 {
+			rd.zeroSince = time.Time{}
+			log.Infof("[Signer] %d satoshi still locked under retiring redeem %s", val, rd.Key)
+			continue
+		}
+		if rd.zeroSince.IsZero() {
+			rd.zeroSince = time.Now()
+		}
+		if time.Since(rd.zeroSince) < ROTATION_RETIRE_GRACE {
+			log.Infof("[Signer] nothing locked under retiring redeem %s, retire it after %v", rd.Key,
+				(ROTATION_RETIRE_GRACE - time.Since(rd.zeroSince)).Round(time.Second))
+			continue
+		}
+		pending, err := signer.hasPending(rd.Key)
+		if err != nil {
+			log.Errorf("[Signer] failed to check pending txs of retiring redeem %s: %v", rd.Key, err)
+			continue
+		}
+		if pending {
+			log.Infof("[Signer] retiring redeem %s still has pending txs", rd.Key)
+			continue
+		}
+		signer.retire(rd)
+	}
+}
+
+func (signer *Signer) getRetiring() []*Redeem {
+	signer.rlock.RLock()
+	defer signer.rlock.RUnlock()
+
+	res := make([]*Redeem, 0)
+	for _, rd := range signer.redeems {
+		if rd.retiring {
+			res = append(res, rd)
+		}
+	}
+	return res
+}
+
+// getLockedValue returns the value of utxos locked under rd recorded on poly.
+func (signer *Signer) getLockedValue(rd *Redeem) (uint64, error) {
+	rk := btcutil.Hash160(rd.redeem)
+	store, err := signer.poly.GetStorage(putils.CrossChainManagerContractAddress.ToHexString(),
+		append(append([]byte(btc.UTXOS), putils.GetUint64Bytes(1)...), []byte(hex.EncodeToString(rk))...))
+	if err != nil {
+		return 0, fmt.Errorf("failed to get utxos: %v", err)
+	}
+	if store == nil {
+		return 0, nil
+	}
+	utxos := &btc.Utxos{}
+	if err = utxos.Deserialization(common.NewZeroCopySource(store)); err != nil {
+		return 0, fmt.Errorf("failed to deserialize utxos: %v", err)
+	}
+	sum := uint64(0)
+	for _, u := range utxos.Utxos {
+		sum += u.Value
+	}
+	return sum, nil
+}
+
+// hasPending tells if any tx of the redeem is waiting to be signed or submitted.
+func (signer *Signer) hasPending(redeemKey string) (bool, error) {
+	obs, err := signer.vdb.GetAllOutbox()
+	if err != nil {
+		return false, err
+	}
+	for _, ob := range obs {
+		if ob.Item.RedeemKey == redeemKey && (ob.Status == utils.OUTBOX_PENDING || ob.Status == utils.OUTBOX_SENT) {
+			return true, nil
+		}
+	}
+	_, queued, err := signer.vdb.GetQueue()
+	if err != nil {
+		return false, err
+	}
+	for _, item := range queued {
+		if item.RedeemKey == redeemKey {
+			return true, nil
+		}
+	}
+	for _, getAll := range []func() (map[chainhash.Hash]*utils.HeldItem, error){
+		signer.vdb.GetAllApprovals,
+		signer.vdb.GetAllDelayed,
+		signer.vdb.GetAllFrozen,
+	} {
+		items, err := getAll()
+		if err != nil {
+			return false, err
+		}
+		for _, v := range items {
+			if v.Item.RedeemKey == redeemKey && v.Status == utils.HELD_PENDING {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// retire stops using the key of rd for good.
+func (signer *Signer) retire(rd *Redeem) {
+	// not in the middle of signing anything
+	signer.lock.Lock()
+	defer signer.lock.Unlock()
+
+	if err := signer.vdb.PutRetired(rd.Key); err != nil {
+		log.Errorf("[Signer] failed to save retired redeem %s: %v", rd.Key, err)
+		return
+	}
+	signer.rlock.Lock()
+	delete(signer.redeems, rd.Key)
+	signer.rlock.Unlock()
+	log.Infof("[Signer] nothing is locked under redeem %s any more and its key %s is retired", rd.Key,
+		rd.addr.EncodeAddress())
+}
@@ -0,0 +1,76 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package signer
+
+import (
+	"github.com/polynetwork/btc-vendor-tools/db"
+	"github.com/polynetwork/btc-vendor-tools/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSigner_retire(t *testing.T) {
+	vdb, err := db.NewVendorDB("./temp")
+	assert.NoError(t, err)
+	defer os.RemoveAll("./temp")
+	oldSigner, newSigner := getKeySigner(t), getKeySigner(t)
+	old, rd := theRedeem(oldSigner), theRedeem(newSigner)
+	old.SetRetiring(true)
+	signer := newTestSigner(old, rd)
+	signer.vdb = vdb
+	assert.Equal(t, []*Redeem{old}, signer.getRetiring())
+
+	item, _ := getKeyItem(oldSigner)
+	assert.NoError(t, signer.validateInputs(item))
+	assert.Equal(t, old.Key, item.RedeemKey)
+	key := utils.GetUnsignedTxHash(item.Mtx)
+	assert.NoError(t, vdb.PutDelayed(key[:], &utils.HeldItem{
+		Item:         item,
+		TimeReceived: time.Now(),
+		Status:       utils.HELD_PENDING,
+	}))
+	pending, err := signer.hasPending(old.Key)
+	assert.NoError(t, err)
+	assert.True(t, pending)
+	pending, err = signer.hasPending(rd.Key)
+	assert.NoError(t, err)
+	assert.False(t, pending)
+
+	assert.NoError(t, vdb.PutDelayed(key[:], &utils.HeldItem{
+		Item:         item,
+		TimeReceived: time.Now(),
+		Status:       utils.HELD_APPROVED,
+	}))
+	pending, _ = signer.hasPending(old.Key)
+	assert.False(t, pending)
+
+	signer.retire(old)
+	assert.Equal(t, 0, len(signer.getRetiring()))
+	_, err = signer.redeemOf(item)
+	assert.Equal(t, REASON_FOREIGN_INPUT, err.(InputError).Reason)
+	item, _ = getKeyItem(newSigner)
+	assert.NoError(t, signer.validateInputs(item))
+
+	// retired key is not loaded again
+	s, err := NewSigner([]*Redeem{old, rd}, nil, nil, nil, vdb, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(s.redeems))
+	_, err = NewSigner([]*Redeem{old}, nil, nil, nil, vdb, nil)
+	assert.Error(t, err)
+}
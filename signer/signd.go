@@ -0,0 +1,411 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package signer
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/polynetwork/btc-vendor-tools/log"
+	"github.com/polynetwork/btc-vendor-tools/utils"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// bump it when SigndReq or SigndResp changes in an incompatible way
+	SIGND_VERSION = 1
+
+	SIGND_PATH          = "/signd"
+	SIGND_AUTH_HEADER   = "X-Signd-Auth"
+	SIGND_METHOD_PUBKEY = "pubkey"
+	SIGND_METHOD_SIGN   = "sign"
+	// requests older or newer than this are refused
+	SIGND_MAX_SKEW     = time.Minute
+	SIGND_MAX_BODY     = 1 << 20
+	SIGND_MIN_SECRET   = 16
+	SIGND_NONCE_LENGTH = 16
+)
+
+// SigndReq is a request to signd. Requests and responses are authenticated by
+// HMAC-SHA256 of the body with the shared secret in header X-Signd-Auth.
+type SigndReq struct {
+	Version uint32 `json:"version"`
+	Time    int64  `json:"time"`
+	Nonce   string `json:"nonce"`
+	Method  string `json:"method"`
+	// utxo key of the redeem
+	Redeem string `json:"redeem"`
+	// hex of ToSignItem.Serialize, same as the raw in REST api signtx
+	Item string `json:"item,omitempty"`
+}
+
+// SigndResp echoes the nonce of the request, so it can't be replayed for another request.
+type SigndResp struct {
+	Version uint32   `json:"version"`
+	Nonce   string   `json:"nonce"`
+	PubKey  string   `json:"pubkey,omitempty"`
+	Sigs    []string `json:"sigs,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// ItemSigner is a KeyStore which signs whole items instead of sighashes, so it can
+// check what it signs.
+type ItemSigner interface {
+	SignItem(item *utils.ToSignItem) ([][]byte, error)
+}
+
+// SignDaemon holds only btc keys and returns our signatures for items whose inputs
+// are locked by the redeems it serves. The vendor process talks to it by SigndKeyStore.
+type SignDaemon struct {
+	signer *Signer
+	secret []byte
+	guard  *replayGuard
+}
+
+func NewSignDaemon(redeems []*Redeem, secret []byte) (*SignDaemon, error) {
+	if len(secret) < SIGND_MIN_SECRET {
+		return nil, fmt.Errorf("[NewSignDaemon] secret shorter than %d bytes", SIGND_MIN_SECRET)
+	}
+	for _, rd := range redeems {
+		if _, ok := rd.ks.(*PrivKeyStore); !ok {
+			return nil, fmt.Errorf("[NewSignDaemon] key of redeem %s is not held by this process", rd.Key)
+		}
+	}
+	signer, err := NewSigner(redeems, nil, nil, nil, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("[NewSignDaemon] %v", err)
+	}
+	return &SignDaemon{
+		signer: signer,
+		secret: secret,
+		guard:  newReplayGuard(),
+	}, nil
+}
+
+// Serve listens on the unix socket sock, which only our user can connect to.
+func (d *SignDaemon) Serve(sock string) error {
+	l, err := listenUnix(sock)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	log.Infof("[SignDaemon] listening on %s", sock)
+	mux := http.NewServeMux()
+	mux.Handle(SIGND_PATH, d)
+	return http.Serve(l, mux)
+}
+
+// listenUnix listens on the unix socket sock and makes it only accessible to our user.
+func listenUnix(sock string) (net.Listener, error) {
+	if err := os.Remove(sock); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to remove old socket %s: %v", sock, err)
+	}
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %v", sock, err)
+	}
+	if err = os.Chmod(sock, 0600); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("failed to chmod socket %s: %v", sock, err)
+	}
+	return l, nil
+}
+
+func (d *SignDaemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, SIGND_MAX_BODY))
+	if err != nil {
+		d.respond(w, http.StatusBadRequest, &SigndResp{Error: "failed to read body"})
+		return
+	}
+	if !checkMAC(d.secret, body, r.Header.Get(SIGND_AUTH_HEADER)) {
+		log.Errorf("[SignDaemon][ALERT] request not authenticated from %s", r.RemoteAddr)
+		d.respond(w, http.StatusUnauthorized, &SigndResp{Error: "not authenticated"})
+		return
+	}
+	req := &SigndReq{}
+	if err = json.Unmarshal(body, req); err != nil {
+		d.respond(w, http.StatusBadRequest, &SigndResp{Error: "failed to unmarshal request"})
+		return
+	}
+	resp := &SigndResp{Nonce: req.Nonce}
+	if err = d.checkReq(req); err != nil {
+		resp.Error = err.Error()
+		d.respond(w, http.StatusBadRequest, resp)
+		return
+	}
+
+	rd, ok := d.signer.getRedeem(req.Redeem)
+	if !ok {
+		resp.Error = fmt.Sprintf("redeem %s not served", req.Redeem)
+		d.respond(w, http.StatusNotFound, resp)
+		return
+	}
+	switch req.Method {
+	case SIGND_METHOD_PUBKEY:
+		resp.PubKey = hex.EncodeToString(rd.ks.PubKey().SerializeCompressed())
+	case SIGND_METHOD_SIGN:
+		sigs, err := d.sign(rd, req.Item)
+		if err != nil {
+			log.Errorf("[SignDaemon] refuse to sign: %v", err)
+			resp.Error = err.Error()
+			d.respond(w, http.StatusBadRequest, resp)
+			return
+		}
+		for _, sig := range sigs {
+			resp.Sigs = append(resp.Sigs, hex.EncodeToString(sig))
+		}
+	default:
+		resp.Error = fmt.Sprintf("unknown method %s", req.Method)
+		d.respond(w, http.StatusBadRequest, resp)
+		return
+	}
+	d.respond(w, http.StatusOK, resp)
+}
+
+// checkReq refuses requests of other versions, too old or too new, or seen before.
+func (d *SignDaemon) checkReq(req *SigndReq) error {
+	if req.Version != SIGND_VERSION {
+		return fmt.Errorf("unsupported version %d, expecting %d", req.Version, SIGND_VERSION)
+	}
+	return d.guard.check(req.Time, req.Nonce)
+}
+
+// replayGuard refuses authenticated requests too old or too new, or with a nonce seen before,
+// so a request can't be replayed.
+type replayGuard struct {
+	lock   sync.Mutex
+	nonces map[string]time.Time
+}
+
+func newReplayGuard() *replayGuard {
+	return &replayGuard{
+		nonces: make(map[string]time.Time),
+	}
+}
+
+func (g *replayGuard) check(reqTime int64, nonce string) error {
+	t := time.Unix(reqTime, 0)
+	if skew := time.Since(t); skew > SIGND_MAX_SKEW || skew < -SIGND_MAX_SKEW {
+		return fmt.Errorf("request time %s out of range", t.String())
+	}
+	if len(nonce) != 2*SIGND_NONCE_LENGTH {
+		return fmt.Errorf("wrong length of nonce")
+	}
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for k, v := range g.nonces {
+		if time.Since(v) > 2*SIGND_MAX_SKEW {
+			delete(g.nonces, k)
+		}
+	}
+	if _, ok := g.nonces[nonce]; ok {
+		return fmt.Errorf("nonce used")
+	}
+	g.nonces[nonce] = time.Now()
+	return nil
+}
+
+func newNonce() (string, error) {
+	nonce := make([]byte, SIGND_NONCE_LENGTH)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(nonce), nil
+}
+
+// sign checks that the inputs of item are locked by rd and signs it.
+func (d *SignDaemon) sign(rd *Redeem, raw string) ([][]byte, error) {
+	rawItem, err := hex.DecodeString(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode item: %v", err)
+	}
+	item := &utils.ToSignItem{}
+	if err = item.Deserialize(rawItem); err != nil {
+		return nil, fmt.Errorf("failed to deserialize item: %v", err)
+	}
+	item.RedeemKey = rd.Key
+	if err = d.signer.validateInputs(item); err != nil {
+		return nil, err
+	}
+	if _, err = d.signer.Summarize(item); err != nil {
+		return nil, err
+	}
+	pkScripts := make([][]byte, len(item.Mtx.TxIn))
+	for i, in := range item.Mtx.TxIn {
+		pkScripts[i] = in.SignatureScript
+	}
+	txHash := utils.GetUnsignedTxHash(item.Mtx)
+	sigs, err := d.signer.getSigs(rd, item)
+	if err != nil {
+		return nil, err
+	}
+	if err = d.signer.verifySigs(rd, item, pkScripts, sigs); err != nil {
+		return nil, err
+	}
+	log.Infof("[SignDaemon] signed tx %s for redeem %s", txHash.String(), rd.Key)
+	return sigs, nil
+}
+
+func (d *SignDaemon) respond(w http.ResponseWriter, status int, resp *SigndResp) {
+	resp.Version = SIGND_VERSION
+	data, _ := json.Marshal(resp)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(SIGND_AUTH_HEADER, hex.EncodeToString(mac(d.secret, data)))
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// SigndKeyStore is the key store of a redeem whose key is held by signd. It only signs
+// whole items.
+type SigndKeyStore struct {
+	url    string
+	cli    *http.Client
+	secret []byte
+	redeem string
+	pubk   *btcec.PublicKey
+}
+
+// NewSigndKeyStore connects to signd at addr, e.g. unix:///path/to/sock, for the key in redeem.
+func NewSigndKeyStore(addr string, secret []byte, redeem []byte) (*SigndKeyStore, error) {
+	if len(secret) < SIGND_MIN_SECRET {
+		return nil, fmt.Errorf("[NewSigndKeyStore] secret shorter than %d bytes", SIGND_MIN_SECRET)
+	}
+	ks := &SigndKeyStore{
+		secret: secret,
+		redeem: utils.GetUtxoKey(redeem),
+	}
+	ks.url, ks.cli = newKeyStoreClient(addr)
+	resp, err := ks.call(SIGND_METHOD_PUBKEY, "")
+	if err != nil {
+		return nil, fmt.Errorf("[NewSigndKeyStore] failed to get pubkey from %s: %v", addr, err)
+	}
+	raw, err := hex.DecodeString(resp.PubKey)
+	if err != nil {
+		return nil, fmt.Errorf("[NewSigndKeyStore] wrong pubkey %s: %v", resp.PubKey, err)
+	}
+	if ks.pubk, err = btcec.ParsePubKey(raw, btcec.S256()); err != nil {
+		return nil, fmt.Errorf("[NewSigndKeyStore] failed to parse pubkey %s: %v", resp.PubKey, err)
+	}
+	return ks, nil
+}
+
+func (ks *SigndKeyStore) PubKey() *btcec.PublicKey {
+	return ks.pubk
+}
+
+func (ks *SigndKeyStore) Sign(hash []byte) ([]byte, error) {
+	return nil, fmt.Errorf("signd only signs whole items")
+}
+
+// SignItem must be called before the prev pkScripts are cleared from item.
+func (ks *SigndKeyStore) SignItem(item *utils.ToSignItem) ([][]byte, error) {
+	raw, err := item.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := ks.call(SIGND_METHOD_SIGN, hex.EncodeToString(raw))
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Sigs) != len(item.Mtx.TxIn) {
+		return nil, fmt.Errorf("signd returns %d sigs for %d inputs", len(resp.Sigs), len(item.Mtx.TxIn))
+	}
+	sigs := make([][]byte, len(resp.Sigs))
+	for i, v := range resp.Sigs {
+		if sigs[i], err = hex.DecodeString(v); err != nil {
+			return nil, fmt.Errorf("wrong No.%d sig from signd: %v", i, err)
+		}
+	}
+	return sigs, nil
+}
+
+func (ks *SigndKeyStore) call(method, item string) (*SigndResp, error) {
+	nonce, err := newNonce()
+	if err != nil {
+		return nil, err
+	}
+	req := &SigndReq{
+		Version: SIGND_VERSION,
+		Time:    time.Now().Unix(),
+		Nonce:   nonce,
+		Method:  method,
+		Redeem:  ks.redeem,
+		Item:    item,
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	hr, err := http.NewRequest(http.MethodPost, ks.url+SIGND_PATH, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	hr.Header.Set("Content-Type", "application/json")
+	hr.Header.Set(SIGND_AUTH_HEADER, hex.EncodeToString(mac(ks.secret, data)))
+	r, err := ks.cli.Do(hr)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, SIGND_MAX_BODY))
+	if err != nil {
+		return nil, err
+	}
+	if !checkMAC(ks.secret, body, r.Header.Get(SIGND_AUTH_HEADER)) {
+		return nil, fmt.Errorf("response from signd not authenticated (status %d)", r.StatusCode)
+	}
+	resp := &SigndResp{}
+	if err = json.Unmarshal(body, resp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %v", err)
+	}
+	if resp.Version != SIGND_VERSION {
+		return nil, fmt.Errorf("signd speaks version %d, expecting %d", resp.Version, SIGND_VERSION)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("signd error: %s", resp.Error)
+	}
+	if resp.Nonce != req.Nonce {
+		return nil, fmt.Errorf("response from signd not for our request")
+	}
+	return resp, nil
+}
+
+func mac(secret, data []byte) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func checkMAC(secret, data []byte, tag string) bool {
+	raw, err := hex.DecodeString(tag)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(raw, mac(secret, data))
+}
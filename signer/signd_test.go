@@ -0,0 +1,101 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package signer
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSignDaemon(t *testing.T) {
+	secret := []byte("0123456789abcdef")
+	signer := getKeySigner(t)
+	local := theRedeem(signer)
+	_, err := NewSignDaemon([]*Redeem{local}, []byte("short"))
+	assert.Error(t, err)
+	d, err := NewSignDaemon([]*Redeem{local}, secret)
+	assert.NoError(t, err)
+
+	defer os.Remove("./signd.sock")
+	go d.Serve("./signd.sock")
+	time.Sleep(100 * time.Millisecond)
+	ks, err := NewSigndKeyStore(UNIX_SCHEME+"./signd.sock", secret, local.redeem)
+	assert.NoError(t, err)
+	assert.True(t, ks.PubKey().IsEqual(local.ks.PubKey()))
+	rd, err := NewRedeem(local.redeem, ks)
+	assert.NoError(t, err)
+
+	item, pkScripts := getKeyItem(signer)
+	sigs, err := signer.getSigs(rd, item)
+	assert.NoError(t, err)
+	assert.NoError(t, signer.verifySigs(local, item, pkScripts, sigs))
+
+	// item spending inputs of another redeem
+	item, _ = getKeyItem(getKeySigner(t))
+	_, err = ks.SignItem(item)
+	assert.Error(t, err)
+
+	_, err = NewSigndKeyStore(UNIX_SCHEME+"./signd.sock", []byte("fedcba9876543210"), local.redeem)
+	assert.Error(t, err)
+	_, err = NewSigndKeyStore(UNIX_SCHEME+"./signd.sock", []byte("short"), local.redeem)
+	assert.Error(t, err)
+}
+
+func TestSignDaemon_checkReq(t *testing.T) {
+	secret := []byte("0123456789abcdef")
+	d, err := NewSignDaemon([]*Redeem{theRedeem(getKeySigner(t))}, secret)
+	assert.NoError(t, err)
+	srv := httptest.NewServer(d)
+	defer srv.Close()
+
+	post := func(req *SigndReq) int {
+		data, _ := json.Marshal(req)
+		hr, _ := http.NewRequest(http.MethodPost, srv.URL, bytes.NewReader(data))
+		hr.Header.Set(SIGND_AUTH_HEADER, hex.EncodeToString(mac(secret, data)))
+		resp, err := http.DefaultClient.Do(hr)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	req := &SigndReq{
+		Version: SIGND_VERSION,
+		Time:    time.Now().Unix(),
+		Nonce:   hex.EncodeToString(make([]byte, SIGND_NONCE_LENGTH)),
+		Method:  SIGND_METHOD_PUBKEY,
+		Redeem:  theRedeem(d.signer).Key,
+	}
+	assert.Equal(t, http.StatusOK, post(req))
+	// replayed
+	assert.Equal(t, http.StatusBadRequest, post(req))
+
+	req.Nonce = hex.EncodeToString(bytes.Repeat([]byte{1}, SIGND_NONCE_LENGTH))
+	req.Version = SIGND_VERSION + 1
+	assert.Equal(t, http.StatusBadRequest, post(req))
+	req.Version = SIGND_VERSION
+	req.Time = time.Now().Add(-2 * SIGND_MAX_SKEW).Unix()
+	assert.Equal(t, http.StatusBadRequest, post(req))
+	req.Time = time.Now().Unix()
+	req.Redeem = "00"
+	assert.Equal(t, http.StatusNotFound, post(req))
+}
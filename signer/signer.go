@@ -18,153 +18,276 @@ package signer
 
 import (
 	"fmt"
-	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/txscript"
-	"github.com/btcsuite/btcutil"
-	"github.com/ontio/ontology-crypto/ec"
 	sdk "github.com/polynetwork/poly-go-sdk"
-	"github.com/polynetwork/poly-go-sdk/client"
 	"github.com/polynetwork/btc-vendor-tools/config"
 	"github.com/polynetwork/btc-vendor-tools/db"
 	"github.com/polynetwork/btc-vendor-tools/log"
 	"github.com/polynetwork/btc-vendor-tools/utils"
+	"sync"
 	"time"
 )
 
 type Signer struct {
-	txchan chan *utils.ToSignItem
-	privk  *btcec.PrivateKey
-	addr   *btcutil.AddressPubKey
-	poly   *sdk.PolySdk
-	acct   *sdk.Account
-	redeem []byte
-	vdb    *db.VendorDB
+	queued  chan struct{}
+	poly    *sdk.PolySdk
+	acct    *sdk.Account
+	redeems map[string]*Redeem
+	rlock   sync.RWMutex
+	vdb     *db.VendorDB
+	policy  *Policy
+	wake    chan struct{}
+	shadow  bool
+	frozen  int32
+	// make sure items are checked and signed one by one
+	lock sync.Mutex
 }
 
-func NewSigner(privkFile string, pwd []byte, txchan chan *utils.ToSignItem, acct *sdk.Account, poly *sdk.PolySdk,
-	redeem []byte, vdb *db.VendorDB) (*Signer, error) {
-	btcAcct, err := utils.GetAccountByPassword(poly, privkFile, pwd)
-	if err != nil {
-		return nil, fmt.Errorf("[NewSigner] failed to get btc account: %v", err)
+func NewSigner(redeems []*Redeem, queued chan struct{}, acct *sdk.Account, poly *sdk.PolySdk,
+	vdb *db.VendorDB, policy *config.SignPolicy) (*Signer, error) {
+	if len(redeems) == 0 {
+		return nil, fmt.Errorf("[NewSigner] no redeem to serve")
 	}
-	privkP256 := btcec.PrivateKey(*btcAcct.GetPrivateKey().(*ec.PrivateKey).PrivateKey)
-	privk, _ := btcec.PrivKeyFromBytes(btcec.S256(), (&privkP256).Serialize())
-	addr, err := btcutil.NewAddressPubKey(privk.PubKey().SerializeCompressed(), config.BtcNetParam)
-	if err != nil {
-		return nil, fmt.Errorf("[NewSigner] failed to new AddressPubKey: %v", err)
+	rds := make(map[string]*Redeem)
+	for _, rd := range redeems {
+		if _, ok := rds[rd.Key]; ok {
+			return nil, fmt.Errorf("[NewSigner] duplicate redeem %s", rd.Key)
+		}
+		if rd.retiring && vdb != nil {
+			if ok, err := vdb.IsRetired(rd.Key); err != nil {
+				return nil, fmt.Errorf("[NewSigner] failed to read retired redeem from db: %v", err)
+			} else if ok {
+				log.Warnf("[NewSigner] redeem %s is already retired, remove it from config", rd.Key)
+				continue
+			}
+		}
+		rds[rd.Key] = rd
+	}
+	if len(rds) == 0 {
+		return nil, fmt.Errorf("[NewSigner] all redeems are retired")
+	}
+
+	frozen := int32(0)
+	if vdb != nil {
+		if ok, err := vdb.IsFrozen(); err != nil {
+			return nil, fmt.Errorf("[NewSigner] failed to read freeze from db: %v", err)
+		} else if ok {
+			log.Warnf("[NewSigner] signing is frozen, unfreeze it through REST api")
+			frozen = 1
+		}
 	}
 
 	return &Signer{
-		txchan: txchan,
-		privk:  privk,
-		addr:   addr,
-		acct:   acct,
-		poly:   poly,
-		redeem: redeem,
-		vdb:    vdb,
+		queued:  queued,
+		acct:    acct,
+		poly:    poly,
+		redeems: rds,
+		vdb:     vdb,
+		policy:  NewPolicy(policy, vdb),
+		wake:    make(chan struct{}, 1),
+		frozen:  frozen,
 	}, nil
 }
 
-func (signer *Signer) Signing() {
-	log.Infof("[Signer] start signing")
-	key := utils.GetUtxoKey(signer.redeem)
-	for {
-		select {
-		case item := <-signer.txchan:
-			txHash := item.Mtx.TxHash()
-			sigs, err := signer.getSigs(item)
-			if err != nil {
-				log.Errorf("[Signer] failed to sign (unsigned tx hash %s), not supposed to happen: "+
-					"%v", txHash.String(), err)
-				continue
-			}
-		RETRY:
-			txid, err := signer.poly.Native.Ccm.BtcMultiSign(1, key, txHash[:], signer.addr.EncodeAddress(),
-				sigs, signer.acct)
-			if err != nil {
-				switch err.(type) {
-				case client.PostErr:
-					log.Errorf("[Signer] post err and would retry after %d sec: %v", config.SleepTime, err)
-					utils.Wait(config.SleepTime)
-					goto RETRY
-				default:
-					log.Errorf("[Signer] account %s failed to invoke polygon: %v", signer.addr.EncodeAddress(), err)
-				}
-				continue
-			}
+// SetShadow makes signer check and sign everything as usual but only record the signatures
+// in db instead of sending them to poly.
+func (signer *Signer) SetShadow(shadow bool) {
+	signer.shadow = shadow
+}
 
-			mtx := item.Mtx.Copy()
-			for _, v := range mtx.TxIn {
-				v.SignatureScript = nil
-			}
-			key := mtx.TxHash()
-			if err = signer.vdb.PutSignedTx(key[:], &utils.SavedItem{
-				Item:         item,
-				TimeReceived: time.Now(),
-				Done:         false,
-			}); err != nil {
-				log.Errorf("[Signer] failed to save item key:%s into db: %v", key.String(), err)
-			}
-			log.Infof("[Signer] signed for btc tx %s (db-key: %s) and send tx %s to polygon", txHash.String(),
-				key.String(), txid.ToHexString())
+// Sign checks item and signs it unless signing is frozen or it has to wait for approval
+// of the operator or for a veto window. The signatures are put into outbox which is sent to poly by Submitting.
+func (signer *Signer) Sign(item *utils.ToSignItem) error {
+	signer.lock.Lock()
+	defer signer.lock.Unlock()
+
+	return signer.handle(item)
+}
+
+// handle is Sign with signer.lock held.
+func (signer *Signer) handle(item *utils.ToSignItem) error {
+	if signer.IsFrozen() {
+		return signer.holdForFreeze(item)
+	}
+	sum, err := signer.check(item)
+	if err != nil {
+		return err
+	}
+	if signer.needApproval(sum) {
+		return signer.holdForApproval(item, sum)
+	}
+	if signer.needDelay(sum) {
+		return signer.holdForDelay(item, sum)
+	}
+	return signer.sign(item, sum)
+}
+
+// check checks item against our policy. Rejected items are saved into db.
+func (signer *Signer) check(item *utils.ToSignItem) (*TxSummary, error) {
+	if err := signer.validateInputs(item); err != nil {
+		signer.reject(item, err)
+		return nil, err
+	}
+	if err := signer.checkConflicts(item); err != nil {
+		signer.reject(item, err)
+		return nil, err
+	}
+	sum, err := signer.Summarize(item)
+	if err != nil {
+		signer.reject(item, err)
+		return nil, err
+	}
+	if err := signer.policy.Check(item, sum); err != nil {
+		signer.reject(item, err)
+		return nil, err
+	}
+	if err := signer.checkFeeRate(item, sum); err != nil {
+		signer.reject(item, err)
+		return nil, err
+	}
+	// external rules go last, so the webhook only sees what we are willing to sign
+	if signer.policy.hook != nil {
+		if err := signer.policy.hook.Check(item, sum); err != nil {
+			signer.reject(item, err)
+			return nil, err
 		}
 	}
+	return sum, nil
 }
 
-func (signer *Signer) Sign(item *utils.ToSignItem) error {
+// sign signs a checked item and puts the signatures into outbox.
+func (signer *Signer) sign(item *utils.ToSignItem, sum *TxSummary) error {
 	txHash := item.Mtx.TxHash()
-	key := utils.GetUtxoKey(signer.redeem)
-	sigs, err := signer.getSigs(item)
+	rd, err := signer.redeemOf(item)
+	if err != nil {
+		log.Errorf("[Signer] no redeem to sign tx %s, not supposed to happen: %v", txHash.String(), err)
+		return err
+	}
+	pkScripts := make([][]byte, len(item.Mtx.TxIn))
+	for i, in := range item.Mtx.TxIn {
+		pkScripts[i] = in.SignatureScript
+	}
+	sigs, err := signer.getSigs(rd, item)
 	if err != nil {
 		log.Errorf("[Signer] failed to sign (unsigned tx hash %s), not supposed to happen: "+
 			"%v", txHash.String(), err)
 		return err
 	}
+	return signer.accept(rd, item, sum, txHash, pkScripts, sigs)
+}
 
-RETRY:
-	txid, err := signer.poly.Native.Ccm.BtcMultiSign(1, key, txHash[:], signer.addr.EncodeAddress(), sigs, signer.acct)
-	if err != nil {
-		switch err.(type) {
-		case client.PostErr:
-			log.Errorf("[Signer] post err and would retry after %d sec: %v", config.SleepTime, err)
-			utils.Wait(config.SleepTime)
-			goto RETRY
-		default:
-			log.Errorf("[Signer] failed to invoke polygon: %v", err)
-			return err
-		}
+// accept verifies sigs of the checked item and puts them into outbox. txHash is the hash
+// of item known by poly, with pkScripts in it.
+func (signer *Signer) accept(rd *Redeem, item *utils.ToSignItem, sum *TxSummary, txHash chainhash.Hash,
+	pkScripts [][]byte, sigs [][]byte) error {
+	if err := signer.verifySigs(rd, item, pkScripts, sigs); err != nil {
+		log.Errorf("[Signer] our signatures for tx %s failed to pass verification: %v", txHash.String(), err)
+		return err
+	}
+	if signer.shadow {
+		return signer.recordShadow(item, txHash, sigs)
+	}
+	if err := signer.recordOutpoints(item); err != nil {
+		log.Errorf("[Signer] %v", err)
+		return err
+	}
+	if err := signer.enqueue(item, txHash, sigs); err != nil {
+		log.Errorf("[Signer] %v", err)
+		return err
+	}
+	if err := signer.policy.Record(item, sum); err != nil {
+		log.Errorf("[Signer] failed to record value of tx %s: %v", txHash.String(), err)
 	}
-	log.Infof("[Signer] signed for btc tx %s and send tx %s to polygon", txHash.String(), txid.ToHexString())
+	metricSigned.Add(rd.Key, 1)
+	log.Infof("[Signer] signed for btc tx %s with redeem %s and put it into outbox", txHash.String(), rd.Key)
 	return nil
 }
 
-func (signer *Signer) getSigs(item *utils.ToSignItem) ([][]byte, error) {
+// recordShadow only keeps sigs under the shadow prefix. Spent outpoints and values are not
+// recorded, so shadow signing never counts against double spend checks or limits of SignPolicy.
+func (signer *Signer) recordShadow(item *utils.ToSignItem, txHash chainhash.Hash, sigs [][]byte) error {
+	key := utils.GetUnsignedTxHash(item.Mtx)
+	if err := signer.vdb.PutShadowTx(key[:], &utils.OutboxItem{
+		Item:         item,
+		TxHash:       txHash,
+		Sigs:         sigs,
+		TimeReceived: time.Now(),
+	}); err != nil {
+		log.Errorf("[Signer] failed to save shadow tx %s into db: %v", key.String(), err)
+		return err
+	}
+	log.Infof("[Signer] shadow mode: signed for btc tx %s (db-key: %s) and not send it to polygon",
+		txHash.String(), key.String())
+	return nil
+}
+
+func (signer *Signer) reject(item *utils.ToSignItem, err error) {
+	key := utils.GetUnsignedTxHash(item.Mtx)
+	reason := reasonOf(err)
+	log.Errorf("[Signer] refuse to sign tx %s: %v", key.String(), err)
+	metricRejected.Add(redeemTag(item), 1)
+	if signer.vdb == nil {
+		return
+	}
+	if err := signer.vdb.PutRejectedTx(key[:], &utils.RejectedItem{
+		Item:         item,
+		TimeReceived: time.Now(),
+		Reason:       reason,
+	}); err != nil {
+		log.Errorf("[Signer] failed to save rejected tx %s into db: %v", key.String(), err)
+	}
+}
+
+// reasonOf returns the reason of a rejection, or the whole error for others.
+func reasonOf(err error) string {
+	switch e := err.(type) {
+	case PolicyError:
+		return e.Reason
+	case InputError:
+		return e.Reason
+	}
+	return err.Error()
+}
+
+func (signer *Signer) getSigs(rd *Redeem, item *utils.ToSignItem) ([][]byte, error) {
+	if is, ok := rd.ks.(ItemSigner); ok {
+		sigs, err := is.SignItem(item)
+		for _, in := range item.Mtx.TxIn {
+			in.SignatureScript = nil
+		}
+		return sigs, err
+	}
 	sigs := make([][]byte, 0)
 	pkScripts := make([][]byte, len(item.Mtx.TxIn))
 	for i, in := range item.Mtx.TxIn {
 		pkScripts[i] = in.SignatureScript
 		item.Mtx.TxIn[i].SignatureScript = nil
 	}
-	var sig []byte
+	var sh *txscript.TxSigHashes
+	var hash []byte
 	var err error
 	for i, pks := range pkScripts {
 		switch c := txscript.GetScriptClass(pks); c {
 		case txscript.MultiSigTy, txscript.ScriptHashTy:
-			sig, err = txscript.RawTxInSignature(item.Mtx, i, signer.redeem, txscript.SigHashAll, signer.privk)
-			if err != nil {
-				return nil, fmt.Errorf("Failed to sign tx's No.%d input(%s): %v", i, c, err)
-			}
+			hash, err = txscript.CalcSignatureHash(rd.redeem, txscript.SigHashAll, item.Mtx, i)
 		case txscript.WitnessV0ScriptHashTy:
-			sh := txscript.NewTxSigHashes(item.Mtx)
-			sig, err = txscript.RawTxInWitnessSignature(item.Mtx, sh, i, int64(item.Amts[i]), signer.redeem,
-				txscript.SigHashAll, signer.privk)
-			if err != nil {
-				return nil, fmt.Errorf("Failed to sign tx's No.%d input(%s): %v", i, c, err)
+			if sh == nil {
+				sh = txscript.NewTxSigHashes(item.Mtx)
 			}
+			hash, err = txscript.CalcWitnessSigHash(rd.redeem, sh, txscript.SigHashAll, item.Mtx, i,
+				int64(item.Amts[i]))
 		default:
 			return nil, fmt.Errorf("wrong type of input: %s", c)
 		}
-		sigs = append(sigs, sig)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get sighash of tx's No.%d input: %v", i, err)
+		}
+		sig, err := rd.ks.Sign(hash)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to sign tx's No.%d input: %v", i, err)
+		}
+		sigs = append(sigs, append(sig, byte(txscript.SigHashAll)))
 	}
 
 	return sigs, nil
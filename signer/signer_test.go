@@ -30,12 +30,15 @@ import (
 	common2 "github.com/polynetwork/poly/native/service/header_sync/common"
 	utils2 "github.com/polynetwork/poly/native/service/utils"
 	"github.com/polynetwork/btc-vendor-tools/config"
+	"github.com/polynetwork/btc-vendor-tools/db"
 	"github.com/polynetwork/btc-vendor-tools/utils"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"io/ioutil"
 	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
 	"time"
 )
@@ -47,29 +50,51 @@ var (
 	amts   = []uint64{11651}
 )
 
+// skipWithout skips a test depending on fixtures which are not in the repo.
+func skipWithout(t *testing.T, files ...string) {
+	for _, f := range files {
+		if _, err := os.Stat(f); os.IsNotExist(err) {
+			t.Skipf("fixture %s not found", f)
+		}
+	}
+}
+
 func TestNewSigner(t *testing.T) {
+	skipWithout(t, "../wallet.dat", privk)
 	config.BtcNetParam = &chaincfg.RegressionNetParams
-	txchan := make(chan *utils.ToSignItem, 10)
+	queued := make(chan struct{}, 1)
 	poly := sdk.NewPolySdk()
 	poly.NewRpcClient().SetAddress(startMockPolyServer())
 	acct, err := utils.GetAccountByPassword(poly, "../wallet.dat", []byte("1"))
-	assert.NoError(t, err)
+	require.NoError(t, err)
 
 	rb, _ := hex.DecodeString(redeem)
-	_, err = NewSigner(privk, "123", txchan, acct, poly, rb)
+	ks, err := NewWalletKeyStore(poly, privk, []byte("123"))
+	require.NoError(t, err)
+	rd, err := NewRedeem(rb, ks)
+	require.NoError(t, err)
+	_, err = NewSigner([]*Redeem{rd}, queued, acct, poly, nil, nil)
 	assert.NoError(t, err)
 }
 
 func TestSigner_Signing(t *testing.T) {
+	skipWithout(t, "../wallet.dat", privk)
 	config.BtcNetParam = &chaincfg.RegressionNetParams
-	txchan := make(chan *utils.ToSignItem, 10)
+	queued := make(chan struct{}, 1)
 	poly := sdk.NewPolySdk()
 	poly.NewRpcClient().SetAddress(startMockPolyServer())
 	acct, err := utils.GetAccountByPassword(poly, "../wallet.dat", []byte("1"))
-	assert.NoError(t, err)
+	require.NoError(t, err)
 
 	rb, _ := hex.DecodeString(redeem)
-	signer, err := NewSigner(privk, "123", txchan, acct, poly, rb)
+	ks, err := NewWalletKeyStore(poly, privk, []byte("123"))
+	require.NoError(t, err)
+	rd, err := NewRedeem(rb, ks)
+	require.NoError(t, err)
+	vdb, err := db.NewVendorDB("./temp")
+	assert.NoError(t, err)
+	defer os.RemoveAll("./temp")
+	signer, err := NewSigner([]*Redeem{rd}, queued, acct, poly, vdb, nil)
 	assert.NoError(t, err)
 
 	go signer.Signing()
@@ -81,19 +106,29 @@ func TestSigner_Signing(t *testing.T) {
 	lock, _ := hex.DecodeString("0020216a09cb8ee51da1a91ea8942552d7936c886a10b507299003661816c0e9f18b")
 	mtx.TxIn[0].SignatureScript = lock
 
-	txchan <- &utils.ToSignItem{
+	assert.NoError(t, vdb.PutQueue(1, make([]byte, 32), []*utils.ToSignItem{{
 		Mtx:  mtx,
 		Amts: amts,
-	}
+	}}))
+	queued <- struct{}{}
 
 	time.Sleep(2 * time.Second)
 }
 
 func TestSigner_getSigs(t *testing.T) {
+	skipWithout(t, privk)
 	config.BtcNetParam = &chaincfg.RegressionNetParams
-	txchan := make(chan *utils.ToSignItem, 10)
+	queued := make(chan struct{}, 1)
 	rb, _ := hex.DecodeString(redeem)
-	signer, err := NewSigner(privk, "123", txchan, nil, nil, rb)
+	ks, err := NewWalletKeyStore(nil, privk, []byte("123"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rd, err := NewRedeem(rb, ks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := NewSigner([]*Redeem{rd}, queued, nil, nil, nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -106,7 +141,7 @@ func TestSigner_getSigs(t *testing.T) {
 
 	lock, _ := hex.DecodeString("0020216a09cb8ee51da1a91ea8942552d7936c886a10b507299003661816c0e9f18b")
 	mtx.TxIn[0].SignatureScript = lock
-	sigs, err := signer.getSigs(&utils.ToSignItem{
+	sigs, err := signer.getSigs(rd, &utils.ToSignItem{
 		Amts: amts,
 		Mtx:  mtx,
 	})
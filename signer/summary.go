@@ -0,0 +1,88 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package signer
+
+import (
+	"fmt"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/polynetwork/btc-vendor-tools/config"
+	"github.com/polynetwork/btc-vendor-tools/utils"
+)
+
+type TxOutInfo struct {
+	Addr   string
+	Value  uint64
+	Change bool
+}
+
+// TxSummary is what a ToSignItem does with our money. All values are in satoshi.
+type TxSummary struct {
+	Outs     []*TxOutInfo
+	InValue  uint64
+	OutValue uint64
+	Change   uint64
+	Fee      uint64
+}
+
+// Leaving is the value not sent back to our multisig, including the fee.
+func (sum *TxSummary) Leaving() uint64 {
+	return sum.InValue - sum.Change
+}
+
+// Summarize decodes every output of item and finds the change sent back to our
+// multisig addresses.
+func (signer *Signer) Summarize(item *utils.ToSignItem) (*TxSummary, error) {
+	rd, err := signer.redeemOf(item)
+	if err != nil {
+		return nil, err
+	}
+	sum := &TxSummary{
+		Outs: make([]*TxOutInfo, len(item.Mtx.TxOut)),
+	}
+	for _, v := range item.Amts {
+		sum.InValue += v
+	}
+	for i, out := range item.Mtx.TxOut {
+		if out.Value < 0 {
+			return nil, PolicyError{
+				Reason: REASON_BAD_OUTPUT,
+				Desc:   fmt.Sprintf("output %d has negative value %d", i, out.Value),
+			}
+		}
+		info := &TxOutInfo{
+			Value: uint64(out.Value),
+		}
+		_, addrs, _, err := txscript.ExtractPkScriptAddrs(out.PkScript, config.BtcNetParam)
+		if err == nil && len(addrs) == 1 {
+			info.Addr = addrs[0].EncodeAddress()
+			info.Change = rd.isChange(info.Addr)
+		}
+		if info.Change {
+			sum.Change += info.Value
+		}
+		sum.OutValue += info.Value
+		sum.Outs[i] = info
+	}
+	if sum.OutValue > sum.InValue {
+		return nil, PolicyError{
+			Reason: REASON_BAD_OUTPUT,
+			Desc:   fmt.Sprintf("outputs value %d exceeds inputs value %d", sum.OutValue, sum.InValue),
+		}
+	}
+	sum.Fee = sum.InValue - sum.OutValue
+	return sum, nil
+}
@@ -0,0 +1,51 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package signer
+
+import (
+	"fmt"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/polynetwork/btc-vendor-tools/config"
+	"github.com/polynetwork/poly/common"
+	"github.com/polynetwork/poly/native/service/cross_chain_manager/btc"
+	putils "github.com/polynetwork/poly/native/service/utils"
+)
+
+const THRESHOLD_REACHED = "threshold reached"
+
+// getSigCount returns the number of vendors who already signed txHash on poly and the
+// number of signatures required by rd.
+func (signer *Signer) getSigCount(rd *Redeem, txHash chainhash.Hash) (int, int, error) {
+	_, _, m, err := txscript.ExtractPkScriptAddrs(rd.redeem, config.BtcNetParam)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to extract redeem: %v", err)
+	}
+	val, err := signer.poly.GetStorage(putils.CrossChainManagerContractAddress.ToHexString(),
+		append([]byte(btc.MULTI_SIGN_INFO), txHash[:]...))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get multisign info: %v", err)
+	}
+	if val == nil {
+		return 0, m, nil
+	}
+	info := &btc.MultiSignInfo{}
+	if err = info.Deserialization(common.NewZeroCopySource(val)); err != nil {
+		return 0, 0, fmt.Errorf("failed to deserialize multisign info: %v", err)
+	}
+	return len(info.MultiSignInfo), m, nil
+}
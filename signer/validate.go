@@ -0,0 +1,80 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package signer
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/polynetwork/btc-vendor-tools/utils"
+)
+
+const (
+	REASON_AMTS_MISMATCH = "amts_mismatch"
+	REASON_FOREIGN_INPUT = "foreign_input"
+)
+
+// InputError means some input of a transaction is not spending from our redeem.
+type InputError struct {
+	Reason string
+	Index  int
+	Desc   string
+}
+
+func (err InputError) Error() string {
+	return fmt.Sprintf("%s: input %d: %s", err.Reason, err.Index, err.Desc)
+}
+
+// validateInputs makes sure that every input of item spends a p2sh or p2wsh output
+// locked by the same redeem of us and that we know the amount of every input. Then
+// item is tagged with the key of that redeem.
+func (signer *Signer) validateInputs(item *utils.ToSignItem) error {
+	if len(item.Amts) != len(item.Mtx.TxIn) {
+		return InputError{
+			Reason: REASON_AMTS_MISMATCH,
+			Index:  -1,
+			Desc:   fmt.Sprintf("%d amounts for %d inputs", len(item.Amts), len(item.Mtx.TxIn)),
+		}
+	}
+	rd, err := signer.redeemOf(item)
+	if err != nil {
+		return err
+	}
+	for i, in := range item.Mtx.TxIn {
+		pks := in.SignatureScript
+		c := txscript.GetScriptClass(pks)
+		switch {
+		case c == txscript.ScriptHashTy && bytes.Equal(pks, rd.p2shScript):
+		case c == txscript.WitnessV0ScriptHashTy && bytes.Equal(pks, rd.p2wshScript):
+		default:
+			return InputError{
+				Reason: REASON_FOREIGN_INPUT,
+				Index:  i,
+				Desc:   fmt.Sprintf("script %x(%s) is not locked by redeem %s", pks, c, rd.Key),
+			}
+		}
+		if item.Amts[i] == 0 {
+			return InputError{
+				Reason: REASON_AMTS_MISMATCH,
+				Index:  i,
+				Desc:   "amount is zero",
+			}
+		}
+	}
+	item.RedeemKey = rd.Key
+	return nil
+}
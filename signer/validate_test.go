@@ -0,0 +1,75 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package signer
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/polynetwork/btc-vendor-tools/config"
+	"github.com/polynetwork/btc-vendor-tools/utils"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func getValidateSigner(t *testing.T) *Signer {
+	config.BtcNetParam = &chaincfg.RegressionNetParams
+	rb, _ := hex.DecodeString(redeem)
+	// not a key in redeem, only for checks before signing
+	privk, _ := btcec.NewPrivateKey(btcec.S256())
+	rd, err := NewRedeem(rb, NewPrivKeyStore(privk))
+	assert.NoError(t, err)
+	return newTestSigner(rd)
+}
+
+func getSwItem() *utils.ToSignItem {
+	mtx := wire.NewMsgTx(wire.TxVersion)
+	buf, _ := hex.DecodeString(swTx)
+	mtx.BtcDecode(bytes.NewBuffer(buf), wire.ProtocolVersion, wire.LatestEncoding)
+	mtx.TxIn[0].Witness = nil
+	lock, _ := hex.DecodeString("0020216a09cb8ee51da1a91ea8942552d7936c886a10b507299003661816c0e9f18b")
+	mtx.TxIn[0].SignatureScript = lock
+	return &utils.ToSignItem{
+		Mtx:  mtx,
+		Amts: []uint64{amts[0]},
+	}
+}
+
+func TestSigner_validateInputs(t *testing.T) {
+	signer := getValidateSigner(t)
+
+	item := getSwItem()
+	assert.NoError(t, signer.validateInputs(item))
+
+	item.Mtx.TxIn[0].SignatureScript = theRedeem(signer).p2shScript
+	assert.NoError(t, signer.validateInputs(item))
+
+	item.Amts = nil
+	assert.Equal(t, REASON_AMTS_MISMATCH, signer.validateInputs(item).(InputError).Reason)
+
+	item = getSwItem()
+	foreign, _ := hex.DecodeString("0020116a09cb8ee51da1a91ea8942552d7936c886a10b507299003661816c0e9f18b")
+	item.Mtx.TxIn[0].SignatureScript = foreign
+	err := signer.validateInputs(item)
+	assert.Equal(t, REASON_FOREIGN_INPUT, err.(InputError).Reason)
+	assert.Equal(t, 0, err.(InputError).Index)
+
+	item.Mtx.TxIn[0].SignatureScript = theRedeem(signer).redeem
+	assert.Equal(t, REASON_FOREIGN_INPUT, signer.validateInputs(item).(InputError).Reason)
+}
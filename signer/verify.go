@@ -0,0 +1,165 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package signer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/polynetwork/btc-vendor-tools/utils"
+)
+
+// VerifyError means a signature we made does not pass the check which poly is going
+// to do, so there is no point to send it.
+type VerifyError struct {
+	Index int
+	Desc  string
+}
+
+func (err VerifyError) Error() string {
+	return fmt.Sprintf("signature for input %d not valid: %s", err.Index, err.Desc)
+}
+
+// verifySigs checks our signature of every input of item against a sighash derived apart
+// from txscript, from the script the input spends, its amount and the tx, so a bug in how
+// getSigs builds the sighash can't hide from the check. pkScripts are the scripts of the
+// outputs spent by item, which must be the p2sh or p2wsh script of rd.
+func (signer *Signer) verifySigs(rd *Redeem, item *utils.ToSignItem, pkScripts [][]byte, sigs [][]byte) error {
+	if len(sigs) != len(pkScripts) || len(pkScripts) != len(item.Mtx.TxIn) || len(item.Amts) != len(pkScripts) {
+		return VerifyError{
+			Index: -1,
+			Desc: fmt.Sprintf("%d sigs and %d amounts for %d inputs", len(sigs), len(item.Amts),
+				len(item.Mtx.TxIn)),
+		}
+	}
+	pubk := rd.ks.PubKey()
+	if !rd.inRedeem(pubk) {
+		return VerifyError{
+			Index: -1,
+			Desc:  fmt.Sprintf("our pubkey %x is not in redeem", pubk.SerializeCompressed()),
+		}
+	}
+	if c := txscript.GetScriptClass(rd.redeem); c != txscript.MultiSigTy {
+		// the script code signed is the whole redeem only if it has no OP_CODESEPARATOR
+		return VerifyError{Index: -1, Desc: fmt.Sprintf("redeem is %s, not multisig", c)}
+	}
+
+	wsh := sha256.Sum256(rd.redeem)
+	p2sh := append(append([]byte{txscript.OP_HASH160, txscript.OP_DATA_20}, btcutil.Hash160(rd.redeem)...),
+		txscript.OP_EQUAL)
+	p2wsh := append([]byte{txscript.OP_0, txscript.OP_DATA_32}, wsh[:]...)
+	for i, pks := range pkScripts {
+		sig := sigs[i]
+		if len(sig) < 2 || txscript.SigHashType(sig[len(sig)-1]) != txscript.SigHashAll {
+			return VerifyError{Index: i, Desc: "sighash type is not SigHashAll"}
+		}
+		pSig, err := btcec.ParseDERSignature(sig[:len(sig)-1], btcec.S256())
+		if err != nil {
+			return VerifyError{Index: i, Desc: fmt.Sprintf("failed to parse signature: %v", err)}
+		}
+
+		var hash []byte
+		switch {
+		case bytes.Equal(pks, p2sh):
+			hash, err = legacySigHash(item.Mtx, i, rd.redeem)
+		case bytes.Equal(pks, p2wsh):
+			hash, err = witnessSigHash(item.Mtx, i, rd.redeem, item.Amts[i])
+		default:
+			return VerifyError{Index: i, Desc: fmt.Sprintf("script %x is not locked by the redeem", pks)}
+		}
+		if err != nil {
+			return VerifyError{Index: i, Desc: fmt.Sprintf("failed to calculate sighash: %v", err)}
+		}
+		if !pSig.Verify(hash, pubk) {
+			return VerifyError{Index: i, Desc: "signature not match with our pubkey"}
+		}
+	}
+	return nil
+}
+
+// legacySigHash returns the SigHashAll sighash of input i of mtx spending a p2sh output
+// of redeem: the tx without witness, input i carrying the redeem and other inputs empty,
+// followed by the sighash type.
+func legacySigHash(mtx *wire.MsgTx, i int, redeem []byte) ([]byte, error) {
+	tx := mtx.Copy()
+	for j, in := range tx.TxIn {
+		in.SignatureScript = nil
+		if j == i {
+			in.SignatureScript = redeem
+		}
+		in.Witness = nil
+	}
+	var buf bytes.Buffer
+	if err := tx.SerializeNoWitness(&buf); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(&buf, binary.LittleEndian, uint32(txscript.SigHashAll)); err != nil {
+		return nil, err
+	}
+	return chainhash.DoubleHashB(buf.Bytes()), nil
+}
+
+// witnessSigHash returns the SigHashAll sighash of input i of mtx spending amt from a p2wsh
+// output of redeem, as defined in BIP143.
+func witnessSigHash(mtx *wire.MsgTx, i int, redeem []byte, amt uint64) ([]byte, error) {
+	var prevouts, sequences, outputs, buf bytes.Buffer
+	for _, in := range mtx.TxIn {
+		prevouts.Write(in.PreviousOutPoint.Hash[:])
+		_ = binary.Write(&prevouts, binary.LittleEndian, in.PreviousOutPoint.Index)
+		_ = binary.Write(&sequences, binary.LittleEndian, in.Sequence)
+	}
+	for _, out := range mtx.TxOut {
+		if err := wire.WriteTxOut(&outputs, 0, 0, out); err != nil {
+			return nil, err
+		}
+	}
+	in := mtx.TxIn[i]
+	_ = binary.Write(&buf, binary.LittleEndian, mtx.Version)
+	buf.Write(chainhash.DoubleHashB(prevouts.Bytes()))
+	buf.Write(chainhash.DoubleHashB(sequences.Bytes()))
+	buf.Write(in.PreviousOutPoint.Hash[:])
+	_ = binary.Write(&buf, binary.LittleEndian, in.PreviousOutPoint.Index)
+	if err := wire.WriteVarBytes(&buf, 0, redeem); err != nil {
+		return nil, err
+	}
+	_ = binary.Write(&buf, binary.LittleEndian, amt)
+	_ = binary.Write(&buf, binary.LittleEndian, in.Sequence)
+	buf.Write(chainhash.DoubleHashB(outputs.Bytes()))
+	_ = binary.Write(&buf, binary.LittleEndian, mtx.LockTime)
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(txscript.SigHashAll))
+	return chainhash.DoubleHashB(buf.Bytes()), nil
+}
+
+func (rd *Redeem) inRedeem(pubk *btcec.PublicKey) bool {
+	pushes, err := txscript.PushedData(rd.redeem)
+	if err != nil {
+		return false
+	}
+	raw := pubk.SerializeCompressed()
+	for _, v := range pushes {
+		if string(v) == string(raw) {
+			return true
+		}
+	}
+	return false
+}
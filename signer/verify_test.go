@@ -0,0 +1,139 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package signer
+
+import (
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/polynetwork/btc-vendor-tools/config"
+	"github.com/polynetwork/btc-vendor-tools/utils"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// getKeySigner returns a signer holding a new key which is one of a 2-of-3 redeem.
+func getKeySigner(t *testing.T) *Signer {
+	config.BtcNetParam = &chaincfg.RegressionNetParams
+	privk, _ := btcec.NewPrivateKey(btcec.S256())
+	addrs := make([]*btcutil.AddressPubKey, 3)
+	for i := range addrs {
+		k := privk
+		if i > 0 {
+			k, _ = btcec.NewPrivateKey(btcec.S256())
+		}
+		addrs[i], _ = btcutil.NewAddressPubKey(k.PubKey().SerializeCompressed(), config.BtcNetParam)
+	}
+	rb, err := txscript.MultiSigScript(addrs, 2)
+	assert.NoError(t, err)
+	rd, err := NewRedeem(rb, NewPrivKeyStore(privk))
+	assert.NoError(t, err)
+	return newTestSigner(rd)
+}
+
+func newTestSigner(rds ...*Redeem) *Signer {
+	signer := &Signer{
+		redeems: make(map[string]*Redeem),
+		policy:  NewPolicy(nil, nil),
+	}
+	for _, rd := range rds {
+		signer.redeems[rd.Key] = rd
+	}
+	return signer
+}
+
+// theRedeem returns the only redeem of signer.
+func theRedeem(signer *Signer) *Redeem {
+	for _, rd := range signer.redeems {
+		return rd
+	}
+	return nil
+}
+
+// getKeyItem returns an item spending one p2sh and one p2wsh output of signer.
+func getKeyItem(signer *Signer) (*utils.ToSignItem, [][]byte) {
+	mtx := wire.NewMsgTx(wire.TxVersion)
+	mtx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{1}, 0), theRedeem(signer).p2shScript, nil))
+	mtx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{2}, 1), theRedeem(signer).p2wshScript, nil))
+	mtx.AddTxOut(wire.NewTxOut(15000, []byte{0x51}))
+	mtx.AddTxOut(wire.NewTxOut(4000, theRedeem(signer).p2wshScript))
+	return &utils.ToSignItem{
+		Mtx:  mtx,
+		Amts: []uint64{10000, 10000},
+	}, [][]byte{theRedeem(signer).p2shScript, theRedeem(signer).p2wshScript}
+}
+
+func TestSigner_verifySigs(t *testing.T) {
+	signer := getKeySigner(t)
+	item, pkScripts := getKeyItem(signer)
+	sigs, err := signer.getSigs(theRedeem(signer), item)
+	assert.NoError(t, err)
+	assert.NoError(t, signer.verifySigs(theRedeem(signer), item, pkScripts, sigs))
+
+	item.Amts[1] = 10001
+	assert.Equal(t, 1, signer.verifySigs(theRedeem(signer), item, pkScripts, sigs).(VerifyError).Index)
+	item.Amts[1] = 10000
+
+	wrong := make([]byte, len(sigs[0]))
+	copy(wrong, sigs[0])
+	wrong[len(wrong)-1] = byte(txscript.SigHashSingle)
+	assert.Equal(t, 0, signer.verifySigs(theRedeem(signer), item, pkScripts, [][]byte{wrong, sigs[1]}).(VerifyError).Index)
+
+	other := getKeySigner(t)
+	theRedeem(other).redeem = theRedeem(signer).redeem
+	assert.Equal(t, -1, other.verifySigs(theRedeem(other), item, pkScripts, sigs).(VerifyError).Index)
+	assert.Equal(t, -1, signer.verifySigs(theRedeem(signer), item, pkScripts, sigs[:1]).(VerifyError).Index)
+
+	// signed over a sighash built the wrong way, with the p2sh script as script code
+	rd := theRedeem(signer)
+	mtx := item.Mtx.Copy()
+	hash, err := txscript.CalcSignatureHash(pkScripts[0], txscript.SigHashAll, mtx, 0)
+	assert.NoError(t, err)
+	sig, err := rd.ks.Sign(hash)
+	assert.NoError(t, err)
+	wrong = append(sig, byte(txscript.SigHashAll))
+	assert.Equal(t, 0, signer.verifySigs(rd, item, pkScripts, [][]byte{wrong, sigs[1]}).(VerifyError).Index)
+
+	// spending a script not of the redeem
+	assert.Equal(t, 1, signer.verifySigs(rd, item, [][]byte{pkScripts[0], pkScripts[0]}, sigs).(VerifyError).Index)
+}
+
+func TestSigHash(t *testing.T) {
+	signer := getKeySigner(t)
+	rd := theRedeem(signer)
+	item, _ := getKeyItem(signer)
+	mtx := item.Mtx.Copy()
+	for _, in := range mtx.TxIn {
+		in.SignatureScript = nil
+	}
+
+	expected, err := txscript.CalcSignatureHash(rd.redeem, txscript.SigHashAll, mtx, 0)
+	assert.NoError(t, err)
+	hash, err := legacySigHash(item.Mtx, 0, rd.redeem)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, hash)
+
+	expected, err = txscript.CalcWitnessSigHash(rd.redeem, txscript.NewTxSigHashes(mtx), txscript.SigHashAll,
+		mtx, 1, int64(item.Amts[1]))
+	assert.NoError(t, err)
+	hash, err = witnessSigHash(item.Mtx, 1, rd.redeem, item.Amts[1])
+	assert.NoError(t, err)
+	assert.Equal(t, expected, hash)
+}
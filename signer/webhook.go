@@ -0,0 +1,191 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package signer
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/polynetwork/btc-vendor-tools/config"
+	"github.com/polynetwork/btc-vendor-tools/log"
+	"github.com/polynetwork/btc-vendor-tools/utils"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	REASON_WEBHOOK_DENIED = "webhook_denied"
+	REASON_WEBHOOK_FAILED = "webhook_failed"
+
+	WEBHOOK_ALLOW           = "allow"
+	DEFAULT_WEBHOOK_TIMEOUT = 10
+	// never read more than this from the webhook
+	MAX_WEBHOOK_RESP = 1 << 16
+)
+
+type WebhookInput struct {
+	TxId   string `json:"txid"`
+	Index  uint32 `json:"index"`
+	Addr   string `json:"addr"`
+	Script string `json:"script"`
+	Value  uint64 `json:"value"`
+}
+
+type WebhookOutput struct {
+	Addr   string `json:"addr"`
+	Script string `json:"script"`
+	Value  uint64 `json:"value"`
+	Change bool   `json:"change"`
+}
+
+// WebhookReq is what we POST to the webhook. Values are in satoshi.
+type WebhookReq struct {
+	TxHash   string           `json:"txhash"`
+	Height   uint32           `json:"height"`
+	Inputs   []*WebhookInput  `json:"inputs"`
+	Outputs  []*WebhookOutput `json:"outputs"`
+	InValue  uint64           `json:"in_value"`
+	OutValue uint64           `json:"out_value"`
+	Change   uint64           `json:"change"`
+	Fee      uint64           `json:"fee"`
+	Leaving  uint64           `json:"leaving"`
+}
+
+// WebhookResp is the answer of the webhook. Only result "allow" lets us sign.
+type WebhookResp struct {
+	Result string `json:"result"`
+	Reason string `json:"reason"`
+}
+
+// Webhook asks an external service whether a transaction can be signed.
+type Webhook struct {
+	url      string
+	failOpen bool
+	cli      *http.Client
+}
+
+func NewWebhook(url string, timeout int, failOpen bool) *Webhook {
+	if timeout <= 0 {
+		timeout = DEFAULT_WEBHOOK_TIMEOUT
+	}
+	return &Webhook{
+		url:      url,
+		failOpen: failOpen,
+		cli: &http.Client{
+			Timeout: time.Second * time.Duration(timeout),
+		},
+	}
+}
+
+// Check returns a PolicyError if the webhook doesn't allow the item. When the webhook
+// can't be reached or answers nonsense, the item is allowed only if failOpen is set.
+func (w *Webhook) Check(item *utils.ToSignItem, sum *TxSummary) error {
+	txHash := utils.GetUnsignedTxHash(item.Mtx)
+	resp, err := w.ask(newWebhookReq(item, sum))
+	if err != nil {
+		if w.failOpen {
+			log.Errorf("[Signer][ALERT] webhook failed for tx %s and it's allowed since fail-open: %v",
+				txHash.String(), err)
+			return nil
+		}
+		return PolicyError{
+			Reason: REASON_WEBHOOK_FAILED,
+			Desc:   err.Error(),
+		}
+	}
+	if resp.Result != WEBHOOK_ALLOW {
+		return PolicyError{
+			Reason: REASON_WEBHOOK_DENIED,
+			Desc:   fmt.Sprintf("webhook answers %s: %s", resp.Result, resp.Reason),
+		}
+	}
+	return nil
+}
+
+func (w *Webhook) ask(req *WebhookReq) (*WebhookResp, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %v", err)
+	}
+	hr, err := w.cli.Post(w.url, "application/json;charset=UTF-8", bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to post to webhook: %v", err)
+	}
+	defer hr.Body.Close()
+	body, err := ioutil.ReadAll(&io.LimitedReader{R: hr.Body, N: MAX_WEBHOOK_RESP})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhook response: %v", err)
+	}
+	if hr.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("webhook responses status %d: %s", hr.StatusCode, strings.TrimSpace(string(body)))
+	}
+	resp := &WebhookResp{}
+	if err = json.Unmarshal(body, resp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal webhook response: %v", err)
+	}
+	return resp, nil
+}
+
+// newWebhookReq describes item. It must be called before getSigs since the prev
+// pkScripts are still in SignatureScript.
+func newWebhookReq(item *utils.ToSignItem, sum *TxSummary) *WebhookReq {
+	txHash := utils.GetUnsignedTxHash(item.Mtx)
+	req := &WebhookReq{
+		TxHash:   txHash.String(),
+		Height:   item.Height,
+		Inputs:   make([]*WebhookInput, len(item.Mtx.TxIn)),
+		Outputs:  make([]*WebhookOutput, len(item.Mtx.TxOut)),
+		InValue:  sum.InValue,
+		OutValue: sum.OutValue,
+		Change:   sum.Change,
+		Fee:      sum.Fee,
+		Leaving:  sum.Leaving(),
+	}
+	for i, in := range item.Mtx.TxIn {
+		req.Inputs[i] = &WebhookInput{
+			TxId:   in.PreviousOutPoint.Hash.String(),
+			Index:  in.PreviousOutPoint.Index,
+			Addr:   scriptAddr(in.SignatureScript),
+			Script: hex.EncodeToString(in.SignatureScript),
+		}
+		if i < len(item.Amts) {
+			req.Inputs[i].Value = item.Amts[i]
+		}
+	}
+	for i, out := range item.Mtx.TxOut {
+		req.Outputs[i] = &WebhookOutput{
+			Addr:   sum.Outs[i].Addr,
+			Script: hex.EncodeToString(out.PkScript),
+			Value:  sum.Outs[i].Value,
+			Change: sum.Outs[i].Change,
+		}
+	}
+	return req
+}
+
+func scriptAddr(script []byte) string {
+	_, addrs, _, err := txscript.ExtractPkScriptAddrs(script, config.BtcNetParam)
+	if err != nil || len(addrs) != 1 {
+		return ""
+	}
+	return addrs[0].EncodeAddress()
+}
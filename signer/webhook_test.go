@@ -0,0 +1,55 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package signer
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebhook_Check(t *testing.T) {
+	signer := getKeySigner(t)
+	item, _ := getKeyItem(signer)
+	item.Height = 100
+	sum, err := signer.Summarize(item)
+	assert.NoError(t, err)
+
+	result := WEBHOOK_ALLOW
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &WebhookReq{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(req))
+		assert.Equal(t, uint32(100), req.Height)
+		assert.Equal(t, 2, len(req.Inputs))
+		assert.Equal(t, theRedeem(signer).p2shAddr, req.Inputs[0].Addr)
+		assert.Equal(t, uint64(1000), req.Fee)
+		assert.True(t, req.Outputs[1].Change)
+		json.NewEncoder(w).Encode(&WebhookResp{Result: result, Reason: "test"})
+	}))
+
+	assert.NoError(t, NewWebhook(srv.URL, 1, false).Check(item, sum))
+	result = "deny"
+	err = NewWebhook(srv.URL, 1, true).Check(item, sum)
+	assert.Equal(t, REASON_WEBHOOK_DENIED, err.(PolicyError).Reason)
+
+	srv.Close()
+	err = NewWebhook(srv.URL, 1, false).Check(item, sum)
+	assert.Equal(t, REASON_WEBHOOK_FAILED, err.(PolicyError).Reason)
+	assert.NoError(t, NewWebhook(srv.URL, 1, true).Check(item, sum))
+}
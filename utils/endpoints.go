@@ -0,0 +1,198 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package utils
+
+import (
+	"bytes"
+	"expvar"
+	"fmt"
+	"github.com/polynetwork/btc-vendor-tools/log"
+	sdk "github.com/polynetwork/poly-go-sdk"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	// an endpoint failing is skipped for this long at first, doubled on every failure after
+	ENDPOINT_MIN_BACKOFF = 10 * time.Second
+	ENDPOINT_MAX_BACKOFF = 5 * time.Minute
+)
+
+// health of poly endpoints by address, published at /debug/vars
+var (
+	metricEndpointUp       = expvar.NewMap("poly_endpoint_up")
+	metricEndpointFailures = expvar.NewMap("poly_endpoint_failures")
+)
+
+type endpoint struct {
+	addr      *url.URL
+	fails     int
+	downUntil time.Time
+	lastErr   error
+}
+
+// PolyEndpoints is a http.RoundTripper sending requests of the sdk to the endpoint in use, and
+// failing over to the next healthy one when it can't be reached or answers with a server error.
+type PolyEndpoints struct {
+	lock      sync.Mutex
+	endpoints []*endpoint
+	current   int
+	transport http.RoundTripper
+}
+
+func NewPolyEndpoints(addrs []string) (*PolyEndpoints, error) {
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no poly rpc address")
+	}
+	pe := &PolyEndpoints{
+		endpoints: make([]*endpoint, len(addrs)),
+		transport: &http.Transport{
+			MaxIdleConnsPerHost:   5,
+			IdleConnTimeout:       time.Second * 300,
+			ResponseHeaderTimeout: time.Second * 60,
+		},
+	}
+	for i, addr := range addrs {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return nil, fmt.Errorf("wrong poly rpc address %s: %v", addr, err)
+		}
+		pe.endpoints[i] = &endpoint{addr: u}
+		metricEndpointUp.Set(addr, ExpvarInt(1))
+	}
+	return pe, nil
+}
+
+func (pe *PolyEndpoints) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		_ = req.Body.Close()
+	}
+
+	var lastErr error
+	for _, i := range pe.order() {
+		ep := pe.endpoints[i]
+		r := req.Clone(req.Context())
+		r.URL = ep.addr
+		r.Host = ep.addr.Host
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		resp, err := pe.transport.RoundTrip(r)
+		if err == nil && resp.StatusCode >= http.StatusInternalServerError {
+			_ = resp.Body.Close()
+			err = fmt.Errorf("status %s", resp.Status)
+		}
+		if err != nil {
+			pe.fail(i, err)
+			lastErr = err
+			continue
+		}
+		pe.succeed(i)
+		return resp, nil
+	}
+	return nil, fmt.Errorf("all poly endpoints failed, last error: %v", lastErr)
+}
+
+// order returns indexes of endpoints to try, starting with the one in use, then the other
+// healthy ones, and the ones backing off at last, so we still try when all are down.
+func (pe *PolyEndpoints) order() []int {
+	pe.lock.Lock()
+	defer pe.lock.Unlock()
+
+	now := time.Now()
+	up, down := make([]int, 0, len(pe.endpoints)), make([]int, 0)
+	for j := range pe.endpoints {
+		i := (pe.current + j) % len(pe.endpoints)
+		if pe.endpoints[i].downUntil.After(now) {
+			down = append(down, i)
+		} else {
+			up = append(up, i)
+		}
+	}
+	return append(up, down...)
+}
+
+func (pe *PolyEndpoints) fail(i int, err error) {
+	pe.lock.Lock()
+	defer pe.lock.Unlock()
+
+	ep := pe.endpoints[i]
+	backoff := ENDPOINT_MIN_BACKOFF << uint(ep.fails)
+	if backoff > ENDPOINT_MAX_BACKOFF || backoff <= 0 {
+		backoff = ENDPOINT_MAX_BACKOFF
+	}
+	ep.fails++
+	ep.downUntil = time.Now().Add(backoff)
+	ep.lastErr = err
+	metricEndpointUp.Set(ep.addr.String(), ExpvarInt(0))
+	metricEndpointFailures.Add(ep.addr.String(), 1)
+	log.Warnf("[PolyEndpoints] %s failed %d times, skipped for %v: %v", ep.addr, ep.fails, backoff, err)
+}
+
+func (pe *PolyEndpoints) succeed(i int) {
+	pe.lock.Lock()
+	defer pe.lock.Unlock()
+
+	ep := pe.endpoints[i]
+	if ep.fails > 0 {
+		log.Infof("[PolyEndpoints] %s is back", ep.addr)
+	}
+	ep.fails, ep.downUntil, ep.lastErr = 0, time.Time{}, nil
+	metricEndpointUp.Set(ep.addr.String(), ExpvarInt(1))
+	if pe.current != i {
+		log.Infof("[PolyEndpoints] switch from %s to %s", pe.endpoints[pe.current].addr, ep.addr)
+		pe.current = i
+	}
+}
+
+// ExpvarInt returns an expvar.Int of v to be set into an expvar.Map.
+func ExpvarInt(v int64) *expvar.Int {
+	res := new(expvar.Int)
+	res.Set(v)
+	return res
+}
+
+// SetUpPoly points poly to rpcAddrs. With more than one address, requests fail over
+// between them, see PolyEndpoints.
+func SetUpPoly(poly *sdk.PolySdk, rpcAddrs ...string) error {
+	if len(rpcAddrs) == 0 {
+		return fmt.Errorf("no poly rpc address")
+	}
+	cli := poly.NewRpcClient().SetAddress(rpcAddrs[0])
+	if len(rpcAddrs) > 1 {
+		pe, err := NewPolyEndpoints(rpcAddrs)
+		if err != nil {
+			return err
+		}
+		cli.SetHttpClient(&http.Client{
+			Transport: pe,
+			Timeout:   time.Second * 300,
+		})
+	}
+	hdr, err := poly.GetHeaderByHeight(0)
+	if err != nil {
+		return err
+	}
+	poly.SetChainId(hdr.ChainID)
+	return nil
+}
@@ -0,0 +1,63 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPolyEndpoints(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer down.Close()
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		_, _ = w.Write(body)
+	}))
+	defer up.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	pe, err := NewPolyEndpoints([]string{closed.URL, down.URL, up.URL})
+	assert.NoError(t, err)
+	cli := &http.Client{Transport: pe}
+	for i := 0; i < 2; i++ {
+		resp, err := cli.Post("http://ignored", "application/json", strings.NewReader("ping"))
+		assert.NoError(t, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, "ping", string(body))
+		assert.Equal(t, 2, pe.current)
+	}
+	assert.Equal(t, 1, pe.endpoints[0].fails)
+	assert.Equal(t, 1, pe.endpoints[1].fails)
+	assert.Equal(t, []int{2, 0, 1}, pe.order())
+
+	up.Close()
+	_, err = cli.Post("http://ignored", "application/json", strings.NewReader("ping"))
+	assert.Error(t, err)
+	assert.Equal(t, 2, pe.endpoints[0].fails)
+
+	_, err = NewPolyEndpoints(nil)
+	assert.Error(t, err)
+}
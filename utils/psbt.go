@@ -0,0 +1,422 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"io"
+	"sort"
+)
+
+// key types of BIP174
+const (
+	PSBT_MAGIC = "psbt\xff"
+
+	PSBT_GLOBAL_UNSIGNED_TX = 0x00
+
+	PSBT_IN_NON_WITNESS_UTXO = 0x00
+	PSBT_IN_WITNESS_UTXO     = 0x01
+	PSBT_IN_PARTIAL_SIG      = 0x02
+	PSBT_IN_SIGHASH_TYPE     = 0x03
+	PSBT_IN_REDEEM_SCRIPT    = 0x04
+	PSBT_IN_WITNESS_SCRIPT   = 0x05
+
+	PSBT_OUT_REDEEM_SCRIPT  = 0x00
+	PSBT_OUT_WITNESS_SCRIPT = 0x01
+
+	PSBT_MAX_SIZE = 1 << 24
+)
+
+// PSBTKV is a key-value pair we don't know, kept as it is.
+type PSBTKV struct {
+	Key   []byte
+	Value []byte
+}
+
+type PSBTInput struct {
+	NonWitnessUtxo *wire.MsgTx
+	WitnessUtxo    *wire.TxOut
+	// hex of pubkey => signature with the sighash type
+	PartialSigs   map[string][]byte
+	SigHashType   uint32
+	RedeemScript  []byte
+	WitnessScript []byte
+	Unknowns      []*PSBTKV
+}
+
+type PSBTOutput struct {
+	RedeemScript  []byte
+	WitnessScript []byte
+	Unknowns      []*PSBTKV
+}
+
+// PSBT is a partially signed bitcoin transaction of BIP174.
+type PSBT struct {
+	Tx       *wire.MsgTx
+	Inputs   []*PSBTInput
+	Outputs  []*PSBTOutput
+	Unknowns []*PSBTKV
+}
+
+// NewPSBT converts item spending p2wsh outputs of redeem to a PSBT. BIP174 requires the whole
+// previous transaction for inputs spending p2sh, which the vendor doesn't know, so items with
+// p2sh inputs are refused.
+func NewPSBT(item *ToSignItem, redeem []byte) (*PSBT, error) {
+	if len(item.Amts) != len(item.Mtx.TxIn) {
+		return nil, fmt.Errorf("[NewPSBT] %d amounts for %d inputs", len(item.Amts), len(item.Mtx.TxIn))
+	}
+	p2sh, p2wsh, err := getRedeemScripts(redeem)
+	if err != nil {
+		return nil, fmt.Errorf("[NewPSBT] %v", err)
+	}
+	p := &PSBT{
+		Tx:      item.Mtx.Copy(),
+		Inputs:  make([]*PSBTInput, len(item.Mtx.TxIn)),
+		Outputs: make([]*PSBTOutput, len(item.Mtx.TxOut)),
+	}
+	for i, in := range p.Tx.TxIn {
+		pi := &PSBTInput{
+			WitnessUtxo: wire.NewTxOut(int64(item.Amts[i]), in.SignatureScript),
+			PartialSigs: make(map[string][]byte),
+			SigHashType: uint32(txscript.SigHashAll),
+		}
+		switch {
+		case bytes.Equal(in.SignatureScript, p2sh):
+			return nil, fmt.Errorf("[NewPSBT] input %d spends p2sh, which needs the previous tx "+
+				"as non-witness utxo and it's unknown to the vendor", i)
+		case bytes.Equal(in.SignatureScript, p2wsh):
+			pi.WitnessScript = redeem
+		default:
+			return nil, fmt.Errorf("[NewPSBT] input %d is not locked by the redeem", i)
+		}
+		in.SignatureScript = nil
+		in.Witness = nil
+		p.Inputs[i] = pi
+	}
+	for i, out := range p.Tx.TxOut {
+		po := &PSBTOutput{}
+		switch {
+		case bytes.Equal(out.PkScript, p2sh):
+			po.RedeemScript = redeem
+		case bytes.Equal(out.PkScript, p2wsh):
+			po.WitnessScript = redeem
+		}
+		p.Outputs[i] = po
+	}
+	return p, nil
+}
+
+// ToSignItem returns the item to sign with the previous pkScripts as signature scripts,
+// the same as items from poly.
+func (p *PSBT) ToSignItem() (*ToSignItem, error) {
+	item := &ToSignItem{
+		Mtx:  p.Tx.Copy(),
+		Amts: make([]uint64, len(p.Inputs)),
+	}
+	for i, pi := range p.Inputs {
+		in := item.Mtx.TxIn[i]
+		var prev *wire.TxOut
+		if pi.NonWitnessUtxo != nil {
+			if pi.NonWitnessUtxo.TxHash() != in.PreviousOutPoint.Hash {
+				return nil, fmt.Errorf("[ToSignItem] non-witness utxo of input %d is not the previous tx", i)
+			}
+			if int(in.PreviousOutPoint.Index) >= len(pi.NonWitnessUtxo.TxOut) {
+				return nil, fmt.Errorf("[ToSignItem] previous output of input %d not found", i)
+			}
+			prev = pi.NonWitnessUtxo.TxOut[in.PreviousOutPoint.Index]
+			if pi.WitnessUtxo != nil && (pi.WitnessUtxo.Value != prev.Value ||
+				!bytes.Equal(pi.WitnessUtxo.PkScript, prev.PkScript)) {
+				return nil, fmt.Errorf("[ToSignItem] utxos of input %d not match", i)
+			}
+		} else if pi.WitnessUtxo != nil {
+			prev = pi.WitnessUtxo
+		} else {
+			return nil, fmt.Errorf("[ToSignItem] no utxo for input %d", i)
+		}
+		if prev.Value < 0 {
+			return nil, fmt.Errorf("[ToSignItem] negative value of input %d", i)
+		}
+		item.Amts[i] = uint64(prev.Value)
+		in.SignatureScript = prev.PkScript
+		in.Witness = nil
+	}
+	return item, nil
+}
+
+func (p *PSBT) Serialize() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(PSBT_MAGIC)
+
+	var tx bytes.Buffer
+	if err := p.Tx.SerializeNoWitness(&tx); err != nil {
+		return nil, err
+	}
+	if err := writePSBTKV(&buf, []byte{PSBT_GLOBAL_UNSIGNED_TX}, tx.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := writePSBTMapEnd(&buf, p.Unknowns); err != nil {
+		return nil, err
+	}
+
+	for _, pi := range p.Inputs {
+		if pi.NonWitnessUtxo != nil {
+			var prev bytes.Buffer
+			if err := pi.NonWitnessUtxo.Serialize(&prev); err != nil {
+				return nil, err
+			}
+			if err := writePSBTKV(&buf, []byte{PSBT_IN_NON_WITNESS_UTXO}, prev.Bytes()); err != nil {
+				return nil, err
+			}
+		}
+		if pi.WitnessUtxo != nil {
+			var out bytes.Buffer
+			if err := wire.WriteTxOut(&out, 0, 0, pi.WitnessUtxo); err != nil {
+				return nil, err
+			}
+			if err := writePSBTKV(&buf, []byte{PSBT_IN_WITNESS_UTXO}, out.Bytes()); err != nil {
+				return nil, err
+			}
+		}
+		pubks := make([]string, 0, len(pi.PartialSigs))
+		for k := range pi.PartialSigs {
+			pubks = append(pubks, k)
+		}
+		sort.Strings(pubks)
+		for _, k := range pubks {
+			raw, err := hex.DecodeString(k)
+			if err != nil {
+				return nil, fmt.Errorf("wrong pubkey %s: %v", k, err)
+			}
+			if err = writePSBTKV(&buf, append([]byte{PSBT_IN_PARTIAL_SIG}, raw...), pi.PartialSigs[k]); err != nil {
+				return nil, err
+			}
+		}
+		if pi.SigHashType != 0 {
+			val := make([]byte, 4)
+			binary.LittleEndian.PutUint32(val, pi.SigHashType)
+			if err := writePSBTKV(&buf, []byte{PSBT_IN_SIGHASH_TYPE}, val); err != nil {
+				return nil, err
+			}
+		}
+		if err := writePSBTScript(&buf, PSBT_IN_REDEEM_SCRIPT, pi.RedeemScript); err != nil {
+			return nil, err
+		}
+		if err := writePSBTScript(&buf, PSBT_IN_WITNESS_SCRIPT, pi.WitnessScript); err != nil {
+			return nil, err
+		}
+		if err := writePSBTMapEnd(&buf, pi.Unknowns); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, po := range p.Outputs {
+		if err := writePSBTScript(&buf, PSBT_OUT_REDEEM_SCRIPT, po.RedeemScript); err != nil {
+			return nil, err
+		}
+		if err := writePSBTScript(&buf, PSBT_OUT_WITNESS_SCRIPT, po.WitnessScript); err != nil {
+			return nil, err
+		}
+		if err := writePSBTMapEnd(&buf, po.Unknowns); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func (p *PSBT) Deserialize(raw []byte) error {
+	if len(raw) > PSBT_MAX_SIZE {
+		return fmt.Errorf("psbt too large")
+	}
+	if !bytes.HasPrefix(raw, []byte(PSBT_MAGIC)) {
+		return fmt.Errorf("wrong magic of psbt")
+	}
+	r := bytes.NewReader(raw[len(PSBT_MAGIC):])
+
+	p.Tx, p.Unknowns = nil, nil
+	err := readPSBTMap(r, func(k, v []byte) error {
+		switch {
+		case k[0] == PSBT_GLOBAL_UNSIGNED_TX && len(k) == 1:
+			if p.Tx != nil {
+				return fmt.Errorf("duplicate unsigned tx")
+			}
+			p.Tx = wire.NewMsgTx(wire.TxVersion)
+			if err := p.Tx.DeserializeNoWitness(bytes.NewReader(v)); err != nil {
+				return fmt.Errorf("failed to decode unsigned tx: %v", err)
+			}
+		default:
+			p.Unknowns = append(p.Unknowns, &PSBTKV{k, v})
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("global: %v", err)
+	}
+	if p.Tx == nil {
+		return fmt.Errorf("no unsigned tx in psbt")
+	}
+	for i, in := range p.Tx.TxIn {
+		if len(in.SignatureScript) > 0 || len(in.Witness) > 0 {
+			return fmt.Errorf("input %d of unsigned tx is signed", i)
+		}
+	}
+
+	p.Inputs = make([]*PSBTInput, len(p.Tx.TxIn))
+	for i := range p.Inputs {
+		pi := &PSBTInput{
+			PartialSigs: make(map[string][]byte),
+		}
+		err := readPSBTMap(r, func(k, v []byte) error {
+			switch k[0] {
+			case PSBT_IN_NON_WITNESS_UTXO:
+				pi.NonWitnessUtxo = wire.NewMsgTx(wire.TxVersion)
+				return pi.NonWitnessUtxo.Deserialize(bytes.NewReader(v))
+			case PSBT_IN_WITNESS_UTXO:
+				pi.WitnessUtxo = &wire.TxOut{}
+				return readTxOut(bytes.NewReader(v), pi.WitnessUtxo)
+			case PSBT_IN_PARTIAL_SIG:
+				if len(k) != 34 && len(k) != 66 {
+					return fmt.Errorf("wrong length of pubkey")
+				}
+				pi.PartialSigs[hex.EncodeToString(k[1:])] = v
+			case PSBT_IN_SIGHASH_TYPE:
+				if len(v) != 4 {
+					return fmt.Errorf("wrong length of sighash type")
+				}
+				pi.SigHashType = binary.LittleEndian.Uint32(v)
+			case PSBT_IN_REDEEM_SCRIPT:
+				pi.RedeemScript = v
+			case PSBT_IN_WITNESS_SCRIPT:
+				pi.WitnessScript = v
+			default:
+				pi.Unknowns = append(pi.Unknowns, &PSBTKV{k, v})
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("input %d: %v", i, err)
+		}
+		p.Inputs[i] = pi
+	}
+
+	p.Outputs = make([]*PSBTOutput, len(p.Tx.TxOut))
+	for i := range p.Outputs {
+		po := &PSBTOutput{}
+		err := readPSBTMap(r, func(k, v []byte) error {
+			switch k[0] {
+			case PSBT_OUT_REDEEM_SCRIPT:
+				po.RedeemScript = v
+			case PSBT_OUT_WITNESS_SCRIPT:
+				po.WitnessScript = v
+			default:
+				po.Unknowns = append(po.Unknowns, &PSBTKV{k, v})
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("output %d: %v", i, err)
+		}
+		p.Outputs[i] = po
+	}
+	if r.Len() > 0 {
+		return fmt.Errorf("%d bytes left after psbt", r.Len())
+	}
+	return nil
+}
+
+func getRedeemScripts(redeem []byte) ([]byte, []byte, error) {
+	p2sh, p2wsh, err := GetRedeemAddrs(redeem, &chaincfg.MainNetParams)
+	if err != nil {
+		return nil, nil, err
+	}
+	p2shScript, err := txscript.PayToAddrScript(p2sh)
+	if err != nil {
+		return nil, nil, err
+	}
+	p2wshScript, err := txscript.PayToAddrScript(p2wsh)
+	if err != nil {
+		return nil, nil, err
+	}
+	return p2shScript, p2wshScript, nil
+}
+
+func writePSBTKV(w io.Writer, k, v []byte) error {
+	if err := wire.WriteVarBytes(w, 0, k); err != nil {
+		return err
+	}
+	return wire.WriteVarBytes(w, 0, v)
+}
+
+func writePSBTScript(w io.Writer, t byte, script []byte) error {
+	if len(script) == 0 {
+		return nil
+	}
+	return writePSBTKV(w, []byte{t}, script)
+}
+
+// writePSBTMapEnd writes unknown pairs and the separator of a map.
+func writePSBTMapEnd(w io.Writer, unknowns []*PSBTKV) error {
+	for _, kv := range unknowns {
+		if err := writePSBTKV(w, kv.Key, kv.Value); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write([]byte{0x00})
+	return err
+}
+
+// readPSBTMap calls f on every pair of a map until the separator. Duplicate keys are refused.
+func readPSBTMap(r *bytes.Reader, f func(k, v []byte) error) error {
+	seen := make(map[string]bool)
+	for {
+		k, err := wire.ReadVarBytes(r, 0, uint32(r.Len()), "psbt key")
+		if err != nil {
+			return err
+		}
+		if len(k) == 0 {
+			return nil
+		}
+		if seen[string(k)] {
+			return fmt.Errorf("duplicate key %x", k)
+		}
+		seen[string(k)] = true
+		v, err := wire.ReadVarBytes(r, 0, uint32(r.Len()), "psbt value")
+		if err != nil {
+			return err
+		}
+		if err = f(k, v); err != nil {
+			return err
+		}
+	}
+}
+
+func readTxOut(r *bytes.Reader, out *wire.TxOut) error {
+	if err := binary.Read(r, binary.LittleEndian, &out.Value); err != nil {
+		return err
+	}
+	script, err := wire.ReadVarBytes(r, 0, uint32(r.Len()), "pkScript")
+	if err != nil {
+		return err
+	}
+	out.PkScript = script
+	return nil
+}
@@ -0,0 +1,93 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+*/
+package utils
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// a valid psbt with a non-witness utxo from BIP174
+const BIP174_PSBT = "cHNidP8BAHUCAAAAASaBcTce3/KF6Tet7qSze3gADAVmy7OtZGQXE8pCFxv2AAAAAAD+////AtPf9QUAAAAAGXapFNDFmQPFusKGh2DpD9UhpGZap2UgiKwA4fUFAAAAABepFDVF5uM7gyxHBQ8k0+65PJwDlIvHh7MuEwAAAQD9pQEBAAAAAAECiaPHHqtNIOA3G7ukzGmPopXJRjr6Ljl/hTPMti+VZ+UBAAAAFxYAFL4Y0VKpsBIDna89p95PUzSe7LmF/////4b4qkOnHf8USIk6UwpyN+9rRgi7st0tAXHmOuxqSJC0AQAAABcWABT+Pp7xp0XpdNkCxDVZQ6vLNL1TU/////8CAMLrCwAAAAAZdqkUhc/xCX/Z4Ai7NK9wnGIZeziXikiIrHL++E4sAAAAF6kUM5cluiHv1irHU6m80GfWx6ajnQWHAkcwRAIgJxK+IuAnDzlPVoMR3HyppolwuAJf3TskAinwf4pfOiQCIAGLONfc0xTnNMkna9b7QPZzMlvEuqFEyADS8vAtsnZcASED0uFWdJQbrUqZY3LLh+GFbTZSYG2YVi/jnF6efkE/IQUCSDBFAiEA0SuFLYXc2WHS9fSrZgZU327tzHlMDDPOXMMJ/7X85Y0CIGczio4OFyXBl/saiK9Z9R5E5CVbIBZ8hoQDHAXR8lkqASECI7cr7vCWXRC+B3jv7NYfysb3mk6haTkzgHNEZPhPKrMAAAAAAAAA"
+
+func TestPSBT_Deserialize(t *testing.T) {
+	raw, err := base64.StdEncoding.DecodeString(BIP174_PSBT)
+	assert.NoError(t, err)
+	p := &PSBT{}
+	assert.NoError(t, p.Deserialize(raw))
+	assert.Equal(t, 1, len(p.Inputs))
+	assert.NotNil(t, p.Inputs[0].NonWitnessUtxo)
+	assert.Equal(t, 2, len(p.Outputs))
+	res, err := p.Serialize()
+	assert.NoError(t, err)
+	assert.Equal(t, raw, res)
+
+	item, err := p.ToSignItem()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(p.Inputs[0].NonWitnessUtxo.TxOut[0].Value), item.Amts[0])
+
+	assert.Error(t, p.Deserialize(raw[:len(raw)-1]))
+	assert.Error(t, p.Deserialize(raw[1:]))
+}
+
+func TestNewPSBT(t *testing.T) {
+	redeem, _ := hex.DecodeString("5221023ac710e73e1410718530b2686ce47f12fa3c470a9eb6085976b70b01c64c9f732102c9dc4d8f419e325bbef0fe039ed6feaf2079a2ef7b27336ddb79be2ea6e334bf2102eac9dc4c8e0a3c3ebfc7dcb1a0d2b47a3f4d6b0e5d2e3b8bd9a1f8bbcd2e4f5c53ae")
+	p2sh, p2wsh, err := getRedeemScripts(redeem)
+	assert.NoError(t, err)
+	mtx := wire.NewMsgTx(wire.TxVersion)
+	mtx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{1}, 0), p2wsh, nil))
+	mtx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{2}, 1), p2wsh, nil))
+	mtx.AddTxOut(wire.NewTxOut(15000, []byte{0x51}))
+	mtx.AddTxOut(wire.NewTxOut(4000, p2sh))
+	item := &ToSignItem{
+		Mtx:  mtx,
+		Amts: []uint64{10000, 10000},
+	}
+	p, err := NewPSBT(item, redeem)
+	assert.NoError(t, err)
+	assert.Equal(t, redeem, p.Inputs[0].WitnessScript)
+	assert.Equal(t, redeem, p.Inputs[1].WitnessScript)
+	assert.Equal(t, redeem, p.Outputs[1].RedeemScript)
+	p.Inputs[1].PartialSigs["03c9dc4d8f419e325bbef0fe039ed6feaf2079a2ef7b27336ddb79be2ea6e334bf"] = []byte{1, 2}
+
+	raw, err := p.Serialize()
+	assert.NoError(t, err)
+	res := &PSBT{}
+	assert.NoError(t, res.Deserialize(raw))
+	assert.Equal(t, p.Tx.TxHash(), res.Tx.TxHash())
+	assert.Equal(t, p.Inputs, res.Inputs)
+	assert.Equal(t, p.Outputs, res.Outputs)
+	back, err := res.ToSignItem()
+	assert.NoError(t, err)
+	assert.Equal(t, item.Amts, back.Amts)
+	assert.Equal(t, item.Mtx.TxHash(), back.Mtx.TxHash())
+	// item is not changed
+	assert.Equal(t, p2wsh, item.Mtx.TxIn[0].SignatureScript)
+
+	// previous tx of p2sh input is unknown
+	item.Mtx.TxIn[0].SignatureScript = p2sh
+	_, err = NewPSBT(item, redeem)
+	assert.Error(t, err)
+
+	item.Mtx.TxIn[0].SignatureScript = []byte{0x51}
+	_, err = NewPSBT(item, redeem)
+	assert.Error(t, err)
+}
@@ -18,14 +18,18 @@ package utils
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
 	sdk "github.com/polynetwork/poly-go-sdk"
 	"golang.org/x/crypto/ripemd160"
+	"io"
 	"time"
 )
 
@@ -38,6 +42,10 @@ const (
 type ToSignItem struct {
 	Mtx  *wire.MsgTx
 	Amts []uint64
+	// poly height when the item is captured, zero if unknown
+	Height uint32
+	// utxo key of the redeem locking the inputs, empty if unknown
+	RedeemKey string
 }
 
 func (item *ToSignItem) Serialize() ([]byte, error) {
@@ -60,6 +68,12 @@ func (item *ToSignItem) Serialize() ([]byte, error) {
 			return nil, err
 		}
 	}
+	if err := binary.Write(&buf, binary.BigEndian, item.Height); err != nil {
+		return nil, err
+	}
+	if err := writeVarBytes(&buf, []byte(item.RedeemKey)); err != nil {
+		return nil, err
+	}
 
 	return buf.Bytes(), nil
 }
@@ -94,6 +108,20 @@ func (item *ToSignItem) Deserialize(buf []byte) error {
 	}
 	item.Amts = amts
 
+	// items saved by older versions end here
+	if r.Len() > 0 {
+		if err := binary.Read(r, binary.BigEndian, &item.Height); err != nil {
+			return err
+		}
+	}
+	if r.Len() > 0 {
+		rk, err := readVarBytes(r)
+		if err != nil {
+			return err
+		}
+		item.RedeemKey = string(rk)
+	}
+
 	return nil
 }
 
@@ -187,6 +215,380 @@ func (arr SavedItemArr) Len() int {
 	return len(arr)
 }
 
+type RejectedItem struct {
+	Item         *ToSignItem
+	TimeReceived time.Time
+	Reason       string
+}
+
+func (rejected *RejectedItem) Serialize() ([]byte, error) {
+	var buf bytes.Buffer
+	raw, err := rejected.Item.Serialize()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize ToSignItem: %v", err)
+	}
+	if err := binary.Write(&buf, binary.BigEndian, uint32(len(raw))); err != nil {
+		return nil, err
+	}
+	buf.Write(raw)
+
+	t, err := rejected.TimeReceived.GobEncode()
+	if err != nil {
+		return nil, err
+	}
+	if err := binary.Write(&buf, binary.BigEndian, uint32(len(t))); err != nil {
+		return nil, err
+	}
+	buf.Write(t)
+
+	if err := binary.Write(&buf, binary.BigEndian, uint32(len(rejected.Reason))); err != nil {
+		return nil, err
+	}
+	buf.WriteString(rejected.Reason)
+	return buf.Bytes(), nil
+}
+
+func (rejected *RejectedItem) Deserialize(buf []byte) error {
+	r := bytes.NewReader(buf)
+	var lenItem uint32
+	if err := binary.Read(r, binary.BigEndian, &lenItem); err != nil {
+		return err
+	}
+	raw := make([]byte, lenItem)
+	if _, err := r.Read(raw); err != nil {
+		return err
+	}
+	item := &ToSignItem{}
+	if err := item.Deserialize(raw); err != nil {
+		return err
+	}
+	rejected.Item = item
+
+	var tr time.Time
+	var lenTr uint32
+	if err := binary.Read(r, binary.BigEndian, &lenTr); err != nil {
+		return err
+	}
+	raw = make([]byte, lenTr)
+	if _, err := r.Read(raw); err != nil {
+		return err
+	}
+	if err := tr.GobDecode(raw); err != nil {
+		return err
+	}
+	rejected.TimeReceived = tr
+
+	var lenReason uint32
+	if err := binary.Read(r, binary.BigEndian, &lenReason); err != nil {
+		return err
+	}
+	raw = make([]byte, lenReason)
+	if _, err := r.Read(raw); err != nil && lenReason > 0 {
+		return err
+	}
+	rejected.Reason = string(raw)
+
+	return nil
+}
+
+const (
+	OUTBOX_PENDING uint8 = iota
+	OUTBOX_FAILED
+	OUTBOX_SENT
+	OUTBOX_SKIPPED
+)
+
+// OutboxItem holds our signatures for a transaction until they are accepted by poly.
+type OutboxItem struct {
+	Item         *ToSignItem
+	TxHash       chainhash.Hash // hash of the tx known by poly
+	Sigs         [][]byte
+	TimeReceived time.Time
+	Status       uint8
+	Attempts     uint32
+	NextTry      time.Time
+	Err          string
+	PolyTx       string // hash of our last BtcMultiSign tx
+	SentTime     time.Time
+}
+
+func (ob *OutboxItem) Serialize() ([]byte, error) {
+	var buf bytes.Buffer
+	raw, err := ob.Item.Serialize()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize ToSignItem: %v", err)
+	}
+	if err := writeVarBytes(&buf, raw); err != nil {
+		return nil, err
+	}
+	buf.Write(ob.TxHash[:])
+	if err := binary.Write(&buf, binary.BigEndian, uint32(len(ob.Sigs))); err != nil {
+		return nil, err
+	}
+	for _, sig := range ob.Sigs {
+		if err := writeVarBytes(&buf, sig); err != nil {
+			return nil, err
+		}
+	}
+	if err := writeTime(&buf, ob.TimeReceived); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(&buf, binary.BigEndian, ob.Status); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(&buf, binary.BigEndian, ob.Attempts); err != nil {
+		return nil, err
+	}
+	if err := writeTime(&buf, ob.NextTry); err != nil {
+		return nil, err
+	}
+	if err := writeVarBytes(&buf, []byte(ob.Err)); err != nil {
+		return nil, err
+	}
+	if err := writeVarBytes(&buf, []byte(ob.PolyTx)); err != nil {
+		return nil, err
+	}
+	if err := writeTime(&buf, ob.SentTime); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (ob *OutboxItem) Deserialize(buf []byte) error {
+	r := bytes.NewReader(buf)
+	raw, err := readVarBytes(r)
+	if err != nil {
+		return err
+	}
+	item := &ToSignItem{}
+	if err := item.Deserialize(raw); err != nil {
+		return err
+	}
+	ob.Item = item
+	if _, err := io.ReadFull(r, ob.TxHash[:]); err != nil {
+		return err
+	}
+	var lenSigs uint32
+	if err := binary.Read(r, binary.BigEndian, &lenSigs); err != nil {
+		return err
+	}
+	ob.Sigs = make([][]byte, lenSigs)
+	for i := range ob.Sigs {
+		if ob.Sigs[i], err = readVarBytes(r); err != nil {
+			return err
+		}
+	}
+	if ob.TimeReceived, err = readTime(r); err != nil {
+		return err
+	}
+	if err := binary.Read(r, binary.BigEndian, &ob.Status); err != nil {
+		return err
+	}
+	if err := binary.Read(r, binary.BigEndian, &ob.Attempts); err != nil {
+		return err
+	}
+	if ob.NextTry, err = readTime(r); err != nil {
+		return err
+	}
+	if raw, err = readVarBytes(r); err != nil {
+		return err
+	}
+	ob.Err = string(raw)
+	if raw, err = readVarBytes(r); err != nil {
+		return err
+	}
+	ob.PolyTx = string(raw)
+	if ob.SentTime, err = readTime(r); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func writeVarBytes(buf *bytes.Buffer, b []byte) error {
+	if err := binary.Write(buf, binary.BigEndian, uint32(len(b))); err != nil {
+		return err
+	}
+	buf.Write(b)
+	return nil
+}
+
+func readVarBytes(r *bytes.Reader) ([]byte, error) {
+	var l uint32
+	if err := binary.Read(r, binary.BigEndian, &l); err != nil {
+		return nil, err
+	}
+	if int64(l) > r.Size() {
+		return nil, fmt.Errorf("length %d out of range", l)
+	}
+	b := make([]byte, l)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func writeTime(buf *bytes.Buffer, t time.Time) error {
+	raw, err := t.GobEncode()
+	if err != nil {
+		return err
+	}
+	return writeVarBytes(buf, raw)
+}
+
+func readTime(r *bytes.Reader) (time.Time, error) {
+	var t time.Time
+	raw, err := readVarBytes(r)
+	if err != nil {
+		return t, err
+	}
+	err = t.GobDecode(raw)
+	return t, err
+}
+
+const (
+	HELD_PENDING uint8 = iota
+	HELD_APPROVED
+	HELD_REJECTED
+)
+
+// HeldItem is a transaction waiting for a decision of the operator before signing.
+type HeldItem struct {
+	Item         *ToSignItem
+	TimeReceived time.Time
+	Status       uint8
+	Operator     string
+	DecisionTime time.Time
+	ReleaseTime  time.Time // for delayed tx, the time to sign it if no one vetoes
+	Reason       string    // why it's rejected, missing in records of older versions
+}
+
+func (held *HeldItem) Serialize() ([]byte, error) {
+	var buf bytes.Buffer
+	raw, err := held.Item.Serialize()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize ToSignItem: %v", err)
+	}
+	if err := writeVarBytes(&buf, raw); err != nil {
+		return nil, err
+	}
+	if err := writeTime(&buf, held.TimeReceived); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(&buf, binary.BigEndian, held.Status); err != nil {
+		return nil, err
+	}
+	if err := writeVarBytes(&buf, []byte(held.Operator)); err != nil {
+		return nil, err
+	}
+	if err := writeTime(&buf, held.DecisionTime); err != nil {
+		return nil, err
+	}
+	if err := writeTime(&buf, held.ReleaseTime); err != nil {
+		return nil, err
+	}
+	if err := writeVarBytes(&buf, []byte(held.Reason)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (held *HeldItem) Deserialize(buf []byte) error {
+	r := bytes.NewReader(buf)
+	raw, err := readVarBytes(r)
+	if err != nil {
+		return err
+	}
+	item := &ToSignItem{}
+	if err := item.Deserialize(raw); err != nil {
+		return err
+	}
+	held.Item = item
+	if held.TimeReceived, err = readTime(r); err != nil {
+		return err
+	}
+	if err := binary.Read(r, binary.BigEndian, &held.Status); err != nil {
+		return err
+	}
+	if raw, err = readVarBytes(r); err != nil {
+		return err
+	}
+	held.Operator = string(raw)
+	if held.DecisionTime, err = readTime(r); err != nil {
+		return err
+	}
+	if held.ReleaseTime, err = readTime(r); err != nil {
+		return err
+	}
+	if r.Len() == 0 {
+		return nil
+	}
+	if raw, err = readVarBytes(r); err != nil {
+		return err
+	}
+	held.Reason = string(raw)
+	return nil
+}
+
+// QuarantinedItem is a notify from poly which failed to be decoded, kept for inspection.
+type QuarantinedItem struct {
+	Height       uint32
+	TxHash       string
+	States       []byte
+	Reason       string
+	TimeReceived time.Time
+}
+
+func (q *QuarantinedItem) Serialize() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.BigEndian, q.Height); err != nil {
+		return nil, err
+	}
+	for _, v := range [][]byte{[]byte(q.TxHash), q.States, []byte(q.Reason)} {
+		if err := writeVarBytes(&buf, v); err != nil {
+			return nil, err
+		}
+	}
+	if err := writeTime(&buf, q.TimeReceived); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (q *QuarantinedItem) Deserialize(buf []byte) error {
+	r := bytes.NewReader(buf)
+	if err := binary.Read(r, binary.BigEndian, &q.Height); err != nil {
+		return err
+	}
+	txHash, err := readVarBytes(r)
+	if err != nil {
+		return err
+	}
+	q.TxHash = string(txHash)
+	if q.States, err = readVarBytes(r); err != nil {
+		return err
+	}
+	reason, err := readVarBytes(r)
+	if err != nil {
+		return err
+	}
+	q.Reason = string(reason)
+	if q.TimeReceived, err = readTime(r); err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetUnsignedTxHash returns the hash of mtx with all signature scripts cleared, which
+// is the key we use for a transaction in db.
+func GetUnsignedTxHash(mtx *wire.MsgTx) chainhash.Hash {
+	mtx = mtx.Copy()
+	for _, v := range mtx.TxIn {
+		v.SignatureScript = nil
+	}
+	return mtx.TxHash()
+}
+
 func GetAccountByPassword(sdk *sdk.PolySdk, path string, pwd []byte) (*sdk.Account, error) {
 	wallet, err := sdk.OpenWallet(path)
 	if err != nil {
@@ -220,12 +622,18 @@ func GetUtxoKey(scriptPk []byte) string {
 	}
 }
 
-func SetUpPoly(poly *sdk.PolySdk, rpcAddr string) error {
-	poly.NewRpcClient().SetAddress(rpcAddr)
-	hdr, err := poly.GetHeaderByHeight(0)
+// GetRedeemAddrs returns the p2sh and p2wsh addresses of a multisig redeem script.
+func GetRedeemAddrs(redeem []byte, params *chaincfg.Params) (*btcutil.AddressScriptHash,
+	*btcutil.AddressWitnessScriptHash, error) {
+	p2sh, err := btcutil.NewAddressScriptHash(redeem, params)
 	if err != nil {
-		return err
+		return nil, nil, fmt.Errorf("failed to new an AddressScriptHash object error: %v", err)
 	}
-	poly.SetChainId(hdr.ChainID)
-	return nil
+	hasher := sha256.New()
+	hasher.Write(redeem)
+	p2wsh, err := btcutil.NewAddressWitnessScriptHash(hasher.Sum(nil), params)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to new AddressWitnessScriptHash object error: %v", err)
+	}
+	return p2sh, p2wsh, nil
 }
\ No newline at end of file
@@ -31,8 +31,10 @@ func TestToSignItem_Serialize(t *testing.T) {
 	mtx := wire.NewMsgTx(wire.TxVersion)
 	mtx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, 10), nil, nil))
 	v := &ToSignItem{
-		Mtx:  mtx,
-		Amts: []uint64{100},
+		Mtx:       mtx,
+		Amts:      []uint64{100},
+		Height:    10,
+		RedeemKey: "87a9652e9b396545598c0fc72cb5a98848bf93d3",
 	}
 	raw, err := v.Serialize()
 	assert.NoError(t, err)
@@ -40,6 +42,14 @@ func TestToSignItem_Serialize(t *testing.T) {
 	v1 := &ToSignItem{}
 	err = v1.Deserialize(raw)
 	assert.NoError(t, err)
+	assert.Equal(t, uint32(10), v1.Height)
+	assert.Equal(t, v.RedeemKey, v1.RedeemKey)
+
+	// without height and redeem key
+	v2 := &ToSignItem{}
+	assert.NoError(t, v2.Deserialize(raw[:len(raw)-4-4-len(v.RedeemKey)]))
+	assert.Equal(t, uint32(0), v2.Height)
+	assert.Equal(t, "", v2.RedeemKey)
 
 	fmt.Println(v1.Mtx.TxIn[0].PreviousOutPoint.String())
 }
@@ -67,6 +77,98 @@ func TestSavedItem_Serialize(t *testing.T) {
 	fmt.Println(s1.TimeReceived.String(), s1.Item.Mtx.TxIn[0].PreviousOutPoint.String())
 }
 
+func TestRejectedItem_Serialize(t *testing.T) {
+	mtx := wire.NewMsgTx(wire.TxVersion)
+	mtx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, 10), nil, nil))
+	r := &RejectedItem{
+		TimeReceived: time.Now(),
+		Item: &ToSignItem{
+			Mtx:  mtx,
+			Amts: []uint64{100},
+		},
+		Reason: "output_count",
+	}
+
+	raw, err := r.Serialize()
+	assert.NoError(t, err)
+
+	r1 := &RejectedItem{}
+	err = r1.Deserialize(raw)
+	assert.NoError(t, err)
+	assert.Equal(t, r.Reason, r1.Reason)
+	assert.Equal(t, true, r.TimeReceived.Equal(r1.TimeReceived))
+	assert.Equal(t, mtx.TxHash(), r1.Item.Mtx.TxHash())
+}
+
+func TestOutboxItem_Serialize(t *testing.T) {
+	mtx := wire.NewMsgTx(wire.TxVersion)
+	mtx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, 10), nil, nil))
+	ob := &OutboxItem{
+		Item: &ToSignItem{
+			Mtx:  mtx,
+			Amts: []uint64{100},
+		},
+		TxHash:       chainhash.Hash{1},
+		Sigs:         [][]byte{{1, 2}, {}},
+		TimeReceived: time.Now(),
+		Status:       OUTBOX_FAILED,
+		Attempts:     3,
+		NextTry:      time.Now().Add(time.Minute),
+		Err:          "post err",
+		PolyTx:       "00ff",
+		SentTime:     time.Now(),
+	}
+	raw, err := ob.Serialize()
+	assert.NoError(t, err)
+
+	ob1 := &OutboxItem{}
+	assert.NoError(t, ob1.Deserialize(raw))
+	assert.Equal(t, ob.TxHash, ob1.TxHash)
+	assert.Equal(t, ob.Sigs, ob1.Sigs)
+	assert.Equal(t, OUTBOX_FAILED, ob1.Status)
+	assert.Equal(t, uint32(3), ob1.Attempts)
+	assert.True(t, ob.NextTry.Equal(ob1.NextTry))
+	assert.Equal(t, "post err", ob1.Err)
+	assert.Equal(t, "00ff", ob1.PolyTx)
+	assert.True(t, ob.SentTime.Equal(ob1.SentTime))
+	assert.Error(t, ob1.Deserialize(raw[:len(raw)-1]))
+}
+
+func TestHeldItem_Serialize(t *testing.T) {
+	mtx := wire.NewMsgTx(wire.TxVersion)
+	mtx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, 10), []byte{1}, nil))
+	h := &HeldItem{
+		Item: &ToSignItem{
+			Mtx:  mtx,
+			Amts: []uint64{100},
+		},
+		TimeReceived: time.Now(),
+		Status:       HELD_APPROVED,
+		Operator:     "alice",
+		DecisionTime: time.Now(),
+		ReleaseTime:  time.Now().Add(time.Hour),
+		Reason:       "vetoed",
+	}
+	raw, err := h.Serialize()
+	assert.NoError(t, err)
+
+	h1 := &HeldItem{}
+	assert.NoError(t, h1.Deserialize(raw))
+	assert.Equal(t, mtx.TxHash(), h1.Item.Mtx.TxHash())
+	assert.Equal(t, HELD_APPROVED, h1.Status)
+	assert.Equal(t, "alice", h1.Operator)
+	assert.True(t, h.DecisionTime.Equal(h1.DecisionTime))
+	assert.True(t, h.ReleaseTime.Equal(h1.ReleaseTime))
+	assert.Equal(t, "vetoed", h1.Reason)
+
+	// written by older versions without reason
+	h.Reason = ""
+	raw, _ = h.Serialize()
+	h1 = &HeldItem{}
+	assert.NoError(t, h1.Deserialize(raw[:len(raw)-4]))
+	assert.Equal(t, "", h1.Reason)
+}
+
 func TestGetAccountByPassword(t *testing.T) {
 	tx := "0100000001ce8c9ed816254a123be3fbca5a58436583116a32a1cbe11db0de68bdb4da491200000000fd5f02004730440220463bb76f43e867af12437173ce1f17187bda9e2e871dd9063bcd02c800419a28022079cfea2d4f26f4c93fb7592781e1c3f4996bb3509beebf757bbbbb9006103b8501483045022100b0922a8f61fedca065b8ca4985862cf9f92b271722c2902442f82394a7f36ddf0220262f8bd70d8f757fbcc7e447e5f1e892dfabe77e03b11eec57d6b8b0a5c0f7e70147304402200663f1745c3366cce2f7311f6ccf78ab334c94f9add7aab11454a0f19d679d7e022040a551059f353f139a8d928e0b1160f81a7316e97c88c8cbe2a806aaf1239182014830450221009b25652451fc0ec4beb4c7db3cc1e2e085fe2e28074faa9d6131710d8db6234f02206e78909eb2a937932ca3abfab8f654574421d442a63ddb876e36a5bc3d8604b601483045022100c9b1738b666e099e843adbe1922751f2a1210bd2a542adcf92760f4f424a73c802204331b6ac5233fab40bda41ed6d5a7528bf9594926ca1273cc837cb49569701c2014cf1552102dec9a415b6384ec0a9331d0cdf02020f0f1e5731c327b86e2b5a92455a289748210365b1066bcfa21987c3e207b92e309b95ca6bee5f1133cf04d6ed4ed265eafdbc21031104e387cd1a103c27fdc8a52d5c68dec25ddfb2f574fbdca405edfd8c5187de21031fdb4b44a9f20883aff505009ebc18702774c105cb04b1eecebcb294d404b1cb210387cda955196cc2b2fc0adbbbac1776f8de77b563c6d2a06a77d96457dc3d0d1f2102dd7767b6a7cc83693343ba721e0f5f4c7b4b8d85eeb7aec20d227625ec0f59d321034ad129efdab75061e8d4def08f5911495af2dae6d3e9a4b6e7aeb5186fa432fc57aeffffffff02ff120100000000001976a9145f35a2cc0318fbc17c4c479964734e7a9f8819d788aca04b000000000000220020216a09cb8ee51da1a91ea8942552d7936c886a10b507299003661816c0e9f18b00000000"
 	raw, _ := hex.DecodeString(tx)
@@ -17,7 +17,6 @@
 package service
 
 import (
-	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"github.com/btcsuite/btcd/btcec"
@@ -130,15 +129,9 @@ func (s *BtcService) GeneRedeem(pubkStr, reqStr string) ([]byte, []byte, *btcuti
 		return nil, nil, nil, nil, fmt.Errorf("failed to get a multisig-script: %v", err)
 	}
 	rk := btcutil.Hash160(r)
-	p2sh, err := btcutil.NewAddressScriptHash(r, config.BtcNetParam)
+	p2sh, p2wsh, err := utils2.GetRedeemAddrs(r, config.BtcNetParam)
 	if err != nil {
-		return nil, nil, nil, nil, fmt.Errorf("failed to new an AddressScriptHash object error: %v", err)
-	}
-	hasher := sha256.New()
-	hasher.Write(r)
-	p2wsh, err := btcutil.NewAddressWitnessScriptHash(hasher.Sum(nil), config.BtcNetParam)
-	if err != nil {
-		return nil, nil, nil, nil, fmt.Errorf("failed to new AddressWitnessScriptHash object error: %v", err)
+		return nil, nil, nil, nil, err
 	}
 	return r, rk, p2sh, p2wsh, nil
 }